@@ -0,0 +1,265 @@
+/// Escrow and settlement of mental-poker games. Players buy in to a game
+/// with a Deposit, which the server (server/settler.go) reads to seat them
+/// with one chip per MIST. The server's signer, the dealer, pays the final
+/// stacks, or a tournament's prizes, out with end_game, or one player's
+/// stack with cash_out, along with the digest of the game's shuffle and
+/// reveal transcript. Each settlement carries a key, so one resubmitted by
+/// the server is not paid twice, and destroys the deposits whose chips it
+/// pays out. A deposit no settlement took is withdrawn by its player once
+/// the game is over.
+module mental_poker::mental_poker {
+    use std::string::String;
+    use sui::balance::{Self, Balance};
+    use sui::coin::{Self, Coin};
+    use sui::event;
+    use sui::sui::SUI;
+    use sui::table::{Self, Table};
+
+    const ENotDealer: u64 = 0;
+    const EUnknownGame: u64 = 1;
+    const EGameOver: u64 = 2;
+    const EBadStakes: u64 = 3;
+    const EBadBuyIn: u64 = 4;
+    const ELengthMismatch: u64 = 5;
+    const EGameInPlay: u64 = 6;
+    const ENotCreator: u64 = 7;
+    const ESettled: u64 = 8; // checked by the server, see moveSettled
+    const EWrongDeposit: u64 = 9;
+
+    /// Every game, shared. Created on publish with the publisher as dealer.
+    public struct GameData has key {
+        id: UID,
+        dealer: address,
+        games: Table<ID, Game>,
+    }
+
+    public struct Game has store {
+        creator: address,
+        sb: u64,
+        bb: u64,
+        max_players: u64,
+        min_buy_in: u64,
+        max_buy_in: u64,
+        pool: Balance<SUI>, // every buy-in not paid out yet
+        open: u64, // total of the deposits not destroyed yet
+        over: bool,
+        proof: String, // transcript digest of the final settlement
+        settled: Table<String, bool>, // keys of the settlements paid
+    }
+
+    /// A buy-in escrowed in its game's pool, shared so the server can read it.
+    public struct Deposit has key {
+        id: UID,
+        game_id: ID,
+        player: address,
+        amount: u64,
+    }
+
+    public struct GameCreated has copy, drop {
+        game_id: ID,
+        sb: u64,
+        bb: u64,
+        max_players: u64,
+        min_buy_in: u64,
+        max_buy_in: u64,
+    }
+
+    public struct PlayerJoined has copy, drop {
+        game_id: ID,
+        player: address,
+        deposit_id: ID,
+        amount: u64,
+    }
+
+    public struct GameCancelled has copy, drop {
+        game_id: ID,
+    }
+
+    public struct GameEnded has copy, drop {
+        game_id: ID,
+        proof: String,
+    }
+
+    public struct CashedOut has copy, drop {
+        game_id: ID,
+        player: address,
+        amount: u64,
+        proof: String,
+    }
+
+    fun init(ctx: &mut TxContext) {
+        transfer::share_object(GameData {
+            id: object::new(ctx),
+            dealer: tx_context::sender(ctx),
+            games: table::new(ctx),
+        });
+    }
+
+    public fun create_game(
+        data: &mut GameData,
+        sb: u64,
+        bb: u64,
+        max_players: u64,
+        min_buy_in: u64,
+        max_buy_in: u64,
+        ctx: &mut TxContext,
+    ): ID {
+        assert!(sb > 0 && bb >= sb && max_players >= 2, EBadStakes);
+        assert!(min_buy_in >= bb && max_buy_in >= min_buy_in, EBadStakes);
+
+        // a fresh object id, so game ids are never reused
+        let uid = object::new(ctx);
+        let game_id = object::uid_to_inner(&uid);
+        object::delete(uid);
+
+        table::add(&mut data.games, game_id, Game {
+            creator: tx_context::sender(ctx),
+            sb,
+            bb,
+            max_players,
+            min_buy_in,
+            max_buy_in,
+            pool: balance::zero(),
+            open: 0,
+            over: false,
+            proof: std::string::utf8(b""),
+            settled: table::new(ctx),
+        });
+        event::emit(GameCreated { game_id, sb, bb, max_players, min_buy_in, max_buy_in });
+        game_id
+    }
+
+    /// Escrows buy_in for the sender. Rebuys are further joins.
+    public fun join(data: &mut GameData, game_id: ID, buy_in: Coin<SUI>, ctx: &mut TxContext) {
+        let game = borrow_game(data, game_id);
+        assert!(!game.over, EGameOver);
+        let amount = coin::value(&buy_in);
+        assert!(amount >= game.min_buy_in && amount <= game.max_buy_in, EBadBuyIn);
+        balance::join(&mut game.pool, coin::into_balance(buy_in));
+        game.open = game.open + amount;
+
+        let player = tx_context::sender(ctx);
+        let deposit = Deposit { id: object::new(ctx), game_id, player, amount };
+        let deposit_id = object::id(&deposit);
+        transfer::share_object(deposit);
+        event::emit(PlayerJoined { game_id, player, deposit_id, amount });
+    }
+
+    /// Closes a game nobody bought in to.
+    public fun cancel_game(data: &mut GameData, game_id: ID, ctx: &TxContext) {
+        let game = borrow_game(data, game_id);
+        assert!(!game.over, EGameOver);
+        assert!(game.creator == tx_context::sender(ctx), ENotCreator);
+        assert!(balance::value(&game.pool) == 0, EGameInPlay);
+        game.over = true;
+        event::emit(GameCancelled { game_id });
+    }
+
+    /// Pays chips[i] to players[i] and ends the game, destroying the
+    /// deposits played in it. chips are amounts of the pool: stacks at a
+    /// cash game, prizes at a tournament. What is left in the pool past the
+    /// deposits never played, the rake, goes to the dealer.
+    public fun end_game(
+        game_id: ID,
+        data: &mut GameData,
+        players: vector<address>,
+        chips: vector<u64>,
+        proof: String,
+        key: String,
+        deposits: vector<Deposit>,
+        ctx: &mut TxContext,
+    ) {
+        let dealer = data.dealer;
+        assert!(tx_context::sender(ctx) == dealer, ENotDealer);
+        let game = borrow_game(data, game_id);
+        settle(game, key);
+        consume(game, game_id, deposits, option::none());
+        pay(game, &players, &chips, ctx);
+
+        let rake = balance::value(&game.pool) - game.open;
+        if (rake > 0) {
+            transfer::public_transfer(coin::take(&mut game.pool, rake, ctx), dealer);
+        };
+        game.over = true;
+        game.proof = proof;
+        event::emit(GameEnded { game_id, proof });
+    }
+
+    /// Pays one player leaving a game that goes on without them, destroying
+    /// the deposits they played. players and chips hold a single entry.
+    public fun cash_out(
+        game_id: ID,
+        data: &mut GameData,
+        players: vector<address>,
+        chips: vector<u64>,
+        proof: String,
+        key: String,
+        deposits: vector<Deposit>,
+        ctx: &mut TxContext,
+    ) {
+        assert!(tx_context::sender(ctx) == data.dealer, ENotDealer);
+        assert!(vector::length(&players) == 1, ELengthMismatch);
+        let game = borrow_game(data, game_id);
+        settle(game, key);
+        consume(game, game_id, deposits, option::some(*vector::borrow(&players, 0)));
+        pay(game, &players, &chips, ctx);
+        event::emit(CashedOut {
+            game_id,
+            player: *vector::borrow(&players, 0),
+            amount: *vector::borrow(&chips, 0),
+            proof,
+        });
+    }
+
+    /// Gives the sender back a deposit of theirs no settlement took, once
+    /// its game is over.
+    public fun withdraw(data: &mut GameData, deposit: Deposit, ctx: &mut TxContext) {
+        let Deposit { id, game_id, player, amount } = deposit;
+        assert!(tx_context::sender(ctx) == player, EWrongDeposit);
+        let game = borrow_game(data, game_id);
+        assert!(game.over, EGameInPlay);
+        game.open = game.open - amount;
+        transfer::public_transfer(coin::take(&mut game.pool, amount, ctx), player);
+        object::delete(id);
+    }
+
+    fun borrow_game(data: &mut GameData, game_id: ID): &mut Game {
+        assert!(table::contains(&data.games, game_id), EUnknownGame);
+        table::borrow_mut(&mut data.games, game_id)
+    }
+
+    /// Records key as paid. A key paid before aborts with ESettled, even once
+    /// the game is over, so the server can tell a repeat from a failure.
+    fun settle(game: &mut Game, key: String) {
+        assert!(!table::contains(&game.settled, key), ESettled);
+        assert!(!game.over, EGameOver);
+        table::add(&mut game.settled, key, true);
+    }
+
+    /// Destroys deposits of game_id, made by player if there is one. Their
+    /// amounts stay in the pool, to pay the settlement.
+    fun consume(game: &mut Game, game_id: ID, mut deposits: vector<Deposit>, player: Option<address>) {
+        while (!vector::is_empty(&deposits)) {
+            let Deposit { id, game_id: deposit_game, player: owner, amount } = vector::pop_back(&mut deposits);
+            assert!(deposit_game == game_id, EWrongDeposit);
+            assert!(option::is_none(&player) || *option::borrow(&player) == owner, EWrongDeposit);
+            game.open = game.open - amount;
+            object::delete(id);
+        };
+        vector::destroy_empty(deposits);
+    }
+
+    fun pay(game: &mut Game, players: &vector<address>, chips: &vector<u64>, ctx: &mut TxContext) {
+        let n = vector::length(players);
+        assert!(vector::length(chips) == n, ELengthMismatch);
+        let mut i = 0;
+        while (i < n) {
+            let amount = *vector::borrow(chips, i);
+            if (amount > 0) {
+                let payout = coin::take(&mut game.pool, amount, ctx);
+                transfer::public_transfer(payout, *vector::borrow(players, i));
+            };
+            i = i + 1;
+        }
+    }
+}
@@ -0,0 +1,70 @@
+package poker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/block-vision/sui-go-sdk/models"
+)
+
+const (
+	// AuthPlain trusts Auth.Text as a display name. It never proves an address.
+	AuthPlain = "plain"
+	// AuthSui proves ownership of a Sui address by signing a server nonce.
+	AuthSui = "sui"
+)
+
+// AuthChallenge is sent to AuthSui clients. The client signs Message as a
+// Sui personal message (wallet signPersonalMessage) and replies with
+// Auth{Mechanism: "sui", Text: <serialized signature>}.
+type AuthChallenge struct {
+	Mechanism string `json:"mechanism"`
+	Nonce     string `json:"nonce"`
+	Message   string `json:"message"`
+}
+
+var errBadSignature = errors.New("signature verification failed")
+
+func newAuthChallenge() (*AuthChallenge, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	nonce := hex.EncodeToString(b)
+	return &AuthChallenge{
+		Mechanism: AuthSui,
+		Nonce:     nonce,
+		Message:   fmt.Sprintf("mental-poker login: %s", nonce),
+	}, nil
+}
+
+// challengeSui runs the AuthSui challenge-response on conn and returns the
+// Sui address that signed the challenge. If want is set, the signer must match it.
+func challengeSui(conn *Conn, want string) (string, error) {
+	challenge, err := newAuthChallenge()
+	if err != nil {
+		return "", err
+	}
+	if err := conn.WriteJSON(challenge); err != nil {
+		return "", err
+	}
+
+	resp := &Auth{}
+	if err := conn.ReadJSONTimeout(resp, readWait); err != nil {
+		return "", err
+	}
+	if resp.Mechanism != AuthSui {
+		return "", errBadSignature
+	}
+
+	address, ok, err := models.VerifyPersonalMessage(challenge.Message, resp.Text)
+	if err != nil {
+		return "", err
+	}
+	if !ok || (want != "" && want != address) {
+		return "", errBadSignature
+	}
+	return address, nil
+}
@@ -0,0 +1,106 @@
+package poker
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/block-vision/sui-go-sdk/signer"
+	"github.com/gorilla/websocket"
+)
+
+func newTestSigner(seed byte) *signer.Signer {
+	return signer.NewSigner(bytes.Repeat([]byte{seed}, 32))
+}
+
+func signChallenge(t *testing.T, s *signer.Signer, message string) *Auth {
+	t.Helper()
+	sig, err := s.SignPersonalMessageV1(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Auth{Mechanism: AuthSui, Text: sig.Signature}
+}
+
+// runChallenge runs challengeSui against a websocket client that answers
+// the challenge with respond, and returns what challengeSui returned.
+func runChallenge(t *testing.T, want string, respond func(c *AuthChallenge) *Auth) (string, error) {
+	t.Helper()
+	type result struct {
+		address string
+		err     error
+	}
+	done := make(chan result, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		conn := NewConn(ws, 8)
+		defer conn.Close()
+		address, err := challengeSui(conn, want)
+		done <- result{address, err}
+	}))
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	challenge := &AuthChallenge{}
+	if err := ws.ReadJSON(challenge); err != nil {
+		t.Fatal(err)
+	}
+	if challenge.Mechanism != AuthSui || !strings.Contains(challenge.Message, challenge.Nonce) {
+		t.Fatalf("challenge %+v", challenge)
+	}
+	if err := ws.WriteJSON(respond(challenge)); err != nil {
+		t.Fatal(err)
+	}
+	r := <-done
+	return r.address, r.err
+}
+
+func TestChallengeSui(t *testing.T) {
+	alice, bob := newTestSigner(1), newTestSigner(2)
+
+	var replay *Auth
+	for _, want := range []string{"", alice.Address} {
+		address, err := runChallenge(t, want, func(c *AuthChallenge) *Auth {
+			replay = signChallenge(t, alice, c.Message)
+			return replay
+		})
+		if err != nil || address != alice.Address {
+			t.Fatalf("want %q: signed in as %q, %v", want, address, err)
+		}
+	}
+
+	for name, c := range map[string]struct {
+		want    string
+		respond func(c *AuthChallenge) *Auth
+	}{
+		"wrong address": {bob.Address, func(c *AuthChallenge) *Auth {
+			return signChallenge(t, alice, c.Message)
+		}},
+		"tampered challenge": {"", func(c *AuthChallenge) *Auth {
+			return signChallenge(t, alice, c.Message+"0")
+		}},
+		"replayed challenge": {alice.Address, func(c *AuthChallenge) *Auth {
+			return replay
+		}},
+		"wrong mechanism": {"", func(c *AuthChallenge) *Auth {
+			auth := signChallenge(t, alice, c.Message)
+			auth.Mechanism = AuthPlain
+			return auth
+		}},
+	} {
+		if address, err := runChallenge(t, c.want, c.respond); err == nil {
+			t.Errorf("%s: signed in as %q", name, address)
+		}
+	}
+}
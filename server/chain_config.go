@@ -0,0 +1,231 @@
+package poker
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/block-vision/sui-go-sdk/constant"
+	"github.com/block-vision/sui-go-sdk/signer"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	NetworkDevnet  = "devnet"
+	NetworkTestnet = "testnet"
+	NetworkMainnet = "mainnet"
+	NetworkLocal   = "local"
+)
+
+var networkEndpoints = map[string]string{
+	NetworkDevnet:  "https://fullnode.devnet.sui.io",
+	NetworkTestnet: constant.SuiTestnetEndpoint,
+	NetworkMainnet: constant.SuiMainnetEndpoint,
+	NetworkLocal:   "http://127.0.0.1:9000",
+}
+
+// ChainConfig is the Sui network and server signer used for settlement.
+// It is read from a JSON file and POKER_* environment variables, the
+// environment taking precedence.
+type ChainConfig struct {
+	Network    string `json:"network"`
+	Endpoint   string `json:"endpoint,omitempty"` // defaults to the network's public full node
+	PackageID  string `json:"package_id"`         // published from move/
+	GameDataID string `json:"game_data_id"`
+	GasBudget  string `json:"gas_budget"`
+
+	// The signer is either a mnemonic or an encrypted keystore file
+	// (see EncryptKeystore) unlocked with KeystorePassword.
+	Mnemonic         string `json:"mnemonic,omitempty"`
+	Keystore         string `json:"keystore,omitempty"`
+	KeystorePassword string `json:"-"`
+}
+
+// LoadChainConfig reads the config file at path, if any, and applies the
+// environment on top of it. The result is validated.
+func LoadChainConfig(path string) (*ChainConfig, error) {
+	cfg := &ChainConfig{
+		Network:   NetworkTestnet,
+		GasBudget: "100000000",
+	}
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	for env, field := range map[string]*string{
+		"POKER_NETWORK":           &cfg.Network,
+		"POKER_RPC_ENDPOINT":      &cfg.Endpoint,
+		"POKER_PACKAGE_ID":        &cfg.PackageID,
+		"POKER_GAME_DATA_ID":      &cfg.GameDataID,
+		"POKER_GAS_BUDGET":        &cfg.GasBudget,
+		"POKER_MNEMONIC":          &cfg.Mnemonic,
+		"POKER_KEYSTORE":          &cfg.Keystore,
+		"POKER_KEYSTORE_PASSWORD": &cfg.KeystorePassword,
+	} {
+		if v, ok := os.LookupEnv(env); ok {
+			*field = v
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func isObjectID(id string) bool {
+	digits, ok := strings.CutPrefix(id, "0x")
+	if !ok || len(digits) == 0 || len(digits) > 64 {
+		return false
+	}
+	for _, c := range digits {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate fills in the endpoint and checks every setting, including that
+// the signer can be loaded.
+func (cfg *ChainConfig) Validate() error {
+	endpoint, ok := networkEndpoints[cfg.Network]
+	if !ok {
+		return fmt.Errorf("unknown network %q", cfg.Network)
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = endpoint
+	}
+	if !isObjectID(cfg.PackageID) {
+		return fmt.Errorf("invalid package_id %q", cfg.PackageID)
+	}
+	if !isObjectID(cfg.GameDataID) {
+		return fmt.Errorf("invalid game_data_id %q", cfg.GameDataID)
+	}
+	if n, err := strconv.ParseUint(cfg.GasBudget, 10, 64); err != nil || n == 0 {
+		return fmt.Errorf("invalid gas_budget %q", cfg.GasBudget)
+	}
+	if (cfg.Mnemonic == "") == (cfg.Keystore == "") {
+		return errors.New("exactly one of mnemonic and keystore must be set")
+	}
+	_, err := cfg.Signer()
+	return err
+}
+
+// Signer returns the server's signing account.
+func (cfg *ChainConfig) Signer() (*signer.Signer, error) {
+	mnemonic := cfg.Mnemonic
+	if cfg.Keystore != "" {
+		b, err := os.ReadFile(cfg.Keystore)
+		if err != nil {
+			return nil, err
+		}
+		if mnemonic, err = DecryptKeystore(b, cfg.KeystorePassword); err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.Keystore, err)
+		}
+	}
+	return signer.NewSignertWithMnemonic(mnemonic)
+}
+
+type keystoreFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       string `json:"salt"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+var errKeystorePassword = errors.New("wrong keystore password")
+
+func keystoreCipher(password string, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(password), salt, n, r, p, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptKeystore seals mnemonic with a key derived from password
+// (scrypt, AES-256-GCM) and returns the keystore file contents.
+func EncryptKeystore(mnemonic, password string) ([]byte, error) {
+	ks := &keystoreFile{
+		Version: 1,
+		KDF:     "scrypt",
+		N:       1 << 15,
+		R:       8,
+		P:       1,
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := keystoreCipher(password, salt, ks.N, ks.R, ks.P)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	ks.Salt = hex.EncodeToString(salt)
+	ks.Nonce = hex.EncodeToString(nonce)
+	ks.Ciphertext = hex.EncodeToString(aead.Seal(nil, nonce, []byte(mnemonic), nil))
+	return json.MarshalIndent(ks, "", "  ")
+}
+
+// DecryptKeystore opens a keystore written by EncryptKeystore.
+func DecryptKeystore(b []byte, password string) (string, error) {
+	ks := &keystoreFile{}
+	if err := json.Unmarshal(b, ks); err != nil {
+		return "", err
+	}
+	if ks.Version != 1 || ks.KDF != "scrypt" {
+		return "", fmt.Errorf("unsupported keystore version %d kdf %q", ks.Version, ks.KDF)
+	}
+	salt, err := hex.DecodeString(ks.Salt)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := hex.DecodeString(ks.Nonce)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := hex.DecodeString(ks.Ciphertext)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := keystoreCipher(password, salt, ks.N, ks.R, ks.P)
+	if err != nil {
+		return "", err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return "", errors.New("invalid keystore nonce")
+	}
+	mnemonic, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errKeystorePassword
+	}
+	return string(mnemonic), nil
+}
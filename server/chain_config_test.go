@@ -0,0 +1,64 @@
+package poker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+func TestKeystore(t *testing.T) {
+	b, err := EncryptKeystore(testMnemonic, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mnemonic, err := DecryptKeystore(b, "secret"); err != nil || mnemonic != testMnemonic {
+		t.Fatalf("decrypt: %q, %v", mnemonic, err)
+	}
+	if _, err := DecryptKeystore(b, "wrong"); err != errKeystorePassword {
+		t.Fatalf("wrong password: %v", err)
+	}
+}
+
+func TestChainConfigValidate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.keystore")
+	b, _ := EncryptKeystore(testMnemonic, "secret")
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	valid := func() *ChainConfig {
+		return &ChainConfig{
+			Network:          NetworkLocal,
+			PackageID:        "0x2",
+			GameDataID:       "0xabc",
+			GasBudget:        "1000",
+			Keystore:         path,
+			KeystorePassword: "secret",
+		}
+	}
+	cfg := valid()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Endpoint != networkEndpoints[NetworkLocal] {
+		t.Fatalf("endpoint %q", cfg.Endpoint)
+	}
+
+	for name, breakIt := range map[string]func(cfg *ChainConfig){
+		"network":  func(cfg *ChainConfig) { cfg.Network = "moonnet" },
+		"package":  func(cfg *ChainConfig) { cfg.PackageID = "" },
+		"gamedata": func(cfg *ChainConfig) { cfg.GameDataID = "0xzz" },
+		"gas":      func(cfg *ChainConfig) { cfg.GasBudget = "lots" },
+		"no key":   func(cfg *ChainConfig) { cfg.Keystore = "" },
+		"two keys": func(cfg *ChainConfig) { cfg.Mnemonic = testMnemonic },
+		"password": func(cfg *ChainConfig) { cfg.KeystorePassword = "wrong" },
+	} {
+		cfg := valid()
+		breakIt(cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: invalid config accepted", name)
+		}
+	}
+}
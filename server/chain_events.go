@@ -0,0 +1,350 @@
+package poker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"mental-poker/mental_poker"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/block-vision/sui-go-sdk/models"
+	"github.com/block-vision/sui-go-sdk/sui"
+)
+
+const (
+	EventGameCreated   = "GameCreated"
+	EventPlayerJoined  = "PlayerJoined"
+	EventGameCancelled = "GameCancelled"
+	EventGameEnded     = "GameEnded"
+)
+
+const (
+	chainPoll      = 2 * time.Second
+	chainPageLimit = 50
+)
+
+var errBadCursor = errors.New("invalid event cursor")
+
+// ChainEvent is a mental_poker module event. Which fields are set depends
+// on Type: stakes for GameCreated, player and deposit for PlayerJoined.
+type ChainEvent struct {
+	Type     string `json:"type"`
+	GameID   string `json:"game_id"`
+	SB       int    `json:"sb,omitempty"`
+	BB       int    `json:"bb,omitempty"`
+	Max      int    `json:"max_players,omitempty"`
+	MinChips int    `json:"min_buy_in,omitempty"`
+	MaxChips int    `json:"max_buy_in,omitempty"`
+	Player   string `json:"player,omitempty"`
+	Deposit  string `json:"deposit_id,omitempty"`
+	Chips    int    `json:"amount,omitempty"`
+}
+
+// EventSource delivers mental_poker events in chain order.
+type EventSource interface {
+	// Events returns the events after cursor, oldest first, and the cursor
+	// to continue from. An empty cursor starts from the first event.
+	Events(ctx context.Context, cursor string) ([]*ChainEvent, string, error)
+}
+
+// SuiEventSource queries the events of the mental_poker module.
+type SuiEventSource struct {
+	cfg *ChainConfig
+}
+
+func NewSuiEventSource(cfg *ChainConfig) *SuiEventSource {
+	return &SuiEventSource{cfg: cfg}
+}
+
+// Events returns one page of events. The cursor is "<tx digest>:<event seq>".
+func (src *SuiEventSource) Events(ctx context.Context, cursor string) ([]*ChainEvent, string, error) {
+	req := models.SuiXQueryEventsRequest{
+		SuiEventFilter: models.EventFilterByMoveModule{
+			MoveModule: models.MoveModule{
+				Package: src.cfg.PackageID,
+				Module:  "mental_poker",
+			},
+		},
+		Limit: chainPageLimit,
+	}
+	if cursor != "" {
+		digest, seq, ok := strings.Cut(cursor, ":")
+		if !ok {
+			return nil, "", errBadCursor
+		}
+		req.Cursor = models.EventId{TxDigest: digest, EventSeq: seq}
+	}
+
+	cli := sui.NewSuiClient(src.cfg.Endpoint)
+	rsp, err := cli.SuiXQueryEvents(ctx, req)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var events []*ChainEvent
+	for _, e := range rsp.Data {
+		if ev := parseSuiEvent(e); ev != nil {
+			events = append(events, ev)
+		}
+		cursor = e.Id.TxDigest + ":" + e.Id.EventSeq
+	}
+	return events, cursor, nil
+}
+
+// parseSuiEvent maps a mental_poker event to a ChainEvent, or returns nil
+// for events the server does not act on.
+func parseSuiEvent(e models.SuiEventResponse) *ChainEvent {
+	i := strings.LastIndex(e.Type, "::")
+	if i < 0 {
+		return nil
+	}
+	ev := &ChainEvent{Type: e.Type[i+2:]}
+	switch ev.Type {
+	case EventGameCreated, EventPlayerJoined, EventGameCancelled, EventGameEnded:
+	default:
+		return nil
+	}
+
+	str := func(name string) string {
+		s, _ := e.ParsedJson[name].(string)
+		return s
+	}
+	// u64 fields are JSON strings, smaller integers JSON numbers
+	num := func(name string) int {
+		switch v := e.ParsedJson[name].(type) {
+		case string:
+			n, _ := strconv.Atoi(v)
+			return n
+		case float64:
+			return int(v)
+		}
+		return 0
+	}
+	ev.GameID = str("game_id")
+	ev.SB = num("sb")
+	ev.BB = num("bb")
+	ev.Max = num("max_players")
+	ev.MinChips = num("min_buy_in")
+	ev.MaxChips = num("max_buy_in")
+	ev.Player = str("player")
+	ev.Deposit = str("deposit_id")
+	ev.Chips = num("amount")
+	return ev
+}
+
+// LocalEventSource plays back events added to it or recorded in a file of
+// JSON lines, standing in for the chain. The cursor is the number of
+// events already consumed.
+type LocalEventSource struct {
+	lock   sync.Mutex
+	events []*ChainEvent
+}
+
+// NewLocalEventSource returns a source replaying the file at path, or an
+// empty one if path is empty.
+func NewLocalEventSource(path string) (*LocalEventSource, error) {
+	src := &LocalEventSource{}
+	if path == "" {
+		return src, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		ev := &ChainEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), ev); err != nil {
+			return nil, err
+		}
+		src.events = append(src.events, ev)
+	}
+	return src, scanner.Err()
+}
+
+// Add appends events as if they had just been emitted on chain.
+func (src *LocalEventSource) Add(events ...*ChainEvent) {
+	src.lock.Lock()
+	defer src.lock.Unlock()
+
+	src.events = append(src.events, events...)
+}
+
+func (src *LocalEventSource) Events(ctx context.Context, cursor string) ([]*ChainEvent, string, error) {
+	src.lock.Lock()
+	defer src.lock.Unlock()
+
+	n := 0
+	if cursor != "" {
+		var err error
+		if n, err = strconv.Atoi(cursor); err != nil || n < 0 || n > len(src.events) {
+			return nil, "", errBadCursor
+		}
+	}
+	events := append([]*ChainEvent(nil), src.events[n:]...)
+	return events, strconv.Itoa(len(src.events)), nil
+}
+
+// ChainWatcher keeps the rooms in step with the games on chain: a room is
+// opened with the on-chain stakes when a game is created, a seat is held
+// for each player who joins it, and the room is closed when the game is
+// cancelled or ended. With a file, the watcher stores its cursor and the
+// games still open, and reopens their rooms on restart.
+type ChainWatcher struct {
+	source EventSource
+	path   string
+	cursor string
+	games  map[string]*ChainEvent // GameCreated of the open games
+}
+
+// chainState is the watcher as stored.
+type chainState struct {
+	Cursor string                 `json:"cursor"`
+	Games  map[string]*ChainEvent `json:"games"`
+}
+
+// NewChainWatcher returns a watcher reading source, stored in the file at
+// path, or starting from the first event every time if path is empty.
+func NewChainWatcher(source EventSource, path string) (*ChainWatcher, error) {
+	w := &ChainWatcher{
+		source: source,
+		path:   path,
+		games:  make(map[string]*ChainEvent),
+	}
+	if path == "" {
+		return w, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return w, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state chainState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, err
+	}
+	w.cursor = state.Cursor
+	for _, ev := range state.Games {
+		w.Apply(ev)
+	}
+	return w, nil
+}
+
+// Run applies events until ctx is done.
+func (w *ChainWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(chainPoll)
+	defer ticker.Stop()
+
+	for {
+		if err := w.Poll(ctx); err != nil {
+			log.Println("chain events", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll applies every event available from the source, saving the cursor
+// after each page.
+func (w *ChainWatcher) Poll(ctx context.Context) error {
+	for {
+		events, cursor, err := w.source.Events(ctx, w.cursor)
+		if err != nil {
+			return err
+		}
+		for _, ev := range events {
+			w.Apply(ev)
+		}
+		done := cursor == w.cursor || len(events) == 0
+		w.cursor = cursor
+		if err := w.save(); err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// save writes the cursor and the open games to the watcher's file.
+func (w *ChainWatcher) save() error {
+	if w.path == "" {
+		return nil
+	}
+	return writeJSON(w.path, &chainState{Cursor: w.cursor, Games: w.games})
+}
+
+// Apply updates the rooms for one event.
+func (w *ChainWatcher) Apply(ev *ChainEvent) {
+	if ev.GameID == "" {
+		return
+	}
+
+	switch ev.Type {
+	case EventGameCreated:
+		w.games[ev.GameID] = ev
+		if RoomExist(ev.GameID) {
+			return
+		}
+		room := NewRoom(ev.GameID, ev.Max, ev.SB, ev.BB)
+		room.MinChips = ev.MinChips
+		room.MaxChips = ev.MaxChips
+		if err := room.SetUpGame(); err != nil {
+			// the deck is set up again for every hand; until then seated
+			// players only need the game id
+			log.Println("chain events", ev.GameID, err)
+			room.game = mental_poker.NewGame(room.Id, nil, "")
+		}
+		SetRoom(room)
+	case EventPlayerJoined:
+		// the player buys in with the deposit; a rebuy finds them seated
+		room := lookupRoom(ev.GameID)
+		if room == nil {
+			return
+		}
+		if _, err := room.holdSeat(ev.Player, 0); err != nil && err != errSeated {
+			log.Println("chain events", ev.GameID, ev.Player, err)
+		}
+	case EventGameCancelled, EventGameEnded:
+		delete(w.games, ev.GameID)
+		if room := lookupRoom(ev.GameID); room != nil {
+			room.Close()
+		}
+	}
+}
+
+// Close removes everyone from the room and discards it. The chain has
+// already paid out, so nothing is settled.
+func (room *Room) Close() {
+	room.Each(0, func(o *Occupant) bool {
+		room.ledger.Transfer(room.hand, EntryCashOut, PlayerAccount(o.Id), AccountCashier, o.Chips)
+		o.Leave()
+		return true
+	})
+	for _, o := range room.spectatorList() {
+		o.Unwatch()
+	}
+
+	if RoomExist(room.Id) {
+		DelRoom(room)
+		room.exit()
+	}
+}
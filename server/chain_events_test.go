@@ -0,0 +1,141 @@
+package poker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/block-vision/sui-go-sdk/models"
+)
+
+func TestChainWatcher(t *testing.T) {
+	game := "0x" + t.Name()
+	src, _ := NewLocalEventSource("")
+	w, _ := NewChainWatcher(src, "")
+
+	src.Add(
+		&ChainEvent{Type: EventGameCreated, GameID: game, SB: 50, BB: 100, Max: 6, MinChips: 2000, MaxChips: 10000},
+		&ChainEvent{Type: EventPlayerJoined, GameID: game, Player: "0xa", Deposit: "0xd1", Chips: 5000},
+	)
+	if err := w.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	room := GetRoom(game)
+	if !RoomExist(game) || room.Id != game {
+		t.Fatal("room not created")
+	}
+	if room.SB != 50 || room.BB != 100 || room.Max != 6 || room.MinChips != 2000 || room.MaxChips != 10000 {
+		t.Fatalf("unexpected stakes %+v", room)
+	}
+	if pos := room.findSeat(0, "0xa"); pos == 0 || room.seatFree(pos, "0xb") {
+		t.Fatal("no seat held for the player who joined")
+	}
+
+	// nothing new
+	if err := w.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if GetRoom(game) != room {
+		t.Fatal("room replaced")
+	}
+
+	o := newTestOccupant("0xa", 5000)
+	o.JoinRoom(room, 5000)
+	src.Add(&ChainEvent{Type: EventGameEnded, GameID: game})
+	if err := w.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if RoomExist(game) {
+		t.Fatal("room not closed")
+	}
+	if room.N != 0 || o.Pos != 0 {
+		t.Fatal("occupant still seated")
+	}
+	if len(w.games) != 0 {
+		t.Fatal("game kept after it ended")
+	}
+}
+
+func TestChainWatcherRestart(t *testing.T) {
+	game := "0x" + t.Name()
+	path := filepath.Join(t.TempDir(), "events.json")
+	src, _ := NewLocalEventSource("")
+	src.Add(&ChainEvent{Type: EventGameCreated, GameID: game, SB: 50, BB: 100, Max: 6})
+	w, _ := NewChainWatcher(src, path)
+	if err := w.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	room := lookupRoom(game)
+	DelRoom(room) // lost with the server
+	room.exit()
+
+	w, err := NewChainWatcher(src, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened := lookupRoom(game)
+	if reopened == nil || reopened == room || reopened.BB != 100 || w.cursor != "1" {
+		t.Fatalf("room not reopened from the cursor file, cursor %q", w.cursor)
+	}
+	stopRoom(t, reopened)
+
+	// events already applied are not read again
+	src.Add(&ChainEvent{Type: EventGameCancelled, GameID: game})
+	if err := w.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if RoomExist(game) {
+		t.Fatal("room not closed")
+	}
+	if w, _ := NewChainWatcher(src, path); w.cursor != "2" || len(w.games) != 0 {
+		t.Fatalf("cursor %q, %d open games after a restart", w.cursor, len(w.games))
+	}
+}
+
+func TestLocalEventSourceReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	lines := `{"type":"GameCreated","game_id":"0x1","sb":5,"bb":10,"max_players":2}
+
+{"type":"GameCancelled","game_id":"0x1"}
+`
+	if err := os.WriteFile(path, []byte(lines), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := NewLocalEventSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, cursor, err := src.Events(context.Background(), "")
+	if err != nil || len(events) != 2 || cursor != "2" {
+		t.Fatalf("got %d events, cursor %q, %v", len(events), cursor, err)
+	}
+	if events[0].BB != 10 || events[1].Type != EventGameCancelled {
+		t.Fatalf("unexpected events %+v %+v", events[0], events[1])
+	}
+	if events, _, _ := src.Events(context.Background(), cursor); len(events) != 0 {
+		t.Fatal("events replayed after cursor")
+	}
+	if _, _, err := src.Events(context.Background(), "3"); err != errBadCursor {
+		t.Fatalf("cursor past the end: %v", err)
+	}
+}
+
+func TestParseSuiEvent(t *testing.T) {
+	ev := parseSuiEvent(models.SuiEventResponse{
+		Type: "0x2::mental_poker::GameCreated",
+		ParsedJson: map[string]interface{}{
+			"game_id":     "0x1",
+			"sb":          "500",
+			"bb":          "1000",
+			"max_players": float64(9),
+		},
+	})
+	if ev == nil || ev.GameID != "0x1" || ev.SB != 500 || ev.BB != 1000 || ev.Max != 9 {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	if parseSuiEvent(models.SuiEventResponse{Type: "0x2::mental_poker::Other"}) != nil {
+		t.Fatal("parsed an unknown event")
+	}
+}
@@ -0,0 +1,270 @@
+package poker
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Actions of chat messages, of type MsgMessage. The text is in Class.
+const (
+	ChatRoom     = "room"     // to everyone at the sender's table
+	ChatDirect   = "direct"   // to the player To at the same table
+	ChatIgnore   = "ignore"   // stop receiving chat from To
+	ChatUnignore = "unignore" // receive chat from To again
+)
+
+const (
+	maxChatLength = 200 // runes
+	chatBurst     = 5   // messages per chatWindow
+	chatWindow    = 10 * time.Second
+)
+
+var (
+	errChatEmpty   = errors.New("empty message")
+	errChatLength  = errors.New("message too long")
+	errChatRate    = errors.New("sending messages too fast")
+	errChatMuted   = errors.New("muted in this room")
+	errChatNoRoom  = errors.New("not at a table")
+	errChatNoPeer  = errors.New("player not at the table")
+	errChatBlocked = errors.New("message blocked")
+)
+
+// ChatFilter checks a chat message before it is sent and returns the text
+// to send, or an error to reject it.
+type ChatFilter func(from *Occupant, text string) (string, error)
+
+var (
+	chatLock   sync.Mutex
+	chatFilter ChatFilter
+)
+
+// SetChatFilter sets the filter every chat message goes through; nil lets
+// everything through.
+func SetChatFilter(f ChatFilter) {
+	chatLock.Lock()
+	defer chatLock.Unlock()
+
+	chatFilter = f
+}
+
+// BlockedWords returns a filter masking each of words, matched as whole
+// words regardless of case, with asterisks.
+func BlockedWords(words ...string) ChatFilter {
+	if len(words) == 0 {
+		return nil
+	}
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = regexp.QuoteMeta(w)
+	}
+	re := regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)
+	return func(from *Occupant, text string) (string, error) {
+		return re.ReplaceAllStringFunc(text, func(w string) string {
+			return strings.Repeat("*", utf8.RuneCountInString(w))
+		}), nil
+	}
+}
+
+// chatState is an occupant's chat settings and recent messages.
+type chatState struct {
+	lock    sync.Mutex
+	sent    []time.Time // within the last chatWindow
+	ignored map[string]bool
+}
+
+// allow records a message sent at now, unless the rate limit is reached.
+func (cs *chatState) allow(now time.Time) bool {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	recent := cs.sent[:0]
+	for _, t := range cs.sent {
+		if now.Sub(t) < chatWindow {
+			recent = append(recent, t)
+		}
+	}
+	cs.sent = recent
+	if len(cs.sent) >= chatBurst {
+		return false
+	}
+	cs.sent = append(cs.sent, now)
+	return true
+}
+
+func (cs *chatState) ignore(id string, ignored bool) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	if cs.ignored == nil {
+		cs.ignored = make(map[string]bool)
+	}
+	if ignored {
+		cs.ignored[id] = true
+	} else {
+		delete(cs.ignored, id)
+	}
+}
+
+func (cs *chatState) ignores(id string) bool {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	return cs.ignored[id]
+}
+
+// chatRoom returns the table o chats at: where it sits, or else watches.
+func (o *Occupant) chatRoom() *Room {
+	if room := o.Room; room != nil && room.Occupant(o.Id) != nil {
+		return room
+	}
+	return o.watching
+}
+
+// chatText checks and filters a message o wants to send.
+func (o *Occupant) chatText(room *Room, text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", errChatEmpty
+	}
+	if utf8.RuneCountInString(text) > maxChatLength {
+		return "", errChatLength
+	}
+	if room.Muted(o.Id) {
+		return "", errChatMuted
+	}
+	if !o.chat.allow(time.Now()) {
+		return "", errChatRate
+	}
+
+	chatLock.Lock()
+	filter := chatFilter
+	chatLock.Unlock()
+	if filter != nil {
+		var err error
+		if text, err = filter(o, text); err != nil {
+			return "", err
+		}
+		if strings.TrimSpace(text) == "" {
+			return "", errChatBlocked
+		}
+	}
+	return text, nil
+}
+
+// Chat sends text to everyone at o's table, seated or watching, except
+// those ignoring o, and records it in the hand history.
+func (o *Occupant) Chat(text string) error {
+	room := o.chatRoom()
+	if room == nil {
+		return errChatNoRoom
+	}
+	text, err := o.chatText(room, text)
+	if err != nil {
+		return err
+	}
+
+	message := &Message{
+		Type:   MsgMessage,
+		Action: ChatRoom,
+		From:   o.Id,
+		To:     room.Id,
+		Class:  text,
+	}
+	for _, oc := range room.Occupants {
+		if oc != nil && !oc.chat.ignores(o.Id) {
+			oc.SendMessage(message)
+		}
+	}
+	for _, oc := range room.spectatorList() {
+		if !oc.chat.ignores(o.Id) {
+			oc.SendMessage(message)
+		}
+	}
+
+	room.lock.Lock()
+	room.recordChat(o, text)
+	room.lock.Unlock()
+	return nil
+}
+
+// DirectMessage sends text to the player to at o's table, and back to o.
+func (o *Occupant) DirectMessage(to, text string) error {
+	room := o.chatRoom()
+	if room == nil {
+		return errChatNoRoom
+	}
+	peer := room.Occupant(to)
+	if peer == nil {
+		room.watchLock.Lock()
+		peer = room.spectators[to]
+		room.watchLock.Unlock()
+	}
+	if peer == nil {
+		return errChatNoPeer
+	}
+	text, err := o.chatText(room, text)
+	if err != nil {
+		return err
+	}
+
+	message := &Message{
+		Type:   MsgMessage,
+		Action: ChatDirect,
+		From:   o.Id,
+		To:     to,
+		Class:  text,
+	}
+	if !peer.chat.ignores(o.Id) {
+		peer.SendMessage(message)
+	}
+	if peer != o {
+		o.SendMessage(message)
+	}
+	return nil
+}
+
+// Ignore stops o receiving chat from the player id, or resumes it.
+func (o *Occupant) Ignore(id string, ignored bool) {
+	o.chat.ignore(id, ignored)
+}
+
+// Mute stops the player id chatting in the room, or lets it chat again.
+func (room *Room) Mute(id string, muted bool) {
+	room.watchLock.Lock()
+	defer room.watchLock.Unlock()
+
+	if muted {
+		room.muted[id] = true
+	} else {
+		delete(room.muted, id)
+	}
+}
+
+// Muted reports whether the player id may not chat in the room.
+func (room *Room) Muted(id string) bool {
+	room.watchLock.Lock()
+	defer room.watchLock.Unlock()
+
+	return room.muted[id]
+}
+
+func handleChat(o *Occupant, message *Message) {
+	var err error
+	switch message.Action {
+	case ChatRoom:
+		err = o.Chat(message.Class)
+	case ChatDirect:
+		err = o.DirectMessage(message.To, message.Class)
+	case ChatIgnore:
+		o.Ignore(message.To, true)
+	case ChatUnignore:
+		o.Ignore(message.To, false)
+	}
+	if err != nil {
+		o.SendError(3, err.Error())
+	}
+}
@@ -0,0 +1,148 @@
+package poker
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestChat(t *testing.T) {
+	room := newTestRoom(t, 1000, 1000)
+	a, b := room.Occupants[0], room.Occupants[1]
+	s := newTestOccupant("s", 0)
+	if err := s.Watch(room); err != nil {
+		t.Fatal(err)
+	}
+	received(t, s)
+
+	if err := a.Chat("  good luck  "); err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range []*Occupant{a, b, s} {
+		messages := received(t, o)
+		if len(messages) != 1 || messages[0].Type != MsgMessage || messages[0].Class != "good luck" || messages[0].From != "a" {
+			t.Fatalf("%s got %+v", o.Id, messages)
+		}
+	}
+
+	b.Ignore("a", true)
+	if err := a.Chat("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if len(received(t, b)) != 0 {
+		t.Fatal("ignored player's chat delivered")
+	}
+	b.Ignore("a", false)
+	received(t, a)
+	received(t, s)
+
+	if err := s.DirectMessage("b", "psst"); err != nil {
+		t.Fatal(err)
+	}
+	if m := received(t, b); len(m) != 1 || m[0].Action != ChatDirect || m[0].To != "b" {
+		t.Fatalf("direct message %+v", m)
+	}
+	if len(received(t, a)) != 0 || len(received(t, s)) != 1 {
+		t.Fatal("direct message sent to someone else, or not echoed")
+	}
+	if err := a.DirectMessage("nobody", "hi"); err != errChatNoPeer {
+		t.Fatalf("message to a player not at the table: %v", err)
+	}
+}
+
+func TestChatLimits(t *testing.T) {
+	room := newTestRoom(t, 1000, 1000)
+	a := room.Occupants[0]
+
+	if err := a.Chat(" "); err != errChatEmpty {
+		t.Fatalf("empty message: %v", err)
+	}
+	if err := a.Chat(strings.Repeat("x", maxChatLength+1)); err != errChatLength {
+		t.Fatalf("long message: %v", err)
+	}
+	for i := 0; i < chatBurst; i++ {
+		if err := a.Chat("spam"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Chat("spam"); err != errChatRate {
+		t.Fatalf("message over the rate limit: %v", err)
+	}
+
+	b := room.Occupants[1]
+	room.Mute("b", true)
+	if err := b.Chat("hi"); err != errChatMuted {
+		t.Fatalf("muted player chatting: %v", err)
+	}
+	room.Mute("b", false)
+	if err := b.Chat("hi"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := newTestOccupant("x", 0).Chat("hi"); err != errChatNoRoom {
+		t.Fatalf("chat away from a table: %v", err)
+	}
+}
+
+func TestChatFilter(t *testing.T) {
+	defer SetChatFilter(nil)
+	room := newTestRoom(t, 1000, 1000)
+	a, b := room.Occupants[0], room.Occupants[1]
+
+	SetChatFilter(BlockedWords("darn", "heck"))
+	if err := a.Chat("Darn it, what the HECK, darned"); err != nil {
+		t.Fatal(err)
+	}
+	if m := received(t, b); len(m) != 1 || m[0].Class != "**** it, what the ****, darned" {
+		t.Fatalf("filtered %+v", m)
+	}
+
+	errSpam := errors.New("spam")
+	SetChatFilter(func(from *Occupant, text string) (string, error) {
+		return "", errSpam
+	})
+	if err := a.Chat("buy chips"); err != errSpam {
+		t.Fatalf("rejected message: %v", err)
+	}
+}
+
+func TestChatHistory(t *testing.T) {
+	room := newTestRoom(t, 1000, 1000)
+	a, b := room.Occupants[0], room.Occupants[1]
+	a.Cards, b.Cards = cards("SA", "HA"), cards("SK", "HK")
+	room.hand = 1
+	room.remain, room.dealt = 2, 2
+	if err := a.Chat("gl"); err != nil {
+		t.Fatal(err)
+	}
+	room.history = room.newHistory()
+
+	room.betting(a.Pos, 5, EntryBlind)
+	room.betting(b.Pos, 10, EntryBlind)
+	if err := b.Chat("nice hand"); err != nil {
+		t.Fatal(err)
+	}
+	room.betting(a.Pos, -1, EntryBet)
+	room.showdown()
+	room.archiveHistory()
+
+	if err := a.Chat("gg"); err != nil {
+		t.Fatal(err)
+	}
+
+	h := room.Histories()[0]
+	if len(h.Chat) != 2 || h.Chat[0].After != 0 || h.Chat[0].Id != "a" || h.Chat[1].After != 2 || h.Chat[1].Id != "b" {
+		t.Fatalf("chat %+v", h.Chat)
+	}
+	text := h.PokerStars("")
+	if !strings.Contains(text, "b: posts big blind 10\n*** HOLE CARDS ***\nDealt to a [As Ah]\nDealt to b [Ks Kh]\nb said, \"nice hand\"\na: folds\n") ||
+		!strings.Contains(text, "a said, \"gl\"\na: posts small blind 5\n") {
+		t.Fatalf("chat missing from\n%s", text)
+	}
+
+	// chat after the hand goes in the next one
+	room.history = room.newHistory()
+	if len(room.history.Chat) != 1 || room.history.Chat[0].Text != "gg" || room.idleChat != nil {
+		t.Fatalf("chat between hands %+v", room.history.Chat)
+	}
+}
@@ -0,0 +1,298 @@
+package poker
+
+import (
+	"errors"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Protobuf encoding of Message as described by proto/poker.proto.
+
+var errProtoMessage = errors.New("malformed protobuf message")
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendInt(b []byte, num protowire.Number, v int) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(int64(v)))
+}
+
+func appendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, protowire.EncodeBool(v))
+}
+
+func appendInts(b []byte, num protowire.Number, vs []int) []byte {
+	if len(vs) == 0 {
+		return b
+	}
+	var packed []byte
+	for _, v := range vs {
+		packed = protowire.AppendVarint(packed, uint64(int64(v)))
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, packed)
+}
+
+func appendCards(b []byte, num protowire.Number, cards []Card) []byte {
+	for _, card := range cards {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendString(b, card.String())
+	}
+	return b
+}
+
+func appendEmbedded(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+// MarshalProto encodes the message as a poker.Message.
+func (message *Message) MarshalProto() []byte {
+	var b []byte
+	b = appendString(b, 1, message.Id)
+	b = appendString(b, 2, message.Type)
+	b = appendString(b, 3, message.From)
+	b = appendString(b, 4, message.To)
+	b = appendString(b, 5, message.Action)
+	b = appendString(b, 6, message.Class)
+	if message.Occupant != nil {
+		b = appendEmbedded(b, 7, message.Occupant.marshalProto())
+	}
+	if message.Room != nil {
+		b = appendEmbedded(b, 8, message.Room.marshalProto())
+	}
+	for _, room := range message.Rooms {
+		b = appendEmbedded(b, 9, room.marshalProto())
+	}
+	b = appendInt(b, 10, message.Chips)
+	for _, hand := range message.Hands {
+		var h []byte
+		h = appendInt(h, 1, hand.Pos)
+		h = appendString(h, 2, hand.Id)
+		h = appendCards(h, 3, hand.Cards)
+		h = appendInt(h, 4, hand.Hand)
+		h = appendBool(h, 5, hand.Muck)
+		b = appendEmbedded(b, 11, h)
+	}
+	if deal := message.Deal; deal != nil {
+		var d []byte
+		d = appendCards(d, 1, deal.Cards)
+		d = appendInt(d, 2, deal.Hand)
+		b = appendEmbedded(b, 12, d)
+	}
+	if bet := message.BetEvent; bet != nil {
+		b = appendEmbedded(b, 13, bet.marshalProto())
+	}
+	if prompt := message.Prompt; prompt != nil {
+		var p []byte
+		p = appendInt(p, 1, prompt.Pos)
+		p = appendInt(p, 2, prompt.Bet)
+		p = appendInt(p, 3, prompt.ToCall)
+		p = appendInt(p, 4, prompt.MinRaise)
+		p = appendInt(p, 5, prompt.MaxBet)
+		for _, option := range prompt.Options {
+			p = protowire.AppendTag(p, 6, protowire.BytesType)
+			p = protowire.AppendString(p, option)
+		}
+		b = appendEmbedded(b, 14, p)
+	}
+	if pots := message.Pots; pots != nil {
+		b = appendEmbedded(b, 15, appendInts(nil, 1, pots.Pots))
+	}
+	if message.Seq > 0 {
+		b = protowire.AppendTag(b, 16, protowire.VarintType)
+		b = protowire.AppendVarint(b, message.Seq)
+	}
+	b = appendString(b, 17, message.Deposit)
+	b = appendInt(b, 18, message.Rake)
+	if act := message.Act; act != nil {
+		b = appendEmbedded(b, 19, act.marshalProto())
+	}
+	if e := message.Error; e != nil {
+		var p []byte
+		p = appendInt(p, 1, e.Code)
+		p = appendString(p, 2, e.Err)
+		b = appendEmbedded(b, 20, p)
+	}
+	return b
+}
+
+func (room *Room) marshalProto() []byte {
+	var b []byte
+	b = appendString(b, 1, room.Id)
+	b = appendInt(b, 2, room.SB)
+	b = appendInt(b, 3, room.BB)
+	b = appendCards(b, 4, room.Cards)
+	b = appendInts(b, 5, room.Pot)
+	b = appendInt(b, 6, room.Timeout)
+	b = appendInt(b, 7, room.Button)
+	for _, o := range room.Occupants {
+		if o != nil {
+			b = appendEmbedded(b, 8, o.marshalProto())
+		}
+	}
+	b = appendInts(b, 9, room.Chips)
+	b = appendInt(b, 10, room.Bet)
+	b = appendInt(b, 11, room.N)
+	b = appendInt(b, 12, room.Max)
+	b = appendInt(b, 13, room.MaxChips)
+	b = appendInt(b, 14, room.MinChips)
+	b = appendInt(b, 15, room.spectatorCount())
+	b = appendInt(b, 16, room.Ante)
+	return b
+}
+
+func (o *Occupant) marshalProto() []byte {
+	var b []byte
+	b = appendString(b, 1, o.Id)
+	b = appendString(b, 2, o.Name)
+	b = appendString(b, 3, o.Profile)
+	b = appendInt(b, 4, o.Level)
+	b = appendInt(b, 5, o.Chips)
+	b = appendInt(b, 6, o.Pos)
+	b = appendInt(b, 7, o.Bet)
+	b = appendString(b, 8, o.Action)
+	b = appendCards(b, 9, o.Cards)
+	b = appendInt(b, 10, o.Hand)
+	b = appendBool(b, 11, o.SittingOut)
+	b = appendBool(b, 12, o.WaitBB)
+	b = appendInt(b, 13, o.Owed)
+	return b
+}
+
+func (bet *BetEvent) marshalProto() []byte {
+	var b []byte
+	b = appendInt(b, 1, bet.Pos)
+	b = appendString(b, 2, bet.Action)
+	b = appendInt(b, 3, bet.Bet)
+	b = appendInt(b, 4, bet.Chips)
+	return b
+}
+
+func (act *BetRequest) marshalProto() []byte {
+	var b []byte
+	b = appendString(b, 1, act.Action)
+	b = appendInt(b, 2, act.Amount)
+	return b
+}
+
+// protoFields calls f for each field of a protobuf message. f is given the
+// raw varint for VarintType fields and the payload for BytesType fields.
+func protoFields(b []byte, f func(num protowire.Number, v uint64, data []byte)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errProtoMessage
+		}
+		b = b[n:]
+
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return errProtoMessage
+			}
+			f(num, v, nil)
+			b = b[n:]
+		case protowire.BytesType:
+			data, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return errProtoMessage
+			}
+			f(num, 0, data)
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return errProtoMessage
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+// UnmarshalProto decodes a poker.Message sent by a client. Only the fields a
+// client may send are decoded; the rest are skipped.
+func (message *Message) UnmarshalProto(b []byte) error {
+	var err error
+	perr := protoFields(b, func(num protowire.Number, v uint64, data []byte) {
+		switch num {
+		case 1:
+			message.Id = string(data)
+		case 2:
+			message.Type = string(data)
+		case 3:
+			message.From = string(data)
+		case 4:
+			message.To = string(data)
+		case 5:
+			message.Action = string(data)
+		case 6:
+			message.Class = string(data)
+		case 8:
+			message.Room = &Room{}
+			if e := message.Room.unmarshalProto(data); e != nil {
+				err = e
+			}
+		case 10:
+			message.Chips = int(int64(v))
+		case 17:
+			message.Deposit = string(data)
+		case 19:
+			message.Act = &BetRequest{}
+			if e := message.Act.unmarshalProto(data); e != nil {
+				err = e
+			}
+		}
+	})
+	if perr != nil {
+		return perr
+	}
+	return err
+}
+
+func (room *Room) unmarshalProto(b []byte) error {
+	return protoFields(b, func(num protowire.Number, v uint64, data []byte) {
+		switch num {
+		case 1:
+			room.Id = string(data)
+		case 2:
+			room.SB = int(int64(v))
+		case 3:
+			room.BB = int(int64(v))
+		case 6:
+			room.Timeout = int(int64(v))
+		case 12:
+			room.Max = int(int64(v))
+		case 13:
+			room.MaxChips = int(int64(v))
+		case 14:
+			room.MinChips = int(int64(v))
+		}
+	})
+}
+
+func (act *BetRequest) unmarshalProto(b []byte) error {
+	return protoFields(b, func(num protowire.Number, v uint64, data []byte) {
+		switch num {
+		case 1:
+			act.Action = string(data)
+		case 2:
+			act.Amount = int(int64(v))
+		}
+	})
+}
@@ -0,0 +1,306 @@
+package poker
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var (
+	protoPackage = regexp.MustCompile(`package\s+(\w+)\s*;`)
+	protoMessage = regexp.MustCompile(`message\s+(\w+)\s*\{([^}]*)\}`)
+	protoField   = regexp.MustCompile(`^(repeated\s+)?(\w+)\s+(\w+)\s*=\s*(\d+)$`)
+
+	protoScalars = map[string]descriptorpb.FieldDescriptorProto_Type{
+		"string": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		"int64":  descriptorpb.FieldDescriptorProto_TYPE_INT64,
+		"uint64": descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+		"bool":   descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	}
+)
+
+// loadSchema builds a descriptor from proto/poker.proto, so the codec is
+// checked against the schema clients generate their code from. It only
+// understands the flat messages and field types the schema uses.
+func loadSchema(t *testing.T) protoreflect.FileDescriptor {
+	t.Helper()
+	b, err := os.ReadFile("proto/poker.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(b), "\n")
+	for i, line := range lines {
+		if j := strings.Index(line, "//"); j >= 0 {
+			lines[i] = line[:j]
+		}
+	}
+	src := strings.Join(lines, "\n")
+
+	pkg := protoPackage.FindStringSubmatch(src)
+	if pkg == nil {
+		t.Fatal("no package in poker.proto")
+	}
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("poker.proto"),
+		Package: proto.String(pkg[1]),
+		Syntax:  proto.String("proto3"),
+	}
+	for _, m := range protoMessage.FindAllStringSubmatch(src, -1) {
+		message := &descriptorpb.DescriptorProto{Name: proto.String(m[1])}
+		for _, stmt := range strings.Split(m[2], ";") {
+			stmt = strings.Join(strings.Fields(stmt), " ")
+			if stmt == "" {
+				continue
+			}
+			f := protoField.FindStringSubmatch(stmt)
+			if f == nil {
+				t.Fatalf("%s: cannot parse %q", m[1], stmt)
+			}
+			num, _ := strconv.Atoi(f[4])
+			field := &descriptorpb.FieldDescriptorProto{
+				Name:     proto.String(f[3]),
+				Number:   proto.Int32(int32(num)),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				JsonName: proto.String(f[3]),
+			}
+			if f[1] != "" {
+				field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			}
+			if typ, ok := protoScalars[f[2]]; ok {
+				field.Type = typ.Enum()
+			} else {
+				field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+				field.TypeName = proto.String("." + pkg[1] + "." + f[2])
+			}
+			message.Field = append(message.Field, field)
+		}
+		file.MessageType = append(file.MessageType, message)
+	}
+
+	fd, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fd
+}
+
+// decodeSchema decodes b as a poker.Message with the schema, failing on
+// fields the schema does not know, and returns it as protojson.
+func decodeSchema(t *testing.T, fd protoreflect.FileDescriptor, b []byte, seen map[protoreflect.FullName]bool) map[string]any {
+	t.Helper()
+	m := dynamicpb.NewMessage(fd.Messages().ByName("Message"))
+	if err := proto.Unmarshal(b, m); err != nil {
+		t.Fatal(err)
+	}
+	walkSchema(t, m, seen)
+
+	j, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v map[string]any
+	if err := json.Unmarshal(j, &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func walkSchema(t *testing.T, m protoreflect.Message, seen map[protoreflect.FullName]bool) {
+	t.Helper()
+	if len(m.GetUnknown()) > 0 {
+		t.Errorf("%s: fields not in poker.proto", m.Descriptor().FullName())
+	}
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if seen != nil {
+			seen[fd.FullName()] = true
+		}
+		if fd.Message() == nil {
+			return true
+		}
+		if fd.IsList() {
+			for i := 0; i < v.List().Len(); i++ {
+				walkSchema(t, v.List().Get(i).Message(), seen)
+			}
+		} else {
+			walkSchema(t, v.Message(), seen)
+		}
+		return true
+	})
+}
+
+func jsonValue(t *testing.T, s string) map[string]any {
+	t.Helper()
+	var v map[string]any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// fullMessage sets every field MarshalProto encodes.
+func fullMessage() *Message {
+	o := &Occupant{
+		Id: "a", Name: "Ann", Profile: "p", Level: 2, Chips: 990,
+		Pos: 1, Bet: 10, Action: ActCall, Cards: cards("SA", "HA"), Hand: 300,
+		SittingOut: true, WaitBB: true, Owed: 10,
+	}
+	return &Message{
+		Id:       "m1",
+		Type:     MsgPresence,
+		From:     "r1",
+		To:       "a",
+		Action:   ActState,
+		Class:    "c",
+		Occupant: o,
+		Room: &Room{
+			Id: "r1", SB: 5, BB: 10, Cards: cards("SK", "HK", "DK"),
+			Pot: []int{100, 40}, Timeout: 15, Button: 2,
+			Occupants: []*Occupant{o, nil}, Chips: []int{10, 20}, Bet: 20,
+			N: 2, Max: 6, MaxChips: 2000, MinChips: 100, Spectators: 1, Ante: 1,
+		},
+		Rooms:    []*Room{{Id: "r2", SB: 1, BB: 2}},
+		Chips:    -5,
+		Hands:    []*ShownHand{{Pos: 1, Id: "a", Cards: cards("SA", "HA"), Hand: 300, Muck: true}},
+		Deal:     &DealEvent{Cards: cards("SQ"), Hand: 7},
+		BetEvent: &BetEvent{Pos: 2, Action: ActRaise, Bet: 40, Chips: 960},
+		Prompt:   &ActionPrompt{Pos: 1, Bet: 40, ToCall: 30, MinRaise: 70, MaxBet: 990, Options: []string{ActFold, ActCall, ActRaise}},
+		Pots:     &PotEvent{Pots: []int{100, 40}},
+		Seq:      9,
+		Deposit:  "0xd",
+		Rake:     3,
+		Act:      &BetRequest{Action: ActRaise, Amount: 30},
+		Error:    &Error{Code: 1, Err: "x"},
+	}
+}
+
+func TestProtoSchema(t *testing.T) {
+	fd := loadSchema(t)
+	seen := map[protoreflect.FullName]bool{}
+	got := decodeSchema(t, fd, fullMessage().MarshalProto(), seen)
+
+	occupant := `{"id": "a", "name": "Ann", "profile": "p", "level": "2", "chips": "990",
+		"index": "1", "bet": "10", "action": "call", "cards": ["SA", "HA"], "hand": "300",
+		"sitout": true, "waitbb": true, "owed": "10"}`
+	want := jsonValue(t, `{
+		"id": "m1", "type": "presence", "from": "r1", "to": "a", "action": "state", "class": "c",
+		"occupant": `+occupant+`,
+		"room": {"id": "r1", "sb": "5", "bb": "10", "cards": ["SK", "HK", "DK"],
+			"pot": ["100", "40"], "timeout": "15", "button": "2", "occupants": [`+occupant+`],
+			"chips": ["10", "20"], "bet": "20", "n": "2", "max": "6", "max_chips": "2000",
+			"min_chips": "100", "spectators": "1", "ante": "1"},
+		"rooms": [{"id": "r2", "sb": "1", "bb": "2"}],
+		"chips": "-5",
+		"hands": [{"index": "1", "id": "a", "cards": ["SA", "HA"], "hand": "300", "muck": true}],
+		"deal": {"cards": ["SQ"], "hand": "7"},
+		"bet": {"index": "2", "action": "raise", "bet": "40", "chips": "960"},
+		"prompt": {"index": "1", "bet": "40", "to_call": "30", "min_raise": "70", "max_bet": "990",
+			"options": ["fold", "call", "raise"]},
+		"pots": {"pots": ["100", "40"]},
+		"seq": "9", "deposit": "0xd", "rake": "3",
+		"act": {"action": "raise", "amount": "30"},
+		"error": {"code": "1", "error": "x"}
+	}`)
+	if !reflect.DeepEqual(got, want) {
+		gj, _ := json.Marshal(got)
+		t.Fatalf("decoded with poker.proto:\n%s", gj)
+	}
+
+	// every field of the schema is written by the codec
+	messages := fd.Messages()
+	for i := 0; i < messages.Len(); i++ {
+		fields := messages.Get(i).Fields()
+		for j := 0; j < fields.Len(); j++ {
+			if name := fields.Get(j).FullName(); !seen[name] {
+				t.Errorf("%s is never encoded", name)
+			}
+		}
+	}
+}
+
+func TestProtoClientMessage(t *testing.T) {
+	fd := loadSchema(t)
+	m := dynamicpb.NewMessage(fd.Messages().ByName("Message"))
+	err := protojson.Unmarshal([]byte(`{
+		"id": "m1", "type": "message", "from": "a", "to": "b", "action": "bet", "class": "c",
+		"chips": "-5", "deposit": "0xd", "seq": "4",
+		"room": {"id": "r", "sb": 5, "bb": 10, "timeout": 15, "max": 6, "max_chips": 2000,
+			"min_chips": 100, "cards": ["SA"], "occupants": [{"id": "x"}]},
+		"act": {"action": "raise", "amount": 30},
+		"error": {"code": 1}
+	}`), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got Message
+	if err := got.UnmarshalProto(b); err != nil {
+		t.Fatal(err)
+	}
+	want := Message{
+		Id: "m1", Type: "message", From: "a", To: "b", Action: ActBet, Class: "c",
+		Chips: -5, Deposit: "0xd",
+		Room: &Room{Id: "r", SB: 5, BB: 10, Timeout: 15, Max: 6, MaxChips: 2000, MinChips: 100},
+		Act:  &BetRequest{Action: ActRaise, Amount: 30},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("decoded %+v, room %+v, act %+v", got, got.Room, got.Act)
+	}
+
+	if err := new(Message).UnmarshalProto(b[:len(b)-1]); err == nil {
+		t.Fatal("truncated message decoded")
+	}
+}
+
+func TestProtoRoundTrip(t *testing.T) {
+	for _, m := range []*Message{
+		{Type: "message", Action: ActBet, Act: &BetRequest{Amount: 20}},
+		{Type: "message", Action: ActBet, Act: &BetRequest{Action: ActFold}},
+		{Type: MsgPresence, Action: ActJoin, Class: "r1", Chips: 500, Deposit: "0xd"},
+		{Id: "m1", Type: "chat", From: "a", To: "r1", Class: "hi"},
+		{Type: MsgPresence, Action: ActSet, Room: &Room{Id: "r", SB: 5, BB: 10, Timeout: 15, Max: 6, MaxChips: 2000, MinChips: 100}},
+	} {
+		var got Message
+		if err := got.UnmarshalProto(m.MarshalProto()); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(&got, m) {
+			t.Errorf("round trip %+v: got %+v", m, got)
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	conn := &Conn{send: make(chan frame, 1), version: ProtoV2, encoding: EncodingJSON}
+	conn.WriteError(NewError(403, "forbidden"))
+	f := <-conn.send
+	if f.mt != websocket.TextMessage || string(f.payload) != `{"code":403,"error":"forbidden"}` {
+		t.Fatalf("JSON error frame %d %s", f.mt, f.payload)
+	}
+
+	conn.SetEncoding(EncodingProto)
+	conn.WriteError(NewError(403, "forbidden"))
+	f = <-conn.send
+	if f.mt != websocket.BinaryMessage {
+		t.Fatalf("proto error in a %d frame", f.mt)
+	}
+	got := decodeSchema(t, loadSchema(t), f.payload, nil)
+	if want := jsonValue(t, `{"error": {"code": "403", "error": "forbidden"}}`); !reflect.DeepEqual(got, want) {
+		t.Fatalf("proto error %v", got)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"github.com/gorilla/websocket"
 	"io/ioutil"
+	"sync"
 	"time"
 )
 
@@ -20,25 +21,70 @@ const (
 	// Send pings to peer with this period. Must be less than pongWait.
 	pingPeriod = (pongWait * 9) / 10
 
-	// Maximum message size allowed from peer.
-	maxMessageSize = 1024
+	// Maximum message size allowed from peer, in either encoding.
+	maxMessageSize = 64 << 10
 )
 
+// Messages are JSON text frames, or binary protobuf frames once a ProtoV2
+// client asks for EncodingProto. The handshake before that is agreed, and up
+// to the auth response, is always in JSON text frames.
+const (
+	EncodingJSON  = "json"
+	EncodingProto = "proto"
+)
+
+type frame struct {
+	mt      int
+	payload []byte
+}
+
 type Conn struct {
-	ws   *websocket.Conn
-	send chan []byte
+	ws       *websocket.Conn
+	send     chan frame
+	version  string
+	encoding string
+
+	lock   sync.Mutex // guards send against Close
+	closed bool
 }
 
+var errConnClosed = errors.New("connection closed")
+
 func NewConn(ws *websocket.Conn, sendBuffer int) *Conn {
 	conn := &Conn{
-		ws:   ws,
-		send: make(chan []byte, sendBuffer),
+		ws:       ws,
+		send:     make(chan frame, sendBuffer),
+		version:  ProtoV1,
+		encoding: EncodingJSON,
 	}
 	go conn.writePump()
 
 	return conn
 }
 
+// Version is the protocol version negotiated for this connection.
+func (c *Conn) Version() string {
+	return c.version
+}
+
+func (c *Conn) SetVersion(ver string) {
+	c.version = ver
+}
+
+// Encoding is the frame encoding used for messages on this connection.
+func (c *Conn) Encoding() string {
+	return c.encoding
+}
+
+// SetEncoding switches messages to binary protobuf frames. Only ProtoV2
+// connections may use EncodingProto.
+func (c *Conn) SetEncoding(encoding string) {
+	if encoding != EncodingProto || c.version != ProtoV2 {
+		encoding = EncodingJSON
+	}
+	c.encoding = encoding
+}
+
 // write writes a message with the given message type and payload.
 func (c *Conn) write(mt int, payload []byte) error {
 	return c.ws.WriteMessage(mt, payload)
@@ -59,17 +105,57 @@ func (c *Conn) ReadJSONTimeout(v interface{}, timeout time.Duration) error {
 }
 
 func (c *Conn) readJson(v interface{}) error {
-	_, r, err := c.ws.NextReader()
+	_, b, err := c.readFrame()
 	if err != nil {
 		return err
 	}
+	//fmt.Println(">>>", time.Now().Format("15:04:05"), string(b))
+
+	return json.Unmarshal(b, v)
+}
+
+func (c *Conn) readFrame() (int, []byte, error) {
+	mt, r, err := c.ws.NextReader()
+	if err != nil {
+		return 0, nil, err
+	}
 	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	return mt, b, nil
+}
+
+// ReadMessage reads a message in either a JSON text frame or, on proto
+// connections, a binary protobuf frame.
+func (c *Conn) ReadMessage(m *Message) error {
+	c.ws.SetReadDeadline(time.Time{})
+
+	mt, b, err := c.readFrame()
 	if err != nil {
 		return err
 	}
-	//fmt.Println(">>>", time.Now().Format("15:04:05"), string(b))
+	if mt == websocket.BinaryMessage && c.encoding == EncodingProto {
+		return m.UnmarshalProto(b)
+	}
+	return json.Unmarshal(b, m)
+}
 
-	return json.Unmarshal(b, v)
+// WriteMessage writes m in the connection's encoding.
+func (c *Conn) WriteMessage(m *Message) error {
+	if c.encoding == EncodingProto {
+		return c.queue(frame{mt: websocket.BinaryMessage, payload: m.MarshalProto()})
+	}
+	return c.WriteJSON(m)
+}
+
+// WriteError writes e as a JSON object, or on proto connections as a Message
+// carrying only the error.
+func (c *Conn) WriteError(e *Error) error {
+	if c.encoding == EncodingProto {
+		return c.WriteMessage(&Message{Error: e})
+	}
+	return c.WriteJSON(e)
 }
 
 func (c *Conn) WriteJSON(v interface{}) error {
@@ -78,8 +164,18 @@ func (c *Conn) WriteJSON(v interface{}) error {
 		return err
 	}
 
+	return c.queue(frame{mt: websocket.TextMessage, payload: b})
+}
+
+func (c *Conn) queue(f frame) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		return errConnClosed
+	}
 	select {
-	case c.send <- b:
+	case c.send <- f:
 		return nil
 	default:
 		//fmt.Println("buffer full")
@@ -87,9 +183,16 @@ func (c *Conn) WriteJSON(v interface{}) error {
 	}
 }
 
+// Close stops the write pump once it has sent what was queued. Later
+// writes fail.
 func (c *Conn) Close() {
-	close(c.send)
-	c.send = nil
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.closed {
+		c.closed = true
+		close(c.send)
+	}
 }
 
 // writePump pumps messages from the hub to the websocket connection.
@@ -108,7 +211,7 @@ func (c *Conn) writePump() {
 				return
 			}
 			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.write(websocket.TextMessage, message); err != nil {
+			if err := c.write(message.mt, message.payload); err != nil {
 				//log.Println(err)
 				return
 			}
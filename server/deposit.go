@@ -0,0 +1,109 @@
+package poker
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	errUnknownDeposit = errors.New("unknown deposit")
+	errDepositUsed    = errors.New("deposit already used")
+	errDepositPlayer  = errors.New("deposit belongs to another player")
+	errDepositGame    = errors.New("deposit is for another game")
+	errDepositEmpty   = errors.New("deposit is empty")
+	errRoomFull       = errors.New("room is full")
+)
+
+// Deposit is a player's buy-in escrowed on chain for a game.
+type Deposit struct {
+	Id     string `json:"id"`
+	GameID string `json:"game_id"`
+	Player string `json:"player"`
+	Chips  int    `json:"chips"`
+}
+
+// Deposits are claimed in the outbox, so a deposit buys in only once, even
+// across restarts. A claimed deposit is played once its chips are in play;
+// the next settlement of its game, or of its player's cash-out, consumes it
+// on chain.
+
+func claimDeposit(id, game, player string) error {
+	return outbox.claim(id, game, player)
+}
+
+func playDeposit(id string) {
+	outbox.play(id)
+}
+
+func unclaimDeposit(id string) {
+	outbox.unclaim(id)
+}
+
+// verifyDeposit checks through the settler that deposit id escrows chips
+// for o in game, made from the address o signed in with, and claims it.
+func verifyDeposit(game string, o *Occupant, id string) (*Deposit, error) {
+	if id == "" || settler == nil {
+		return nil, errUnknownDeposit
+	}
+	d, err := settler.Deposit(context.Background(), id)
+	if err != nil {
+		return nil, err
+	}
+	if o.address == "" || d.Player != o.address {
+		return nil, errDepositPlayer
+	}
+	if d.GameID != game {
+		return nil, errDepositGame
+	}
+	if d.Chips <= 0 {
+		return nil, errDepositEmpty
+	}
+	if err := claimDeposit(d.Id, game, o.Id); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// BuyIn seats o in room at pos, or any free seat for pos 0, with the chips
+// escrowed by deposit. An occupant already seated in room is just sent the
+// room state, and one seated at another table cannot buy in.
+func (o *Occupant) BuyIn(room *Room, deposit string, pos int) error {
+	if room.Occupant(o.Id) != nil {
+		o.JoinRoom(room, 0)
+		return nil
+	}
+	if o.seated() {
+		return errSeated
+	}
+
+	d, err := verifyDeposit(room.Id, o, deposit)
+	if err != nil {
+		return err
+	}
+	chips := d.Chips
+	if t := room.Tournament; t != nil {
+		// everyone buys in for the same deposit and stack
+		if !t.Start.IsZero() {
+			err = errTournamentStarted
+		} else if d.Chips != t.Config.BuyIn {
+			err = errTournamentBuyIn
+		}
+		chips = t.Config.Stack
+	} else {
+		err = room.checkStack(d.Chips)
+	}
+	if err != nil {
+		unclaimDeposit(d.Id)
+		return err
+	}
+	o.JoinRoomAt(room, chips, pos)
+	if room.Occupant(o.Id) == nil {
+		unclaimDeposit(d.Id)
+		if pos != 0 {
+			return errSeatTaken
+		}
+		return errRoomFull
+	}
+	playDeposit(d.Id)
+	return nil
+}
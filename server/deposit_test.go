@@ -0,0 +1,81 @@
+package poker
+
+import (
+	"testing"
+)
+
+func TestBuyIn(t *testing.T) {
+	local, _ := NewLocalSettler("")
+	SetSettler(local)
+	defer SetSettler(nil)
+
+	room := newTestRoom(t, 0, 0)
+	room.DelOccupant(room.Occupants[1])
+	local.AddDeposit(&Deposit{Id: "0xd1", GameID: room.Id, Player: "alice", Chips: 500})
+	local.AddDeposit(&Deposit{Id: "0xd2", GameID: "other", Player: "bob", Chips: 500})
+
+	bob := newTestOccupant("bob", 0)
+	for _, id := range []string{"", "0xunknown", "0xd1", "0xd2"} {
+		if err := bob.BuyIn(room, id, 0); err == nil {
+			t.Errorf("bob bought in with deposit %q", id)
+		}
+	}
+
+	alice := newTestOccupant("alice", 0)
+	if err := alice.BuyIn(room, "0xd1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if room.Occupant("alice") == nil || alice.Chips != 500 {
+		t.Fatalf("alice not seated with her deposit, chips %d", alice.Chips)
+	}
+
+	alice.Leave()
+	if err := alice.BuyIn(room, "0xd1", 0); err != errDepositUsed {
+		t.Fatalf("reused deposit: %v", err)
+	}
+}
+
+func TestBuyInChecks(t *testing.T) {
+	local, _ := NewLocalSettler("")
+	SetSettler(local)
+	defer SetSettler(nil)
+
+	room := newTestRoom(t, 1000)
+	other := newTestRoom(t, 1000, 0)
+	other.DelOccupant(other.Occupants[1])
+	local.AddDeposit(&Deposit{Id: "0xe1", GameID: other.Id, Player: "carol", Chips: 500})
+	local.AddDeposit(&Deposit{Id: "0xe2", GameID: other.Id, Player: "0xcarol", Chips: 500})
+	local.AddDeposit(&Deposit{Id: "0xe3", GameID: room.Id, Player: "0xcarol", Chips: 500})
+
+	// the deposit is checked against the address signed in with
+	carol := newTestOccupant("carol", 0)
+	carol.address = "0xcarol"
+	if err := carol.BuyIn(other, "0xe1", 0); err != errDepositPlayer {
+		t.Fatalf("bought in with a deposit from %q: %v", carol.Id, err)
+	}
+	guest := newTestOccupant("carol", 0)
+	guest.address = ""
+	if err := guest.BuyIn(other, "0xe2", 0); err != errDepositPlayer {
+		t.Fatalf("bought in without signing in: %v", err)
+	}
+
+	// a full room leaves carol as she was, and tells nobody
+	if err := carol.BuyIn(room, "0xe3", 0); err != errRoomFull {
+		t.Fatalf("bought in to a full room: %v", err)
+	}
+	if carol.Room != nil || carol.Chips != 0 || carol.player != nil {
+		t.Fatalf("carol changed by a failed seat: room %v, chips %d", carol.Room, carol.Chips)
+	}
+	if len(received(t, room.Occupants[0])) != 0 || room.ledger.Balance(PlayerAccount("carol")) != 0 {
+		t.Fatal("failed seat announced or booked")
+	}
+
+	// seated at one table, carol cannot buy in at another
+	if err := carol.BuyIn(other, "0xe2", 0); err != nil {
+		t.Fatal(err)
+	}
+	room.DelOccupant(room.Occupants[0])
+	if err := carol.BuyIn(room, "0xe3", 0); err != errSeated {
+		t.Fatalf("bought in at a second table: %v", err)
+	}
+}
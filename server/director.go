@@ -0,0 +1,601 @@
+package poker
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"mental-poker/mental_poker"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	errRegistered      = errors.New("already registered")
+	errNotRegistered   = errors.New("not registered")
+	errTournamentFull  = errors.New("tournament full")
+	errTooFewEntrants  = errors.New("too few entrants")
+	errUnknownDirector = errors.New("tournament not found")
+)
+
+// Director runs a multi-table tournament: it draws the seats of the
+// entrants over as many tables as they need, then keeps the tables within
+// one player of each other and breaks them as the field shrinks, down to
+// the final table. Each table is a room playing the tournament's blind
+// schedule from the same start; the director places the eliminations and
+// pays out the prize pool of the whole event.
+type Director struct {
+	Id          string      `json:"id"`
+	TableSize   int         `json:"table_size"`
+	Max         int         `json:"max,omitempty"` // entrants, no limit if 0
+	Tournament  *Tournament `json:"tournament"`
+	Left        int         `json:"left"` // players still in
+	HandForHand bool        `json:"hand_for_hand,omitempty"`
+
+	lock       sync.Mutex
+	registered []*Occupant
+	deposits   map[string]string // by registered player
+	tables     []*Room
+	// pending are the tables playing the current hand for hand, true once
+	// they dealt it.
+	pending map[*Room]bool
+	busted  []*bustOut // in the current hand for hand, not placed yet
+	// moves are the players moved to a table, seated there once it is
+	// between hands.
+	moves map[*Room][]*Occupant
+}
+
+// bustOut is a player who lost their last chip at table, and the stack they
+// started the hand with.
+type bustOut struct {
+	o     *Occupant
+	table *Room
+	stack int
+}
+
+// NewDirector returns the director of a tournament cfg played at tables of
+// tableSize seats, open for registration.
+func NewDirector(id string, tableSize, max int, cfg *TournamentConfig) *Director {
+	if tableSize <= 1 || tableSize > MaxN {
+		tableSize = 9
+	}
+	return &Director{
+		Id:         id,
+		TableSize:  tableSize,
+		Max:        max,
+		Tournament: &Tournament{Config: cfg},
+		deposits:   make(map[string]string),
+		moves:      make(map[*Room][]*Occupant),
+	}
+}
+
+// DirectorView is the state of a multi-table tournament: the players left
+// at each of its tables.
+type DirectorView struct {
+	Id          string         `json:"id"`
+	Tournament  *Tournament    `json:"tournament"`
+	Registered  int            `json:"registered,omitempty"`
+	Left        int            `json:"left"`
+	HandForHand bool           `json:"hand_for_hand,omitempty"`
+	Tables      map[string]int `json:"tables,omitempty"`
+}
+
+func (d *Director) View() *DirectorView {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	v := &DirectorView{
+		Id:          d.Id,
+		Tournament:  d.Tournament.view(),
+		Registered:  len(d.registered),
+		Left:        d.Left,
+		HandForHand: d.HandForHand,
+		Tables:      make(map[string]int),
+	}
+	for _, table := range d.tables {
+		v.Tables[table.Id] = d.players(table)
+	}
+	return v
+}
+
+// Register enters o in the tournament with the buy-in escrowed by deposit
+// for the tournament's id, until it starts.
+func (d *Director) Register(o *Occupant, deposit string) error {
+	dep, err := verifyDeposit(d.Id, o, deposit)
+	if err != nil {
+		return err
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	switch {
+	case !d.Tournament.Start.IsZero():
+		err = errTournamentStarted
+	case dep.Chips != d.Tournament.Config.BuyIn:
+		err = errTournamentBuyIn
+	case d.deposits[o.Id] != "":
+		err = errRegistered
+	case o.seated():
+		err = errSeated
+	case d.Max > 0 && len(d.registered) >= d.Max:
+		err = errTournamentFull
+	}
+	if err != nil {
+		unclaimDeposit(dep.Id)
+		return err
+	}
+	d.registered = append(d.registered, o)
+	d.deposits[o.Id] = dep.Id
+	playDeposit(dep.Id)
+	return nil
+}
+
+// Unregister takes o out of the tournament before it starts, giving back
+// its buy-in.
+func (d *Director) Unregister(o *Occupant) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if !d.Tournament.Start.IsZero() {
+		return errTournamentStarted
+	}
+	if d.deposits[o.Id] == "" {
+		return errNotRegistered
+	}
+	for i, r := range d.registered {
+		if r.Id == o.Id {
+			d.registered = append(d.registered[:i], d.registered[i+1:]...)
+			break
+		}
+	}
+	d.refund(o)
+	return nil
+}
+
+// refund gives back the buy-in of o, taken out of the tournament.
+func (d *Director) refund(o *Occupant) {
+	deposit := d.deposits[o.Id]
+	delete(d.deposits, o.Id)
+
+	// no hand was played: the deposit stands in for the transcript
+	s := &Settlement{
+		GameID:  d.Id,
+		Players: []string{o.Id},
+		Chips:   []int{d.Tournament.Config.BuyIn},
+		Proof:   deposit,
+		CashOut: true,
+	}
+	if _, err := outbox.Enqueue(s); err != nil {
+		log.Println("tournament", d.Id, o.Id, err)
+	}
+}
+
+// Start draws the seats of the entrants at random over the fewest tables
+// that hold them, as evenly as it goes, and starts the clock. Registrants
+// whose connection closed, or who sat down at a table since, are taken out
+// first.
+func (d *Director) Start() error {
+	d.lock.Lock()
+	t := d.Tournament
+	if !t.Start.IsZero() {
+		d.lock.Unlock()
+		return errTournamentStarted
+	}
+	var players []*Occupant
+	for _, o := range d.registered {
+		if o.offline.Load() || o.seated() {
+			d.refund(o)
+		} else {
+			players = append(players, o)
+		}
+	}
+	d.registered = players
+	n := len(players)
+	if n < 2 || n < len(t.Config.Payouts) {
+		d.lock.Unlock()
+		return errTooFewEntrants
+	}
+	d.registered = nil
+	t.Start = time.Now()
+	d.lock.Unlock()
+
+	// the games are set up with the mental poker service: registration is
+	// closed, but the director is not held up meanwhile
+	tables := make([]*Room, (n+d.TableSize-1)/d.TableSize)
+	for i := range tables {
+		tables[i] = NewSitAndGo(fmt.Sprintf("%s-%d", d.Id, i+1), d.TableSize, t.Config)
+		tables[i].Tournament.Start = t.Start
+		tables[i].director = d
+		setUpGame(tables[i])
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	rand.Shuffle(n, func(i, j int) {
+		players[i], players[j] = players[j], players[i]
+	})
+	for _, table := range tables {
+		SetRoom(table)
+	}
+	d.tables = tables
+	for i, o := range players {
+		t.Entrants = append(t.Entrants, o.Id)
+		o.JoinRoomAt(tables[i%len(tables)], t.Config.Stack, 0)
+	}
+	d.Left = n
+	return nil
+}
+
+// setUpGame sets up room's game with the mental poker service, or a local
+// one when the service is down.
+func setUpGame(room *Room) {
+	if err := room.SetUpGame(); err != nil {
+		log.Println("tournament", room.Id, err)
+		room.game = mental_poker.NewGame(room.Id, nil, "")
+	}
+}
+
+// deal reports whether table room may deal its next hand. Hand for hand,
+// each table deals one hand, then waits for the others to finish theirs.
+func (d *Director) deal(room *Room) bool {
+	if d == nil {
+		return true
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if !d.HandForHand {
+		return true
+	}
+	if d.pending == nil {
+		d.pending = make(map[*Room]bool)
+		for _, table := range d.tables {
+			if d.players(table) >= 2 {
+				d.pending[table] = false
+			}
+		}
+	}
+	if dealt, ok := d.pending[room]; !ok || dealt {
+		return false
+	}
+	d.pending[room] = true
+	return true
+}
+
+// handDone is told by table room that a hand is over, with the players it
+// busted. They are placed in the event, and players are moved to keep the
+// tables balanced. Hand for hand, the players busted at every table are
+// placed together once the last table finishes its hand, as if they had
+// busted at one table.
+func (d *Director) handDone(room *Room, busted []*Occupant) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for _, o := range busted {
+		d.busted = append(d.busted, &bustOut{o: o, table: room, stack: room.Tournament.stacks[o.Id]})
+	}
+	if d.pending[room] {
+		delete(d.pending, room)
+	}
+	if d.HandForHand && len(d.pending) > 0 {
+		// the other tables are still playing the hand
+		return
+	}
+
+	tables := []*Room{room}
+	for _, b := range d.busted {
+		if !slices.Contains(tables, b.table) {
+			tables = append(tables, b.table)
+		}
+	}
+	d.place()
+	if d.Left <= 1 {
+		d.finish(room)
+		return
+	}
+
+	paid := len(d.Tournament.Config.Payouts)
+	if d.Left == paid+1 && !d.HandForHand {
+		// on the bubble
+		d.HandForHand = true
+		d.pending = nil
+		d.broadcast(&Message{From: d.Id, Type: MsgPresence, Action: ActHandForHand})
+	} else if d.Left <= paid {
+		d.HandForHand = false
+	}
+
+	// the tables that lost players are between hands
+	for _, table := range tables {
+		if !slices.Contains(d.tables, table) {
+			continue
+		}
+		if len(d.tables) > 1 && d.Left <= (len(d.tables)-1)*d.TableSize && d.players(table) <= d.players(d.smallest(nil)) {
+			d.breakTable(table)
+		} else {
+			d.balance(table)
+		}
+	}
+
+	if !d.HandForHand || len(d.pending) == 0 {
+		// the waiting tables deal on their next tick
+		d.pending = nil
+	}
+}
+
+// place places the players busted since the last time in the event, those
+// who started their hand with more chips higher, and takes them out.
+func (d *Director) place() {
+	if len(d.busted) == 0 {
+		return
+	}
+
+	busted := make([]*Occupant, len(d.busted))
+	stacks := make(map[string]int)
+	bustOuts := make(map[string]*bustOut)
+	for i, b := range d.busted {
+		busted[i] = b.o
+		stacks[b.o.Id] = b.stack
+		bustOuts[b.o.Id] = b
+	}
+	d.busted = nil
+	d.Left -= len(busted)
+
+	t := d.Tournament
+	results := placeBusted(busted, stacks, d.Left)
+	t.Results = append(t.Results, results...)
+	for _, r := range results {
+		b := bustOuts[r.Id]
+		b.table.Broadcast(&Message{
+			From:     b.table.Id,
+			Type:     MsgPresence,
+			Action:   ActEliminated,
+			Class:    strconv.Itoa(r.Place),
+			Occupant: b.o,
+		})
+		b.o.Leave()
+	}
+}
+
+// players returns the number of players at table, seated or moving there.
+func (d *Director) players(table *Room) int {
+	return table.N + len(d.moves[table])
+}
+
+// smallest returns the table other than except with the fewest players and
+// a free seat, or nil.
+func (d *Director) smallest(except *Room) (small *Room) {
+	for _, table := range d.tables {
+		if table != except && d.players(table) < table.Cap() && (small == nil || d.players(table) < d.players(small)) {
+			small = table
+		}
+	}
+	return
+}
+
+// balance moves players from room, between hands, to the smallest table
+// while room has two more players. The player due the big blind moves, so
+// nobody skips the blinds.
+func (d *Director) balance(room *Room) {
+	for {
+		to := d.smallest(room)
+		if to == nil || d.players(room)-d.players(to) <= 1 {
+			return
+		}
+		o := room.nextSeat(room.bbPos, func(*Occupant) bool { return true })
+		if o == nil {
+			return
+		}
+		d.move(o, room, to)
+	}
+}
+
+// breakTable moves every player of room to the smallest tables and closes
+// it, announcing the final table once one is left.
+func (d *Director) breakTable(room *Room) {
+	room.Each(0, func(o *Occupant) bool {
+		d.move(o, room, d.smallest(room))
+		return true
+	})
+	moves := d.moves[room]
+	delete(d.moves, room)
+	for _, o := range moves {
+		to := d.smallest(room)
+		d.moves[to] = append(d.moves[to], o)
+		o.SendMessage(&Message{
+			From:   room.Id,
+			Type:   MsgPresence,
+			Action: ActMove,
+			Class:  to.Id,
+		})
+	}
+	for i, table := range d.tables {
+		if table == room {
+			d.tables = append(d.tables[:i], d.tables[i+1:]...)
+			break
+		}
+	}
+	delete(d.pending, room)
+	room.lock.Lock()
+	room.Tournament.lock.Lock()
+	room.Tournament.Finished = true
+	room.Tournament.lock.Unlock()
+	room.lock.Unlock()
+	room.Close()
+
+	if len(d.tables) == 1 {
+		d.broadcast(&Message{From: d.tables[0].Id, Type: MsgPresence, Action: ActFinalTable})
+	}
+}
+
+// move takes o with its stack from table from, which is between hands, to
+// table to, which may be playing one. o is seated there before its next
+// hand, see seat.
+func (d *Director) move(o *Occupant, from, to *Room) {
+	from.Broadcast(&Message{
+		From:     from.Id,
+		Type:     MsgPresence,
+		Action:   ActLeave,
+		Occupant: o,
+	})
+	o.Cards = nil
+	from.DelOccupant(o)
+	from.ledger.Transfer(from.hand, EntryCashOut, PlayerAccount(o.Id), AccountCashier, o.Chips)
+
+	o.Bet = 0
+	o.Hand = 0
+	o.Action = ""
+	o.Pos = 0
+	o.out = true
+	d.moves[to] = append(d.moves[to], o)
+	o.SendMessage(&Message{
+		From:   from.Id,
+		Type:   MsgPresence,
+		Action: ActMove,
+		Class:  to.Id,
+	})
+}
+
+// seat seats the players moved to table room, which is between hands, to be
+// dealt in from its next hand. A player finding no free seat there moves on
+// to the smallest other table, or waits for one at room.
+func (d *Director) seat(room *Room) {
+	if d == nil {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	moves := d.moves[room]
+	delete(d.moves, room)
+	for _, o := range moves {
+		o.SetPlayer(mental_poker.NewPlayer(room.game))
+		if room.AddOccupant(o) == 0 {
+			log.Println("tournament", d.Id, "no seat for", o.Id, "at", room.Id)
+			to := d.smallest(room)
+			if to == nil {
+				to = room
+			}
+			d.moves[to] = append(d.moves[to], o)
+			continue
+		}
+		room.ledger.Transfer(room.hand, EntryBuyIn, AccountCashier, PlayerAccount(o.Id), o.Chips)
+
+		o.Broadcast(&Message{
+			From:     room.Id,
+			Type:     MsgPresence,
+			Action:   ActJoin,
+			Occupant: o,
+		})
+		o.SendMessage(&Message{
+			From:   room.Id,
+			Type:   MsgPresence,
+			Action: ActState,
+			Room:   room,
+		})
+	}
+}
+
+// broadcast sends message to every table, and the players moving between
+// them.
+func (d *Director) broadcast(message *Message) {
+	for _, table := range d.tables {
+		table.Broadcast(message)
+	}
+	for _, moves := range d.moves {
+		for _, o := range moves {
+			o.SendMessage(message)
+		}
+	}
+}
+
+// finish places the last player first, pays the prizes of the event on
+// chain and closes the last tables. room played the last hand.
+func (d *Director) finish(room *Room) {
+	t := d.Tournament
+	for _, table := range d.tables {
+		table.Each(0, func(o *Occupant) bool {
+			t.Results = append(t.Results, &TournamentResult{Place: 1, Id: o.Id})
+			return true
+		})
+	}
+	var moving []*Occupant
+	for _, moves := range d.moves {
+		for _, o := range moves {
+			t.Results = append(t.Results, &TournamentResult{Place: 1, Id: o.Id})
+			moving = append(moving, o)
+		}
+	}
+	d.moves = make(map[*Room][]*Occupant)
+	s := &Settlement{
+		GameID: d.Id,
+		Proof:  room.transcript.Digest(),
+	}
+	t.payout(s)
+	t.Finished = true
+	d.pending = nil
+
+	for _, table := range d.tables {
+		// each table has a copy of its own, changed under its locks
+		results := t.view().Results
+		table.lock.Lock()
+		table.Tournament.lock.Lock()
+		table.Tournament.Results = results
+		table.Tournament.Finished = true
+		table.Tournament.lock.Unlock()
+		table.lock.Unlock()
+		table.Broadcast(&Message{
+			From:   table.Id,
+			Type:   MsgPresence,
+			Action: ActFinished,
+			Room:   table,
+		})
+	}
+	for _, o := range moving {
+		o.SendMessage(&Message{
+			From:   room.Id,
+			Type:   MsgPresence,
+			Action: ActFinished,
+			Room:   room,
+		})
+	}
+	if err := room.enqueue(s); err != nil {
+		log.Println("tournament", d.Id, err)
+	}
+	for _, table := range d.tables {
+		table.Close()
+	}
+	d.tables = nil
+}
+
+type directorList struct {
+	M    map[string]*Director
+	lock sync.Mutex
+}
+
+var directors = &directorList{M: make(map[string]*Director)}
+
+// SetDirector adds the tournament d, unless one has its id.
+func SetDirector(d *Director) bool {
+	directors.lock.Lock()
+	defer directors.lock.Unlock()
+
+	if directors.M[d.Id] != nil {
+		return false
+	}
+	directors.M[d.Id] = d
+	return true
+}
+
+// GetDirector returns the tournament with id, or nil.
+func GetDirector(id string) *Director {
+	directors.lock.Lock()
+	defer directors.lock.Unlock()
+
+	return directors.M[id]
+}
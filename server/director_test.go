@@ -0,0 +1,392 @@
+package poker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mental-poker/mental_poker"
+	"testing"
+	"time"
+)
+
+// newTestDirector returns a started tournament with as many tables of size
+// seats as players, seating players[i] at the i-th.
+func newTestDirector(t *testing.T, cfg *TournamentConfig, size int, players ...int) *Director {
+	d := NewDirector(t.Name(), size, 0, cfg)
+	d.Tournament.Start = time.Now()
+	for i, n := range players {
+		table := NewSitAndGo(fmt.Sprintf("%s-%d", d.Id, i+1), size, cfg)
+		stopRoom(t, table)
+		table.Tournament.Start = d.Tournament.Start
+		table.game = mental_poker.NewGame(table.Id, nil, "")
+		table.director = d
+		SetRoom(table)
+		for j := 0; j < n; j++ {
+			o := newTestOccupant(fmt.Sprintf("p%d", d.Left+1), cfg.Stack)
+			o.SetPlayer(mental_poker.NewPlayer(table.game))
+			table.AddOccupant(o)
+			table.ledger.Transfer(0, EntryBuyIn, AccountCashier, PlayerAccount(o.Id), o.Chips)
+			d.Tournament.Entrants = append(d.Tournament.Entrants, o.Id)
+			d.Left++
+		}
+		d.tables = append(d.tables, table)
+	}
+	return d
+}
+
+// bust has table room play a hand in which the players ids lost their
+// last chip.
+func bust(room *Room, ids ...string) {
+	for _, id := range ids {
+		room.Occupant(id).Chips = 0
+	}
+	room.checkAndEndGame()
+}
+
+func TestDirectorRegistration(t *testing.T) {
+	local, _ := NewLocalSettler("")
+	SetSettler(local)
+	defer SetSettler(nil)
+	ob, _ := NewOutbox(local, "")
+	old := outbox
+	SetOutbox(ob)
+	defer SetOutbox(old)
+
+	d := NewDirector(t.Name(), 3, 0, testTournamentConfig())
+	players := make([]*Occupant, 4)
+	for i := range players {
+		players[i] = newTestOccupant(fmt.Sprintf("p%d", i+1), 0)
+		local.AddDeposit(&Deposit{Id: fmt.Sprintf("0xm%d", i+1), Player: players[i].Id, GameID: d.Id, Chips: 100})
+	}
+	local.AddDeposit(&Deposit{Id: "0xm5", Player: "p1", GameID: d.Id, Chips: 50})
+	local.AddDeposit(&Deposit{Id: "0xm6", Player: "p1", GameID: d.Id, Chips: 100})
+
+	p1 := players[0]
+	if err := d.Register(p1, "0xm5"); err != errTournamentBuyIn {
+		t.Fatalf("registered for another amount: %v", err)
+	}
+	if err := d.Register(p1, "0xm1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Register(p1, "0xm6"); err != errRegistered {
+		t.Fatalf("registered twice: %v", err)
+	}
+	if err := d.Start(); err != errTooFewEntrants {
+		t.Fatalf("started with one entrant: %v", err)
+	}
+
+	// unregistering gives back the buy-in
+	if err := d.Unregister(p1); err != nil {
+		t.Fatal(err)
+	}
+	ob.Process(context.Background())
+	settlements := local.Settlements()
+	if len(settlements) != 1 || !settlements[0].CashOut || settlements[0].Chips[0] != 100 {
+		t.Fatalf("unregistering: %+v", settlements)
+	}
+	if deposits := settlements[0].Deposits; len(deposits) != 1 || deposits[0] != "0xm1" {
+		t.Fatalf("refund consumes %v, want the buy-in", deposits)
+	}
+	if err := d.Register(p1, "0xm1"); err != errDepositUsed {
+		t.Fatalf("registered with a refunded deposit: %v", err)
+	}
+
+	if err := d.Register(p1, "0xm6"); err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range players[1:] {
+		if err := d.Register(o, "0xm"+o.Id[1:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a player seated at a table does not register
+	room := newTestRoom(t, 1000)
+	local.AddDeposit(&Deposit{Id: "0xm7", Player: "a", GameID: d.Id, Chips: 100})
+	if err := d.Register(room.Occupants[0], "0xm7"); err != errSeated {
+		t.Fatalf("registered while seated: %v", err)
+	}
+
+	// a registrant whose connection closed is taken out at the start
+	gone := newTestOccupant("p5", 0)
+	local.AddDeposit(&Deposit{Id: "0xm8", Player: "p5", GameID: d.Id, Chips: 100})
+	if err := d.Register(gone, "0xm8"); err != nil {
+		t.Fatal(err)
+	}
+	gone.offline.Store(true)
+
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, table := range d.tables {
+			table.Close()
+		}
+	}()
+
+	// four players need two tables of three, two each
+	if len(d.tables) != 2 || d.tables[0].N != 2 || d.tables[1].N != 2 || d.Left != 4 {
+		t.Fatalf("seated %d tables: %+v", len(d.tables), d.View().Tables)
+	}
+	for _, o := range players {
+		if o.Room == nil || o.Chips != 1500 || o.Room.Tournament.Start != d.Tournament.Start {
+			t.Fatalf("%s not seated with the starting stack", o.Id)
+		}
+	}
+	if len(d.Tournament.Entrants) != 4 {
+		t.Fatalf("%d entrants", len(d.Tournament.Entrants))
+	}
+	if err := d.Unregister(p1); err != errTournamentStarted {
+		t.Fatalf("unregistered after the start: %v", err)
+	}
+	ob.Process(context.Background())
+	settlements = local.Settlements()
+	if gone.Room != nil || len(settlements) != 2 || settlements[1].Players[0] != "p5" || settlements[1].Chips[0] != 100 {
+		t.Fatalf("registrant gone not refunded: %+v", settlements)
+	}
+}
+
+func TestDirectorTables(t *testing.T) {
+	d := newTestDirector(t, testTournamentConfig(), 3, 3, 3, 2)
+	t1, t2, t3 := d.tables[0], d.tables[1], d.tables[2]
+
+	// a player busts at the short table; the others are balanced as they
+	// finish their hand, moving the player due the big blind
+	bust(t3, "p7")
+	if d.Left != 7 || d.Tournament.Results[0].Place != 8 || t3.N != 1 {
+		t.Fatalf("%d left, %d at the third table", d.Left, t3.N)
+	}
+	t1.bbPos = 1
+	bust(t1)
+	if t3.Occupant("p2") != nil || d.View().Tables[t3.Id] != 2 {
+		t.Fatal("not moved between hands")
+	}
+	d.seat(t3)
+	moved := t3.Occupant("p2")
+	if t1.N != 2 || t3.N != 2 || moved == nil {
+		t.Fatalf("not balanced: %+v", d.View().Tables)
+	}
+	told := false
+	for _, m := range received(t, moved) {
+		told = told || m.Action == ActMove && m.Class == t3.Id
+	}
+	if !told {
+		t.Fatal("player not told of the move")
+	}
+	if t1.ledger.Balance(PlayerAccount("p2")) != 0 || t3.ledger.Balance(PlayerAccount("p2")) != 1500 {
+		t.Fatal("stack not moved in the ledgers")
+	}
+
+	// six left fit at two tables: the short table breaks
+	bust(t2, "p4")
+	d.seat(t1)
+	d.seat(t3)
+	if len(d.tables) != 2 || RoomExist(t2.Id) || t2.N != 0 || t1.N != 3 || t3.N != 3 {
+		t.Fatalf("table not broken: %+v", d.View().Tables)
+	}
+
+	// three left: the final table
+	bust(t1, "p1")
+	bust(t3, "p8")
+	received(t, t3.Occupants[0])
+	bust(t1, "p3")
+	d.seat(t3)
+	if len(d.tables) != 1 || d.tables[0] != t3 || t3.N != 3 || RoomExist(t1.Id) {
+		t.Fatalf("no final table: %+v", d.View().Tables)
+	}
+	final := false
+	for _, m := range received(t, t3.Occupants[0]) {
+		final = final || m.Action == ActFinalTable
+	}
+	if !final {
+		t.Fatal("final table not announced")
+	}
+	t3.Close()
+}
+
+func TestDirectorSeatTaken(t *testing.T) {
+	d := newTestDirector(t, testTournamentConfig(), 3, 3, 1)
+	t1, t2 := d.tables[0], d.tables[1]
+
+	bust(t1)
+	if len(d.moves[t2]) != 1 {
+		t.Fatal("not balanced")
+	}
+	moved := d.moves[t2][0]
+
+	// the seats are gone by the time the table is between hands
+	t2.AddOccupant(newTestOccupant("x", 0))
+	t2.AddOccupant(newTestOccupant("y", 0))
+	d.seat(t2)
+	if t2.Occupant(moved.Id) != nil || len(d.moves[t1]) != 1 {
+		t.Fatal("player not moved on to another table")
+	}
+	d.seat(t1)
+	if t1.Occupant(moved.Id) != moved || t1.ledger.Balance(PlayerAccount(moved.Id)) != 1500 || d.Left != 4 {
+		t.Fatalf("%s not seated with its stack", moved.Id)
+	}
+}
+
+func TestDirectorBubble(t *testing.T) {
+	local, _ := NewLocalSettler("")
+	ob, _ := NewOutbox(local, "")
+	old := outbox
+	SetOutbox(ob)
+	defer SetOutbox(old)
+
+	cfg := testTournamentConfig()
+	cfg.Payouts = []int{50, 30, 20}
+	d := newTestDirector(t, cfg, 3, 3, 3)
+	t1, t2 := d.tables[0], d.tables[1]
+
+	bust(t1, "p1")
+	if d.HandForHand || !d.deal(t1) || !d.deal(t1) {
+		t.Fatal("hand for hand before the bubble")
+	}
+
+	// four left for three places: each table deals one hand at a time
+	bust(t2, "p4")
+	if !d.HandForHand {
+		t.Fatal("not hand for hand on the bubble")
+	}
+	if !d.deal(t1) || d.deal(t1) || !d.deal(t2) {
+		t.Fatal("tables not dealing hand for hand")
+	}
+	bust(t1)
+	if d.deal(t1) {
+		t.Fatal("dealt before the other table finished its hand")
+	}
+	bust(t2)
+	if !d.deal(t1) || !d.deal(t2) {
+		t.Fatal("next hand for hand not dealt")
+	}
+
+	// the bubble bursts once both tables finished the hand, at the final
+	// table
+	bust(t1, "p2")
+	if len(d.Tournament.Results) != 2 || d.Left != 4 {
+		t.Fatal("placed before the other table finished its hand")
+	}
+	bust(t2)
+	d.seat(t2)
+	if d.HandForHand || !d.deal(t2) || !d.deal(t2) || len(d.tables) != 1 || t2.N != 3 {
+		t.Fatal("still hand for hand in the money")
+	}
+	t2.Tournament.stacks = map[string]int{"p3": 1000, "p5": 500, "p6": 7500}
+	bust(t2, "p3", "p5")
+	ob.Process(context.Background())
+
+	results := d.Tournament.Results
+	if !d.Tournament.Finished || len(results) != 6 {
+		t.Fatalf("%d results", len(results))
+	}
+	want := []TournamentResult{{1, "p6", 300}, {2, "p3", 180}, {3, "p5", 120}, {4, "p2", 0}, {5, "p4", 0}, {6, "p1", 0}}
+	for i, r := range results {
+		if *r != want[i] {
+			t.Errorf("place %d: %+v, want %+v", i+1, r, want[i])
+		}
+	}
+	if v := d.View().Tournament; v == d.Tournament || v.Results[0] == results[0] {
+		t.Fatal("view shares the tournament")
+	}
+	if final := t2.Tournament.Results; len(final) != 6 || final[0] == results[0] {
+		t.Fatal("final table shares the results")
+	}
+	settlements := local.Settlements()
+	if len(settlements) != 1 || settlements[0].GameID != d.Id || settlements[0].Chips[0] != 300 {
+		t.Fatalf("settlements %+v", settlements)
+	}
+	if RoomExist(t2.Id) {
+		t.Fatal("final table not closed")
+	}
+}
+
+func TestDirectorHandForHand(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		p2, p5 int // stacks at the start of the hand
+		want   []TournamentResult
+	}{
+		{"ordered", 1000, 500, []TournamentResult{{3, "p2", 120}, {4, "p5", 0}}},
+		{"level", 1000, 1000, []TournamentResult{{3, "p2", 60}, {3, "p5", 60}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			local, _ := NewLocalSettler("")
+			ob, _ := NewOutbox(local, "")
+			old := outbox
+			SetOutbox(ob)
+			defer SetOutbox(old)
+
+			cfg := testTournamentConfig()
+			cfg.Payouts = []int{50, 30, 20}
+			d := newTestDirector(t, cfg, 3, 3, 3)
+			t1, t2 := d.tables[0], d.tables[1]
+			bust(t1, "p1")
+			bust(t2, "p4")
+			if !d.HandForHand || !d.deal(t1) || !d.deal(t2) {
+				t.Fatal("not dealing hand for hand")
+			}
+
+			// each table busts a player in the same hand; the first to
+			// finish it had the bigger stack
+			t1.Tournament.stacks = map[string]int{"p2": tc.p2, "p3": 3000}
+			t2.Tournament.stacks = map[string]int{"p5": tc.p5, "p6": 3000}
+			bust(t1, "p2")
+			if len(d.Tournament.Results) != 2 || t1.Occupant("p2") == nil {
+				t.Fatal("placed before the other table finished its hand")
+			}
+			bust(t2, "p5")
+			if d.Left != 2 || len(d.tables) != 1 {
+				t.Fatalf("%d left at %d tables", d.Left, len(d.tables))
+			}
+
+			final := d.tables[0]
+			d.seat(final)
+			final.Tournament.stacks = map[string]int{"p3": 3000, "p6": 3000}
+			final.Occupant("p6").Chips = 6000
+			bust(final, "p3")
+			want := append([]TournamentResult{{1, "p6", 300}, {2, "p3", 180}}, tc.want...)
+			results := d.Tournament.Results
+			for i, w := range want {
+				if *results[i] != w {
+					t.Errorf("result %d: %+v, want %+v", i+1, results[i], w)
+				}
+			}
+		})
+	}
+}
+
+func TestDirectorViewWhileFinishing(t *testing.T) {
+	local, _ := NewLocalSettler("")
+	ob, _ := NewOutbox(local, "")
+	old := outbox
+	SetOutbox(ob)
+	defer SetOutbox(old)
+
+	d := newTestDirector(t, testTournamentConfig(), 3, 2)
+	table := d.tables[0]
+	started, stop, done := make(chan bool), make(chan bool), make(chan bool)
+	go func() {
+		defer close(done)
+		// as the tournament endpoint and table views encode them
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			json.Marshal(d.View())
+			json.Marshal(table.Tournament.view())
+			if i == 0 {
+				close(started)
+			}
+		}
+	}()
+	<-started
+	bust(table, "p1")
+	close(stop)
+	<-done
+	if v := table.View(nil).Tournament; !v.Finished || len(v.Results) != 2 {
+		t.Fatal("tournament not finished")
+	}
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"log"
 	poker "mental-poker/server"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -13,6 +17,27 @@ var (
 	Addr      string
 	MongoAddr string
 	RedisAddr string
+	// TokenSecret keys session tokens; a random key is used if empty
+	TokenSecret string
+	// Settler is "sui" to settle on chain or "local" to record settlements in SettleFile
+	Settler    string
+	SettleFile string
+	OutboxFile string
+	// HistoryDir stores hand histories, a file per room
+	HistoryDir string
+	// EventsFile replays recorded chain events with the local settler
+	EventsFile string
+	// CursorFile stores how far the chain events were read
+	CursorFile string
+	AdminToken string
+	// RakeConfig is a JSON file with the house fee of new rooms, see poker.RakeConfig
+	RakeConfig string
+	// BlockedWords is a comma separated list of words masked in chat
+	BlockedWords string
+	// ChainConfig is the Sui settlement config file, see poker.ChainConfig
+	ChainConfig string
+	// NewKeystore, if set, encrypts $POKER_MNEMONIC with $POKER_KEYSTORE_PASSWORD into this file and exits
+	NewKeystore string
 )
 
 func init() {
@@ -20,30 +45,109 @@ func init() {
 
 	flag.StringVar(&Addr, "addr", ":8989", "server address ip:port")
 	flag.StringVar(&WebRoot, "web", "", "web directory rooted path")
+	flag.StringVar(&TokenSecret, "token-secret", os.Getenv("POKER_TOKEN_SECRET"), "session token signing key")
+	flag.StringVar(&Settler, "settler", "sui", "game settlement: sui or local")
+	flag.StringVar(&SettleFile, "settle-file", "", "file recording local settlements, in memory if empty")
+	flag.StringVar(&OutboxFile, "outbox", "settlements.json", "settlement outbox file, in memory if empty")
+	flag.StringVar(&HistoryDir, "history-dir", "hands", "hand history directory, in memory if empty")
+	flag.StringVar(&EventsFile, "events", "", "chain events file (JSON lines) to replay with the local settler")
+	flag.StringVar(&CursorFile, "events-cursor", "events.json", "chain events cursor file, read from the first event if empty")
+	flag.StringVar(&AdminToken, "admin-token", os.Getenv("POKER_ADMIN_TOKEN"), "bearer token for /admin endpoints")
+	flag.StringVar(&RakeConfig, "rake-config", "", "rake config file, no rake if empty")
+	flag.StringVar(&BlockedWords, "blocked-words", "", "comma separated words masked in chat")
+	flag.StringVar(&ChainConfig, "chain-config", "", "sui chain config file")
+	flag.StringVar(&NewKeystore, "new-keystore", "", "write an encrypted keystore for $POKER_MNEMONIC and exit")
 	flag.Parse()
 }
 
 func main() {
-	poker := &poker.Poker{
+	if NewKeystore != "" {
+		b, err := poker.EncryptKeystore(os.Getenv("POKER_MNEMONIC"), os.Getenv("POKER_KEYSTORE_PASSWORD"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(NewKeystore, b, 0600); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	p := &poker.Poker{
 		Addr:    Addr,
 		WebRoot: WebRoot,
 		OnAuth:  onAuth,
+		Tokens:  poker.NewTokenIssuer([]byte(TokenSecret), 0),
+
+		OutboxFile: OutboxFile,
+		HistoryDir: HistoryDir,
+		CursorFile: CursorFile,
+		AdminToken: AdminToken,
+	}
+	if BlockedWords != "" {
+		p.ChatFilter = poker.BlockedWords(strings.Split(BlockedWords, ",")...)
+	}
+
+	if RakeConfig != "" {
+		b, err := os.ReadFile(RakeConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		p.Rake = &poker.RakeConfig{}
+		if err := json.Unmarshal(b, p.Rake); err != nil {
+			log.Fatal("rake config: ", err)
+		}
 	}
-	log.Fatal(poker.ListenAndServe())
+
+	switch Settler {
+	case "sui":
+		cfg, err := poker.LoadChainConfig(ChainConfig)
+		if err != nil {
+			log.Fatal("chain config: ", err)
+		}
+		settler, err := poker.NewSuiSettler(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := settler.CheckPackage(context.Background()); err != nil {
+			log.Fatal("chain config: ", err)
+		}
+		p.Settler = settler
+		p.Events = poker.NewSuiEventSource(cfg)
+	case "local":
+		settler, err := poker.NewLocalSettler(SettleFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		p.Settler = settler
+		if EventsFile != "" {
+			events, err := poker.NewLocalEventSource(EventsFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			p.Events = events
+		}
+	default:
+		log.Fatalf("unknown settler %q", Settler)
+	}
+
+	log.Fatal(p.ListenAndServe())
 }
 
 func onAuth(conn *poker.Conn, mechanism, text string) (*poker.Occupant, error) {
-	id := strconv.FormatInt(time.Now().Unix(), 10)
-	if text != "" {
-		id = text
+	// only a signed-in address may take over an existing seat
+	if mechanism == poker.AuthPlain {
+		o := poker.NewOccupant(strconv.FormatInt(time.Now().UnixNano(), 10), conn)
+		o.Name = text
+		return o, nil
 	}
-	o := poker.GetOccupantByAddress(id)
+
+	o := poker.GetOccupantByAddress(text)
 	if o != nil {
 		o.ReconnectWith(conn)
 		return o, nil
 	}
 
-	o = poker.NewOccupant(id, conn)
-	o.Name = id
+	o = poker.NewOccupant(text, conn)
+	o.Name = text
 	return o, nil
 }
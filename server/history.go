@@ -0,0 +1,756 @@
+package poker
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	maxHistories = 100 // hands kept in memory per room
+	maxIdleChat  = 50  // chat messages kept between hands
+)
+
+// historyDir holds a file of JSON lines per room, to which every hand
+// played is appended. Histories are kept in memory only if it is empty.
+var historyDir struct {
+	lock  sync.Mutex
+	path  string
+	files map[string]*historyFile // by room
+}
+
+// SetHistoryDir stores the hand histories of all rooms in dir.
+func SetHistoryDir(dir string) {
+	historyDir.lock.Lock()
+	defer historyDir.lock.Unlock()
+
+	historyDir.path = dir
+	historyDir.files = make(map[string]*historyFile)
+}
+
+// Actions in a hand history besides ActFold, ActCheck, ActCall and ActRaise.
+const (
+	HistorySmallBlind = "small blind"
+	HistoryBigBlind   = "big blind"
+	HistoryBet        = "bet"
+	HistoryAnte       = "ante"
+)
+
+// hand ids are unique across rooms and, at fewer than a thousand hands a
+// second, across restarts
+var lastHandID atomic.Int64
+
+func init() {
+	lastHandID.Store(time.Now().UnixMilli())
+}
+
+// HandHistory is the record of one hand.
+type HandHistory struct {
+	Id      int64            `json:"id"`
+	Room    string           `json:"room"`
+	Hand    int              `json:"hand"` // number of the hand in the room
+	Start   time.Time        `json:"start"`
+	SB      int              `json:"sb"`
+	BB      int              `json:"bb"`
+	Max     int              `json:"max"`
+	Button  int              `json:"button"`
+	Seats   []*HistorySeat   `json:"seats"`
+	Actions []*HistoryAction `json:"actions"`
+	Board   []Card           `json:"board,omitempty"`
+	Pots    []int            `json:"pots,omitempty"` // after rake
+	Shown   []*ShownHand     `json:"shown,omitempty"`
+	Winners []*HistoryWin    `json:"winners,omitempty"`
+	Rake    int              `json:"rake,omitempty"`
+	Chat    []*HistoryChat   `json:"chat,omitempty"`
+}
+
+// HistorySeat is an occupant dealt into the hand, with its stack before
+// the blinds.
+type HistorySeat struct {
+	Pos   int    `json:"index"`
+	Id    string `json:"id"`
+	Name  string `json:"name,omitempty"`
+	Chips int    `json:"chips"`
+	Cards []Card `json:"cards,omitempty"`
+}
+
+// HistoryAction is a blind or a betting action. Amount is the chips put in
+// by the action, Bet the occupant's total bet on the street after it.
+type HistoryAction struct {
+	Street string `json:"street"`
+	Pos    int    `json:"index"`
+	Id     string `json:"id"`
+	Action string `json:"action"`
+	Amount int    `json:"amount,omitempty"`
+	Bet    int    `json:"bet,omitempty"`
+	AllIn  bool   `json:"allin,omitempty"`
+}
+
+// HistoryWin is chips won from a pot, 0 being the main pot. An uncalled bet
+// returned to the bettor is a win of a pot nobody else was in.
+type HistoryWin struct {
+	Pot      int    `json:"pot"`
+	Pos      int    `json:"index"`
+	Id       string `json:"id"`
+	Amount   int    `json:"amount"`
+	Uncalled bool   `json:"uncalled,omitempty"`
+}
+
+// HistoryChat is a table chat message, sent after the first After actions.
+// Chat sent between hands goes in the next hand, before its actions.
+type HistoryChat struct {
+	After int       `json:"after"`
+	Id    string    `json:"id"`
+	Text  string    `json:"text"`
+	Time  time.Time `json:"time"`
+}
+
+// street returns the betting round, from the cards on the board.
+func (room *Room) street() string {
+	switch len(room.Cards) {
+	case 0:
+		return ActPreflop
+	case 3:
+		return ActFlop
+	case 4:
+		return ActTurn
+	default:
+		return ActRiver
+	}
+}
+
+// newHistory starts the history of the hand just dealt.
+func (room *Room) newHistory() *HandHistory {
+	h := &HandHistory{
+		Id:     lastHandID.Add(1),
+		Room:   room.Id,
+		Hand:   room.hand,
+		Start:  time.Now(),
+		SB:     room.SB,
+		BB:     room.BB,
+		Max:    room.Max,
+		Button: room.Button,
+		Chat:   room.idleChat,
+	}
+	room.idleChat = nil
+	for _, o := range room.Occupants {
+		if o != nil && len(o.Cards) > 0 {
+			h.Seats = append(h.Seats, &HistorySeat{
+				Pos:   o.Pos,
+				Id:    o.Id,
+				Name:  o.Name,
+				Chips: o.Chips,
+				Cards: o.Cards,
+			})
+		}
+	}
+	return h
+}
+
+// recordAction adds o's last bet to the history. bet is the room's bet
+// before the action and n the chips it put in.
+func (room *Room) recordAction(o *Occupant, kind string, bet, n int, raised bool) {
+	h := room.history
+	if h == nil {
+		return
+	}
+
+	a := &HistoryAction{
+		Street: room.street(),
+		Pos:    o.Pos,
+		Id:     o.Id,
+		Action: o.Action,
+		Amount: n,
+		Bet:    o.Bet,
+		AllIn:  o.Action == ActAllin,
+	}
+	switch {
+	case kind == EntryBlind && o.Pos != room.bbPos && !h.blinded():
+		a.Action = HistorySmallBlind
+	case kind == EntryBlind:
+		a.Action = HistoryBigBlind
+	case raised && bet == 0:
+		a.Action = HistoryBet
+	case raised:
+		a.Action = ActRaise
+	case a.AllIn:
+		a.Action = ActCall
+	}
+	h.Actions = append(h.Actions, a)
+}
+
+// blinded reports whether a blind was posted in the hand.
+func (h *HandHistory) blinded() bool {
+	for _, a := range h.Actions {
+		if a.Action == HistorySmallBlind || a.Action == HistoryBigBlind {
+			return true
+		}
+	}
+	return false
+}
+
+// recordChat adds a room chat message from o to the history, or keeps it
+// for the next hand between hands.
+func (room *Room) recordChat(o *Occupant, text string) {
+	c := &HistoryChat{
+		Id:   o.Id,
+		Text: text,
+		Time: time.Now(),
+	}
+	h := room.history
+	if h == nil {
+		room.idleChat = append(room.idleChat, c)
+		if len(room.idleChat) > maxIdleChat {
+			room.idleChat = room.idleChat[len(room.idleChat)-maxIdleChat:]
+		}
+		return
+	}
+	c.After = len(h.Actions)
+	h.Chat = append(h.Chat, c)
+}
+
+// recordWin adds n chips won from pot i by the occupant at pos to the history.
+func (room *Room) recordWin(i, pos, n int, uncalled bool) {
+	h := room.history
+	if h == nil || n == 0 {
+		return
+	}
+	h.Winners = append(h.Winners, &HistoryWin{
+		Pot:      i,
+		Pos:      pos,
+		Id:       room.Occupants[pos-1].Id,
+		Amount:   n,
+		Uncalled: uncalled,
+	})
+}
+
+// archiveHistory completes the history of the hand just played, keeps it
+// and saves it to the history directory.
+func (room *Room) archiveHistory() {
+	room.lock.Lock()
+	h := room.history
+	if h == nil {
+		room.lock.Unlock()
+		return
+	}
+	h.Board = room.Cards
+	room.histories = append(room.histories, h)
+	if len(room.histories) > maxHistories {
+		room.histories = room.histories[len(room.histories)-maxHistories:]
+	}
+	room.history = nil
+	room.lock.Unlock()
+
+	if err := saveHistory(h); err != nil {
+		log.Println("hand history", room.Id, h.Id, err)
+	}
+}
+
+// historyFile is the file of the histories of a room. It indexes where
+// each hand is in the file, reading it through once, so a hand is read on
+// its own, and the file is read while hands are appended to it.
+type historyFile struct {
+	path string
+
+	lock   sync.Mutex // guards appending and the index
+	loaded bool
+	size   int64                 // of the whole lines written
+	hands  map[int64]historyLine // by HandHistory.Id
+}
+
+// historyLine is where a hand is in its file.
+type historyLine struct {
+	off, n int64
+}
+
+// roomHistoryFile returns the history file of room, or nil if histories
+// are not saved.
+func roomHistoryFile(room string) *historyFile {
+	historyDir.lock.Lock()
+	defer historyDir.lock.Unlock()
+
+	if historyDir.path == "" {
+		return nil
+	}
+	hf := historyDir.files[room]
+	if hf == nil {
+		hf = &historyFile{path: filepath.Join(historyDir.path, url.PathEscape(room)+".jsonl")}
+		historyDir.files[room] = hf
+	}
+	return hf
+}
+
+// index reads the file through once to find its hands. A line cut short
+// by a crash is left out. Called with the lock held.
+func (hf *historyFile) index() error {
+	if hf.loaded {
+		return nil
+	}
+	hf.hands = make(map[int64]historyLine)
+	hf.size = 0
+	f, err := os.Open(hf.path)
+	if os.IsNotExist(err) {
+		hf.loaded = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var h struct {
+			Id int64 `json:"id"`
+		}
+		if err := json.Unmarshal(line, &h); err != nil {
+			return err
+		}
+		hf.hands[h.Id] = historyLine{hf.size, int64(len(line))}
+		hf.size += int64(len(line))
+	}
+	hf.loaded = true
+	return nil
+}
+
+// append writes h at the end of the file.
+func (hf *historyFile) append(h *HandHistory) error {
+	b, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	hf.lock.Lock()
+	defer hf.lock.Unlock()
+
+	f, err := os.OpenFile(hf.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(b); err != nil {
+		hf.loaded = false // index again past what was written
+		return err
+	}
+	end, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		hf.loaded = false
+		return err
+	}
+	if hf.loaded {
+		hf.hands[h.Id] = historyLine{end - int64(len(b)), int64(len(b))}
+		hf.size = end
+	}
+	return nil
+}
+
+// all reads every hand in the file, oldest first.
+func (hf *historyFile) all() ([]*HandHistory, error) {
+	hf.lock.Lock()
+	err := hf.index()
+	size := hf.size
+	hf.lock.Unlock()
+	if err != nil || size == 0 {
+		return nil, err
+	}
+
+	f, err := os.Open(hf.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var hands []*HandHistory
+	scanner := bufio.NewScanner(io.NewSectionReader(f, 0, size))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		h := &HandHistory{}
+		if err := json.Unmarshal(scanner.Bytes(), h); err != nil {
+			return nil, err
+		}
+		hands = append(hands, h)
+	}
+	return hands, scanner.Err()
+}
+
+// hand reads the hand with id, or returns nil if the file does not hold it.
+func (hf *historyFile) hand(id int64) (*HandHistory, error) {
+	hf.lock.Lock()
+	err := hf.index()
+	line, ok := hf.hands[id]
+	hf.lock.Unlock()
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	f, err := os.Open(hf.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := make([]byte, line.n)
+	if _, err := f.ReadAt(b, line.off); err != nil {
+		return nil, err
+	}
+	h := &HandHistory{}
+	return h, json.Unmarshal(b, h)
+}
+
+// saveHistory appends h to the file of its room.
+func saveHistory(h *HandHistory) error {
+	if hf := roomHistoryFile(h.Room); hf != nil {
+		return hf.append(h)
+	}
+	return nil
+}
+
+// loadHistories reads the saved histories of room, oldest first. ok is
+// false if histories are not saved.
+func loadHistories(room string) (hands []*HandHistory, ok bool, err error) {
+	hf := roomHistoryFile(room)
+	if hf == nil {
+		return nil, false, nil
+	}
+	hands, err = hf.all()
+	return hands, true, err
+}
+
+// loadHistory reads the saved history of the hand with id in room, or
+// returns nil.
+func loadHistory(room string, id int64) (*HandHistory, error) {
+	if hf := roomHistoryFile(room); hf != nil {
+		return hf.hand(id)
+	}
+	return nil, nil
+}
+
+// roomHistories returns the recorded hands of the room with id, oldest
+// first: every hand saved, even of a room gone since, or else the hands
+// the live room keeps in memory.
+func roomHistories(id string) ([]*HandHistory, error) {
+	hands, ok, err := loadHistories(id)
+	if ok {
+		return hands, err
+	}
+	if room := lookupRoom(id); room != nil {
+		return room.Histories(), nil
+	}
+	return nil, nil
+}
+
+// Histories returns the room's recorded hands, oldest first.
+func (room *Room) Histories() []*HandHistory {
+	room.lock.Lock()
+	defer room.lock.Unlock()
+
+	return append([]*HandHistory(nil), room.histories...)
+}
+
+// HandHistory returns the recorded hand with id, or nil.
+func (room *Room) HandHistory(id int64) *HandHistory {
+	room.lock.Lock()
+	defer room.lock.Unlock()
+
+	for _, h := range room.histories {
+		if h.Id == id {
+			return h
+		}
+	}
+	return nil
+}
+
+// Seat returns the seat of the occupant with id, or nil if it was not dealt in.
+func (h *HandHistory) Seat(id string) *HistorySeat {
+	for _, seat := range h.Seats {
+		if seat.Id == id {
+			return seat
+		}
+	}
+	return nil
+}
+
+// View returns the history as seen by the player viewer: its own hole cards
+// and those shown at showdown.
+func (h *HandHistory) View(viewer string) *HandHistory {
+	shown := make(map[int]bool)
+	for _, hand := range h.Shown {
+		if !hand.Muck {
+			shown[hand.Pos] = true
+		}
+	}
+
+	v := *h
+	v.Seats = make([]*HistorySeat, len(h.Seats))
+	for i, seat := range h.Seats {
+		s := *seat
+		if s.Id != viewer && !shown[s.Pos] {
+			s.Cards = nil
+		}
+		v.Seats[i] = &s
+	}
+	return &v
+}
+
+// starsCard formats a card the PokerStars way, rank first: "Ah".
+func starsCard(card Card) string {
+	s := card.String()
+	if len(s) != 2 {
+		return ""
+	}
+	return s[1:] + strings.ToLower(s[:1])
+}
+
+func starsCards(cards []Card) string {
+	s := make([]string, len(cards))
+	for i, card := range cards {
+		s[i] = starsCard(card)
+	}
+	return "[" + strings.Join(s, " ") + "]"
+}
+
+var handNames = map[int]string{
+	HgihCard:      "high card",
+	OnePair:       "a pair",
+	TwoPair:       "two pair",
+	ThreeOfAKind:  "three of a kind",
+	Straight:      "a straight",
+	Flush:         "a flush",
+	FullHouse:     "a full house",
+	FourOfAKind:   "four of a kind",
+	StraightFlush: "a straight flush",
+	RoyalFlush:    "a royal flush",
+}
+
+var streets = []string{ActPreflop, ActFlop, ActTurn, ActRiver}
+
+var starsStreetNames = map[string]string{
+	ActFlop:  "Flop",
+	ActTurn:  "Turn",
+	ActRiver: "River",
+}
+
+func streetIndex(street string) int {
+	for i, s := range streets {
+		if s == street {
+			return i
+		}
+	}
+	return 0
+}
+
+// PokerStars formats the history as a PokerStars hand history, for
+// importing into tracking tools. Hole cards are dealt to hero, or to every
+// seat with cards in the history if hero is empty.
+func (h *HandHistory) PokerStars(hero string) string {
+	var b strings.Builder
+
+	name := make(map[int]string)
+	for _, seat := range h.Seats {
+		name[seat.Pos] = seat.displayName()
+	}
+	said := func(i int) {
+		for _, c := range h.Chat {
+			if c.After == i {
+				who := c.Id
+				if seat := h.Seat(c.Id); seat != nil {
+					who = seat.displayName()
+				}
+				fmt.Fprintf(&b, "%s said, \"%s\"\n", who, c.Text)
+			}
+		}
+	}
+
+	start, zone := h.Start.UTC(), "UTC"
+	if et, err := time.LoadLocation("America/New_York"); err == nil {
+		start, zone = h.Start.In(et), "ET"
+	}
+	fmt.Fprintf(&b, "PokerStars Hand #%d:  Hold'em No Limit (%d/%d) - %s %s\n",
+		h.Id, h.SB, h.BB, start.Format("2006/01/02 15:04:05"), zone)
+	fmt.Fprintf(&b, "Table '%s' %d-max Seat #%d is the button\n", h.Room, h.Max, h.Button)
+	for _, seat := range h.Seats {
+		fmt.Fprintf(&b, "Seat %d: %s (%d in chips)\n", seat.Pos, name[seat.Pos], seat.Chips)
+	}
+
+	holeCards := false
+	street := ActPreflop
+	high := 0 // highest bet on the street
+	folded := make(map[int]string)
+	for i, a := range h.Actions {
+		if !holeCards && a.Action != HistorySmallBlind && a.Action != HistoryBigBlind && a.Action != HistoryAnte {
+			h.starsHoleCards(&b, hero)
+			holeCards = true
+		}
+		said(i)
+		if a.Street != street {
+			h.starsBoard(&b, street, a.Street)
+			street = a.Street
+			high = 0
+		}
+
+		line := ""
+		switch a.Action {
+		case HistoryAnte:
+			line = fmt.Sprintf("posts the ante %d", a.Amount)
+		case HistorySmallBlind:
+			line = fmt.Sprintf("posts small blind %d", a.Amount)
+		case HistoryBigBlind:
+			line = fmt.Sprintf("posts big blind %d", a.Amount)
+			if a.Amount > a.Bet {
+				line = fmt.Sprintf("posts small & big blinds %d", a.Amount)
+			}
+		case ActFold:
+			line = "folds"
+			folded[a.Pos] = a.Street
+		case ActCheck:
+			line = "checks"
+		case ActCall:
+			line = fmt.Sprintf("calls %d", a.Amount)
+		case HistoryBet:
+			line = fmt.Sprintf("bets %d", a.Amount)
+		case ActRaise:
+			line = fmt.Sprintf("raises %d to %d", a.Bet-high, a.Bet)
+		}
+		if a.AllIn {
+			line += " and is all-in"
+		}
+		fmt.Fprintf(&b, "%s: %s\n", name[a.Pos], line)
+		if a.Bet > high {
+			high = a.Bet
+		}
+	}
+	if !holeCards {
+		h.starsHoleCards(&b, hero)
+	}
+	said(len(h.Actions))
+	// the rest of the board is dealt when nobody is left to act
+	if len(h.Board) >= 3 {
+		h.starsBoard(&b, street, streets[len(h.Board)-2])
+	}
+
+	for _, w := range h.Winners {
+		if w.Uncalled {
+			fmt.Fprintf(&b, "Uncalled bet (%d) returned to %s\n", w.Amount, name[w.Pos])
+		}
+	}
+	shown := make(map[int]*ShownHand)
+	if len(h.Shown) > 0 {
+		b.WriteString("*** SHOW DOWN ***\n")
+		for _, hand := range h.Shown {
+			shown[hand.Pos] = hand
+			if hand.Muck {
+				fmt.Fprintf(&b, "%s: mucks hand\n", name[hand.Pos])
+				continue
+			}
+			fmt.Fprintf(&b, "%s: shows %s (%s)\n", name[hand.Pos], starsCards(hand.Cards), handNames[hand.Hand>>16])
+		}
+	}
+	// an uncalled bet is a pot of its own, but no side pot
+	contested := len(h.Pots)
+	for _, w := range h.Winners {
+		if w.Uncalled {
+			contested--
+		}
+	}
+	won := make(map[int]int)
+	total := h.Rake
+	for _, w := range h.Winners {
+		if w.Uncalled {
+			continue
+		}
+		won[w.Pos] += w.Amount
+		total += w.Amount
+		pot := "pot"
+		if contested > 1 {
+			pot = "main pot"
+			if w.Pot > 0 {
+				pot = fmt.Sprintf("side pot-%d", w.Pot)
+			}
+		}
+		fmt.Fprintf(&b, "%s collected %d from %s\n", name[w.Pos], w.Amount, pot)
+	}
+
+	b.WriteString("*** SUMMARY ***\n")
+	fmt.Fprintf(&b, "Total pot %d | Rake %d\n", total, h.Rake)
+	if len(h.Board) > 0 {
+		fmt.Fprintf(&b, "Board %s\n", starsCards(h.Board))
+	}
+	for _, seat := range h.Seats {
+		role := ""
+		if seat.Pos == h.Button {
+			role = " (button)"
+		}
+		for _, a := range h.Actions {
+			if a.Pos == seat.Pos && a.Action == HistorySmallBlind {
+				role += " (small blind)"
+			} else if a.Pos == seat.Pos && a.Action == HistoryBigBlind {
+				role += " (big blind)"
+			}
+		}
+
+		result := ""
+		switch hand := shown[seat.Pos]; {
+		case folded[seat.Pos] == ActPreflop:
+			result = "folded before Flop"
+		case folded[seat.Pos] != "":
+			result = "folded on the " + starsStreetNames[folded[seat.Pos]]
+		case hand != nil && hand.Muck:
+			result = "mucked"
+		case hand != nil && won[seat.Pos] > 0:
+			result = fmt.Sprintf("showed %s and won (%d) with %s", starsCards(hand.Cards), won[seat.Pos], handNames[hand.Hand>>16])
+		case hand != nil:
+			result = fmt.Sprintf("showed %s and lost with %s", starsCards(hand.Cards), handNames[hand.Hand>>16])
+		case won[seat.Pos] > 0:
+			result = fmt.Sprintf("collected (%d)", won[seat.Pos])
+		}
+		fmt.Fprintf(&b, "Seat %d: %s%s %s\n", seat.Pos, name[seat.Pos], role, result)
+	}
+	return b.String()
+}
+
+// starsBoard writes the street headers after street from, up to street to.
+func (h *HandHistory) starsBoard(b *strings.Builder, from, to string) {
+	for i := streetIndex(from) + 1; i <= streetIndex(to); i++ {
+		switch streets[i] {
+		case ActFlop:
+			fmt.Fprintf(b, "*** FLOP *** %s\n", starsCards(h.Board[:3]))
+		case ActTurn:
+			fmt.Fprintf(b, "*** TURN *** %s [%s]\n", starsCards(h.Board[:3]), starsCard(h.Board[3]))
+		case ActRiver:
+			fmt.Fprintf(b, "*** RIVER *** %s [%s]\n", starsCards(h.Board[:4]), starsCard(h.Board[4]))
+		}
+	}
+}
+
+func (h *HandHistory) starsHoleCards(b *strings.Builder, hero string) {
+	b.WriteString("*** HOLE CARDS ***\n")
+	for _, seat := range h.Seats {
+		if len(seat.Cards) > 0 && (hero == "" || seat.Id == hero) {
+			fmt.Fprintf(b, "Dealt to %s %s\n", seat.displayName(), starsCards(seat.Cards))
+		}
+	}
+}
+
+func (seat *HistorySeat) displayName() string {
+	if seat.Name == "" {
+		return seat.Id
+	}
+	return seat.Name
+}
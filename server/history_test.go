@@ -0,0 +1,191 @@
+package poker
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func cards(s ...string) (cards []Card) {
+	for _, c := range s {
+		cards = append(cards, ParseCard(c))
+	}
+	return
+}
+
+// playHistoryHand plays a hand where a and b see a flop and b wins at
+// showdown, while c folds preflop.
+func playHistoryHand(t *testing.T) *Room {
+	room := newTestRoom(t, 1000, 1000, 1000)
+	a, b, c := room.Occupants[0], room.Occupants[1], room.Occupants[2]
+	a.Cards, b.Cards, c.Cards = cards("SA", "HA"), cards("SK", "HK"), cards("C2", "D7")
+	a.Name = "alice"
+	room.Button = c.Pos
+	room.hand = 1
+	room.remain, room.dealt = 3, 3
+	room.history = room.newHistory()
+
+	room.betting(a.Pos, 5, EntryBlind)
+	room.betting(b.Pos, 10, EntryBlind)
+	room.betting(c.Pos, -1, EntryBet)
+	room.betting(a.Pos, 25, EntryBet)
+	room.betting(b.Pos, 20, EntryBet)
+
+	room.ready()
+	room.Cards = cards("CK", "D3", "H9")
+	a.Hand, b.Hand = OnePair<<16, ThreeOfAKind<<16
+	room.betting(a.Pos, 0, EntryBet)
+	room.betting(b.Pos, 50, EntryBet)
+	room.betting(a.Pos, 50, EntryBet)
+	room.Cards = append(room.Cards, cards("S4", "C5")...)
+
+	room.showdown()
+	room.archiveHistory()
+	return room
+}
+
+func TestHandHistory(t *testing.T) {
+	room := playHistoryHand(t)
+	hands := room.Histories()
+	if len(hands) != 1 {
+		t.Fatalf("got %d histories, want 1", len(hands))
+	}
+	h := hands[0]
+	if room.HandHistory(h.Id) != h || room.history != nil {
+		t.Fatal("history not archived")
+	}
+	if len(h.Seats) != 3 || h.Seats[0].Chips != 1000 || len(h.Board) != 5 {
+		t.Fatalf("unexpected history %+v", h)
+	}
+
+	var actions []string
+	for _, a := range h.Actions {
+		actions = append(actions, a.Street+" "+a.Id+" "+a.Action)
+	}
+	want := []string{
+		"preflop a small blind", "preflop b big blind", "preflop c fold", "preflop a raise", "preflop b call",
+		"flop a check", "flop b bet", "flop a call",
+	}
+	if strings.Join(actions, ",") != strings.Join(want, ",") {
+		t.Fatalf("actions %v, want %v", actions, want)
+	}
+	if len(h.Winners) != 1 || h.Winners[0].Id != "b" || h.Winners[0].Amount != 160 {
+		t.Fatalf("winners %+v", h.Winners)
+	}
+
+	b, err := json.Marshal(h.View("c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &HandHistory{}
+	if err := json.Unmarshal(b, v); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Seats[2].Cards) != 2 || len(v.Seats[0].Cards) != 2 {
+		t.Fatal("own or shown cards missing from the view")
+	}
+	if len(h.View("x").Seats[2].Cards) != 0 {
+		t.Fatal("folded cards visible to another player")
+	}
+}
+
+func TestHandHistoryDir(t *testing.T) {
+	SetHistoryDir(t.TempDir())
+	defer SetHistoryDir("")
+
+	room := playHistoryHand(t)
+	played := room.Histories()[0]
+	room.history = room.newHistory()
+	room.archiveHistory()
+	DelRoom(room) // the room is gone, its hands are not
+
+	hands, err := roomHistories(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hands) != 2 || hands[0].Id != played.Id || hands[1].Id <= played.Id {
+		t.Fatalf("saved %d hands", len(hands))
+	}
+	if h := hands[0]; len(h.Actions) != len(played.Actions) || h.Winners[0].Amount != 160 || h.Seats[1].Cards[0] != played.Seats[1].Cards[0] {
+		t.Fatalf("saved hand %+v", h)
+	}
+}
+
+func TestHandHistoryIndex(t *testing.T) {
+	dir := t.TempDir()
+	SetHistoryDir(dir)
+	defer SetHistoryDir("")
+
+	for i := int64(1); i <= 3; i++ {
+		saveHistory(&HandHistory{Id: i, Room: "r", Hand: int(i)})
+	}
+	saveHistory(&HandHistory{Id: 4, Room: "other"})
+	if h, err := loadHistory("r", 2); err != nil || h == nil || h.Hand != 2 {
+		t.Fatalf("hand 2: %+v, %v", h, err)
+	}
+
+	// a restart indexes the file again, leaving out a line cut short
+	f, _ := os.OpenFile(filepath.Join(dir, "r.jsonl"), os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`{"id":5,"ro`)
+	f.Close()
+	SetHistoryDir(dir)
+	if h, err := loadHistory("r", 3); err != nil || h == nil || h.Hand != 3 {
+		t.Fatalf("hand 3 after a restart: %+v, %v", h, err)
+	}
+	if h, _ := loadHistory("r", 4); h != nil {
+		t.Fatal("hand of another room")
+	}
+	if hands, _, err := loadHistories("r"); err != nil || len(hands) != 3 {
+		t.Fatalf("%d hands, %v", len(hands), err)
+	}
+}
+
+func TestHandHistoryPokerStars(t *testing.T) {
+	h := playHistoryHand(t).Histories()[0]
+	text := h.View("b").PokerStars("b")
+
+	for _, line := range []string{
+		"Hold'em No Limit (5/10)",
+		"Table '" + t.Name() + "' 3-max Seat #3 is the button",
+		"Seat 1: alice (1000 in chips)",
+		"alice: posts small blind 5\nb: posts big blind 10\n*** HOLE CARDS ***\nDealt to b [Ks Kh]\nc: folds\nalice: raises 20 to 30\nb: calls 20\n",
+		"*** FLOP *** [Kc 3d 9h]\nalice: checks\nb: bets 50\nalice: calls 50\n*** TURN *** [Kc 3d 9h] [4s]\n*** RIVER *** [Kc 3d 9h 4s] [5c]\n",
+		"*** SHOW DOWN ***",
+		"b collected 160 from pot",
+		"Total pot 160 | Rake 0",
+		"Board [Kc 3d 9h 4s 5c]",
+		"Seat 2: b (big blind) showed [Ks Kh] and won (160) with three of a kind",
+		"Seat 3: c (button) folded before Flop",
+	} {
+		if !strings.Contains(text, line) {
+			t.Errorf("missing %q in\n%s", line, text)
+		}
+	}
+	if strings.Contains(text, "Dealt to alice") {
+		t.Error("cards dealt to another player")
+	}
+}
+
+func TestHandHistoryPokerStarsUncalled(t *testing.T) {
+	h := &HandHistory{
+		Id: 1, Room: "r", SB: 5, BB: 10, Max: 2, Button: 1,
+		Seats: []*HistorySeat{{Pos: 1, Id: "a", Chips: 1000}, {Pos: 2, Id: "b", Chips: 100}},
+		Pots:  []int{200, 900},
+		Winners: []*HistoryWin{
+			{Pot: 0, Pos: 2, Id: "b", Amount: 200},
+			{Pot: 1, Pos: 1, Id: "a", Amount: 900, Uncalled: true},
+		},
+	}
+	text := h.PokerStars("")
+	if !strings.Contains(text, "b collected 200 from pot\n") {
+		t.Fatalf("uncalled bet named as a side pot in\n%s", text)
+	}
+
+	h.Pots = []int{200, 300}
+	h.Winners[1] = &HistoryWin{Pot: 1, Pos: 1, Id: "a", Amount: 300}
+	if text := h.PokerStars(""); !strings.Contains(text, "b collected 200 from main pot\na collected 300 from side pot-1\n") {
+		t.Fatalf("side pot missing in\n%s", text)
+	}
+}
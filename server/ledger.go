@@ -0,0 +1,172 @@
+package poker
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errHalted = errors.New("table halted: chips not conserved")
+
+// Ledger entry kinds.
+const (
+	EntryBuyIn   = "buyin"
+	EntryBlind   = "blind"
+	EntryAnte    = "ante"
+	EntryBet     = "bet"
+	EntryAward   = "award"
+	EntryRake    = "rake"
+	EntryCashOut = "cashout"
+)
+
+// Ledger accounts other than the players'. The cashier stands for the
+// escrow on chain: it goes negative by the chips bought in and not yet
+// cashed out.
+const (
+	AccountCashier = "cashier"
+	AccountPot     = "pot"
+	AccountHouse   = "house"
+)
+
+// PlayerAccount is the ledger account holding a player's stack.
+func PlayerAccount(id string) string {
+	return "player:" + id
+}
+
+// LedgerEntry moves Amount chips from one account to another, so every
+// entry balances.
+type LedgerEntry struct {
+	Hand   int       `json:"hand"`
+	Kind   string    `json:"kind"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Amount int       `json:"amount"`
+	Time   time.Time `json:"time"`
+}
+
+// Ledger records every chip movement of a room.
+type Ledger struct {
+	lock     sync.Mutex
+	entries  []*LedgerEntry
+	balances map[string]int
+}
+
+func NewLedger() *Ledger {
+	return &Ledger{
+		balances: make(map[string]int),
+	}
+}
+
+// Transfer records amount chips moving from one account to another.
+// Zero amounts are not recorded.
+func (l *Ledger) Transfer(hand int, kind, from, to string, amount int) {
+	if amount == 0 {
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.entries = append(l.entries, &LedgerEntry{
+		Hand:   hand,
+		Kind:   kind,
+		From:   from,
+		To:     to,
+		Amount: amount,
+		Time:   time.Now(),
+	})
+	l.balances[from] -= amount
+	l.balances[to] += amount
+}
+
+// Balance returns the chips held by account.
+func (l *Ledger) Balance(account string) int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.balances[account]
+}
+
+// Balances returns every account's balance.
+func (l *Ledger) Balances() map[string]int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	balances := make(map[string]int, len(l.balances))
+	for account, n := range l.balances {
+		balances[account] = n
+	}
+	return balances
+}
+
+// Entries returns the entries of hand, or all entries if hand is negative.
+func (l *Ledger) Entries(hand int) []LedgerEntry {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var entries []LedgerEntry
+	for _, e := range l.entries {
+		if hand < 0 || e.Hand == hand {
+			entries = append(entries, *e)
+		}
+	}
+	return entries
+}
+
+// checkChips asserts that the chips of the hand just played are conserved:
+// the pots went to the house and the winners, the pot account is empty,
+// every seated occupant's stack matches its account and no player account
+// holds chips without a seat.
+func (room *Room) checkChips() error {
+	if n := room.ledger.Balance(AccountPot); n != 0 {
+		return fmt.Errorf("hand %d: %d chips left in the pot", room.hand, n)
+	}
+
+	pots, paid := 0, room.rake
+	for _, n := range room.Pot {
+		pots += n
+	}
+	for _, n := range room.Chips {
+		paid += n
+	}
+	if pots != paid {
+		return fmt.Errorf("hand %d: pots of %d chips paid out %d", room.hand, pots, paid)
+	}
+
+	seated := make(map[string]bool)
+	for _, o := range room.Occupants {
+		if o == nil {
+			continue
+		}
+		seated[PlayerAccount(o.Id)] = true
+		if n := room.ledger.Balance(PlayerAccount(o.Id)); n != o.Chips {
+			return fmt.Errorf("hand %d: %s has %d chips, ledger %d", room.hand, o.Id, o.Chips, n)
+		}
+	}
+	for account, n := range room.ledger.Balances() {
+		if n != 0 && strings.HasPrefix(account, PlayerAccount("")) && !seated[account] {
+			return fmt.Errorf("hand %d: %d chips left with %s", room.hand, n, account)
+		}
+	}
+	return nil
+}
+
+// halt stops the room after its chips did not add up: no more hands are
+// dealt and nothing is settled, so the escrow stays put until the ledger
+// is audited.
+func (room *Room) halt(err error) {
+	log.Println("chips not conserved", room.Id, err)
+	room.halted.Store(true)
+	room.Each(0, func(o *Occupant) bool {
+		o.SendError(4, errHalted.Error())
+		return true
+	})
+}
+
+// Ledger returns the room's chip ledger.
+func (room *Room) Ledger() *Ledger {
+	return room.ledger
+}
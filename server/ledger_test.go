@@ -0,0 +1,159 @@
+package poker
+
+import (
+	"testing"
+)
+
+func TestLedgerHand(t *testing.T) {
+	room := newTestRoom(t, 1000, 1000, 500)
+	for _, o := range room.Occupants {
+		room.ledger.Transfer(room.hand, EntryBuyIn, AccountCashier, PlayerAccount(o.Id), o.Chips)
+	}
+	room.hand++
+
+	a, b, c := room.Occupants[0], room.Occupants[1], room.Occupants[2]
+	for i, o := range room.Occupants {
+		o.Cards = []Card{Card(i), Card(i + 4)}
+		room.remain++
+	}
+	a.Hand, b.Hand, c.Hand = 300, 200, 100
+
+	room.betting(a.Pos, 5, EntryBlind)
+	room.betting(b.Pos, 10, EntryBlind)
+	room.betting(c.Pos, 500, EntryBet) // all in
+	room.betting(a.Pos, 795, EntryBet)
+	room.betting(b.Pos, 790, EntryBet)
+	room.showdown()
+
+	if err := room.checkChips(); err != nil {
+		t.Fatal(err)
+	}
+	if a.Chips != 2300 || b.Chips != 200 || c.Chips != 0 {
+		t.Fatalf("stacks %d %d %d", a.Chips, b.Chips, c.Chips)
+	}
+	if n := room.ledger.Balance(AccountCashier); n != -2500 {
+		t.Fatalf("cashier %d, want -2500", n)
+	}
+
+	entries := room.ledger.Entries(room.hand)
+	if len(entries) != 6 {
+		t.Fatalf("got %d entries for the hand, want 6", len(entries))
+	}
+	if entries[0].Kind != EntryBlind || entries[0].Amount != 5 || entries[0].To != AccountPot {
+		t.Fatalf("unexpected first entry %+v", entries[0])
+	}
+	if len(room.ledger.Entries(-1)) != 9 {
+		t.Fatal("buy-ins missing from the ledger")
+	}
+}
+
+func TestLedgerCheckChips(t *testing.T) {
+	room := newTestRoom(t, 1000, 1000)
+	for _, o := range room.Occupants {
+		room.ledger.Transfer(room.hand, EntryBuyIn, AccountCashier, PlayerAccount(o.Id), o.Chips)
+	}
+	if err := room.checkChips(); err != nil {
+		t.Fatal(err)
+	}
+
+	room.Occupants[0].Chips += 10
+	if err := room.checkChips(); err == nil {
+		t.Fatal("chips created outside the ledger not detected")
+	}
+	room.Occupants[0].Chips -= 10
+
+	room.ledger.Transfer(room.hand, EntryBet, PlayerAccount("a"), AccountPot, 10)
+	room.Occupants[0].Chips -= 10
+	if err := room.checkChips(); err == nil {
+		t.Fatal("chips left in the pot not detected")
+	}
+
+	// the pot is paid out, but not all of it
+	room.Pot = []int{10}
+	room.Chips[0] = 5
+	room.ledger.Transfer(room.hand, EntryAward, AccountPot, PlayerAccount("a"), 10)
+	room.Occupants[0].Chips += 10
+	if err := room.checkChips(); err == nil {
+		t.Fatal("pot paid out short not detected")
+	}
+	room.Chips[0] = 10
+	if err := room.checkChips(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a player left without cashing out
+	b := room.Occupants[1]
+	room.DelOccupant(b)
+	if err := room.checkChips(); err == nil {
+		t.Fatal("chips left with a player gone not detected")
+	}
+}
+
+func TestLedgerHalt(t *testing.T) {
+	local, _ := NewLocalSettler("")
+	ob, _ := NewOutbox(local, "")
+	old := outbox
+	SetOutbox(ob)
+	defer SetOutbox(old)
+
+	room := newTestRoom(t, 1000, 1000)
+	a := room.Occupants[0]
+	room.Occupants[0].Chips += 10 // not in the ledger
+	room.halt(room.checkChips())
+	if messages := received(t, a); len(messages) != 1 {
+		t.Fatalf("players told %d times of the halt", len(messages))
+	}
+
+	room.start()
+	if room.hand != 0 {
+		t.Fatal("halted room dealt a hand")
+	}
+	a.CashOut()
+	if n := len(ob.List("")); n != 0 {
+		t.Fatalf("halted room settled %d times", n)
+	}
+}
+
+func TestLedgerUncalledLeaver(t *testing.T) {
+	ob, _ := NewOutbox(nil, "")
+	old := outbox
+	SetOutbox(ob)
+	defer SetOutbox(old)
+
+	room := newTestRoom(t, 1000, 1000, 1000)
+	for _, o := range room.Occupants {
+		room.ledger.Transfer(room.hand, EntryBuyIn, AccountCashier, PlayerAccount(o.Id), o.Chips)
+	}
+	room.hand++
+	room.history = &HandHistory{}
+
+	a, b, c := room.Occupants[0], room.Occupants[1], room.Occupants[2]
+	for i, o := range room.Occupants {
+		o.Cards = []Card{Card(i), Card(i + 4)}
+		room.remain++
+	}
+	a.Hand, b.Hand = 100, 200
+
+	room.betting(a.Pos, 5, EntryBlind)
+	room.betting(b.Pos, 10, EntryBlind)
+	room.betting(c.Pos, 110, EntryBet)
+	// c cashes out before anyone calls, leaving 100 chips nobody contends
+	c.CashOut()
+	room.betting(a.Pos, -1, EntryBet)
+	room.showdown()
+
+	if err := room.checkChips(); err != nil {
+		t.Fatal(err)
+	}
+	if a.Chips != 995 || b.Chips != 1115 {
+		t.Fatalf("stacks %d %d", a.Chips, b.Chips)
+	}
+	for _, w := range room.history.Winners {
+		if w.Pos != b.Pos || w.Uncalled {
+			t.Fatalf("winner %+v", w)
+		}
+	}
+	if s := ob.List(""); len(s) != 1 || s[0].Settlement.Chips[0] != 890 {
+		t.Fatalf("c cashed out %+v", s)
+	}
+}
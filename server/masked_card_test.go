@@ -1,17 +1,28 @@
 package poker
 
 import (
+	"mental-poker/mental_poker"
 	"testing"
 )
 
 func TestNewDeckMasked(t *testing.T) {
-	deck, err := NewDeckMasked()
-	if err != nil {
-		t.Fatal(err)
+	var initialCards []mental_poker.InitialCard
+	var shuffled []string
+	for _, suite := range []string{"Heart", "Club", "Spade", "Diamond"} {
+		for _, value := range []string{"Two", "Three", "Four", "Five", "Six", "Seven", "Eight", "Nine", "Ten", "Jack", "Queen", "King", "Ace"} {
+			card := suite + value
+			initialCards = append(initialCards, mental_poker.InitialCard{
+				Card:        card,
+				ClassicCard: mental_poker.ClassicCard{Suite: suite, Value: value},
+			})
+			shuffled = append(shuffled, card)
+		}
 	}
+
+	deck := NewDeckMasked(initialCards, shuffled)
 	suiteSet := make(map[string]struct{})
 	valueSet := make(map[string]struct{})
-	for _, card := range deck.Cards {
+	for _, card := range deck.CardMap {
 		suiteSet[card.ClassicCard.Suite] = struct{}{}
 		valueSet[card.ClassicCard.Value] = struct{}{}
 		t.Log(card.ClassicCard)
@@ -23,5 +34,5 @@ func TestNewDeckMasked(t *testing.T) {
 	for valueName, _ := range valueSet {
 		t.Log(valueName)
 	}
-	t.Log(deck.Cards)
+	t.Log(deck.CardMap)
 }
@@ -28,9 +28,14 @@ type Occupant struct {
 	Cards       []Card                      `json:"cards,omitempty"`
 	RevealCards []*mental_poker.ReceiveCard `json:"reveal_cards,omitempty"`
 	Hand        int                         `json:"hand,omitempty"`
+	AutoMuck    bool                        `json:"automuck,omitempty"`
+	SittingOut  bool                        `json:"sitout,omitempty"`
+	WaitBB      bool                        `json:"waitbb,omitempty"` // for the big blind to sit in
+	Owed        int                         `json:"owed,omitempty"`   // missed blinds to post
 
-	conn *Conn
-	Room *Room `json:"-"`
+	conn    *Conn
+	Room    *Room  `json:"-"`
+	address string // Sui address it signed in with, empty for AuthPlain
 
 	recv       chan *Message
 	Actions    chan *Message        `json:"-"`
@@ -38,6 +43,19 @@ type Occupant struct {
 	player     *mental_poker.Player `json:"-"`
 	cancelFunc context.CancelFunc   `json:"-"`
 	stopped    *atomic.Bool         `json:"-"`
+	offline    *atomic.Bool         // its connection closed, and it did not reconnect
+	session    *session
+	watching   *Room // room watched as a spectator
+	chat       *chatState
+	timeouts   int  // actions timed out in a row
+	missedSB   bool // while sitting out
+	missedBB   bool
+	out        bool // dealt out of the current hand
+
+	rebuys    []*Deposit // chips bought for the next hand
+	autoRebuy *Deposit   // to buy back in once busted
+
+	muck *bool // chosen for the current hand, over AutoMuck
 }
 
 func NewOccupant(id string, conn *Conn) *Occupant {
@@ -50,6 +68,9 @@ func NewOccupant(id string, conn *Conn) *Occupant {
 		Profile:    "https://avatars.githubusercontent.com/u/18323181?s=96&v=4",
 		cancelFunc: cancelFunc,
 		stopped:    &atomic.Bool{},
+		offline:    &atomic.Bool{},
+		session:    newSession(),
+		chat:       &chatState{},
 	}
 	o.Start(ctx)
 	return o
@@ -73,7 +94,7 @@ func (o *Occupant) Start(ctx context.Context) {
 			default:
 			}
 			m := &Message{}
-			if err := o.conn.ReadJSON(m); err != nil {
+			if err := o.conn.ReadMessage(m); err != nil {
 				o.stop()
 				return
 			}
@@ -93,6 +114,7 @@ func (o *Occupant) ReconnectWith(conn *Conn) {
 	o.recv = make(chan *Message, 128)
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	o.cancelFunc = cancelFunc
+	o.offline.Store(false)
 	o.Start(ctx)
 }
 
@@ -110,14 +132,42 @@ func (o *Occupant) Broadcast(message *Message) {
 			oc.SendMessage(message)
 		}
 	}
+	for _, oc := range o.Room.spectatorList() {
+		oc.SendMessage(message)
+	}
 }
 
 func (o *Occupant) SendMessage(message *Message) error {
-	return o.conn.WriteJSON(message)
+	return o.session.send(message.View(o), o.write)
+}
+
+func (o *Occupant) write(message *Message) error {
+	return o.conn.WriteMessage(message.Encode(o.conn.Version()))
+}
+
+// Resume sends every message after sequence number last. If they are no
+// longer all buffered, the occupant is sent the current room state instead.
+func (o *Occupant) Resume(last uint64) {
+	if o.session.replay(last, o.write) {
+		return
+	}
+
+	room := o.Room
+	if room == nil || room.Occupant(o.Id) == nil {
+		room = o.watching
+	}
+	if room != nil {
+		o.SendMessage(&Message{
+			From:   room.Id,
+			Type:   MsgPresence,
+			Action: ActState,
+			Room:   room,
+		})
+	}
 }
 
 func (o *Occupant) SendError(code int, err string) error {
-	return o.conn.WriteJSON(NewError(code, err))
+	return o.conn.WriteError(NewError(code, err))
 }
 
 func (o *Occupant) GetMessage(timeout time.Duration) (*Message, error) {
@@ -159,6 +209,10 @@ func (o *Occupant) Betting(n int) (raised bool) {
 		o.Action = ActRaise
 		o.Chips -= n
 		o.Bet += n
+		if o.Bet-room.Bet >= room.raise {
+			// an all-in short of a full raise does not change its size
+			room.raise = o.Bet - room.Bet
+		}
 		room.Bet = o.Bet
 		raised = true
 	}
@@ -219,6 +273,12 @@ func (o *Occupant) GetAction(timeout time.Duration) (*Message, error) {
 //}
 
 func (o *Occupant) JoinRoom(room *Room, chips int) {
+	o.JoinRoomAt(room, chips, 0)
+}
+
+// JoinRoomAt seats o in room at pos, or at its reserved or the first free
+// seat for pos 0, with chips. o is left as it was if there is no seat.
+func (o *Occupant) JoinRoomAt(room *Room, chips int, pos int) {
 	existOccupant := room.Occupant(o.Id)
 	if existOccupant != nil {
 		o.SendMessage(&Message{
@@ -230,23 +290,29 @@ func (o *Occupant) JoinRoom(room *Room, chips int) {
 		return
 	}
 
-	o.Bet = 0
-	o.Cards = nil
-	o.Hand = 0
-	o.Action = ""
-	o.Pos = 0
-	o.Room = room
-	if chips == 0 {
-		chips = 100_000_000
-	}
-	o.Chips = chips
-	//log.Println("user join with  chips", o.Name, chips)
-
 	player := mental_poker.NewPlayer(room.game)
 	player.Setup()
-	o.SetPlayer(player)
-
-	room.AddOccupant(o)
+	if room.seat(o, pos, func() {
+		o.Bet = 0
+		o.Cards = nil
+		o.Hand = 0
+		o.Action = ""
+		o.Chips = chips
+		o.SittingOut = false
+		o.timeouts = 0
+		o.clearOwed()
+		if room.hand > 0 {
+			// joining a game in play: post a big blind, or wait for it
+			o.WaitBB = true
+			o.missedBB = true
+			o.Owed = room.BB
+		}
+		o.SetPlayer(player)
+		room.ledger.Transfer(room.hand, EntryBuyIn, AccountCashier, PlayerAccount(o.Id), chips)
+	}) == 0 {
+		return
+	}
+	o.Unwatch()
 	if room.N > 2 {
 		room.TryStart()
 	}
@@ -277,6 +343,9 @@ func (o *Occupant) Leave() (room *Room) {
 		Occupant: o,
 	})
 	room.DelOccupant(o)
+	room.lock.Lock()
+	o.dropRebuys()
+	room.lock.Unlock()
 
 	o.Bet = 0
 	o.Cards = nil
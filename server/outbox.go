@@ -0,0 +1,483 @@
+package poker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	outboxPoll        = time.Second
+	outboxBackoff     = 5 * time.Second
+	outboxMaxBackoff  = 10 * time.Minute
+	outboxMaxAttempts = 20
+	outboxKeep        = 24 * time.Hour // confirmed entries, before pruning
+)
+
+var errUnknownSettlement = errors.New("unknown settlement")
+
+// depositClaim is a deposit the server took for chips.
+type depositClaim struct {
+	Game       string `json:"game"`
+	Player     string `json:"player"`
+	Played     bool   `json:"played,omitempty"`     // its chips are in play
+	Settlement string `json:"settlement,omitempty"` // key of the settlement consuming it
+}
+
+// outboxFile is the outbox as stored. Files written before claims were
+// stored hold the entries alone.
+type outboxFile struct {
+	Entries []*OutboxEntry           `json:"entries"`
+	Claims  map[string]*depositClaim `json:"claims,omitempty"`
+}
+
+// OutboxEntry is one settlement and its delivery state.
+type OutboxEntry struct {
+	Id          string      `json:"id"`
+	Settlement  *Settlement `json:"settlement"`
+	Status      string      `json:"status"`
+	TxID        string      `json:"tx_id,omitempty"`
+	Attempts    int         `json:"attempts"`
+	LastError   string      `json:"last_error,omitempty"`
+	NextAttempt time.Time   `json:"next_attempt"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+	Seq         uint64      `json:"seq"` // orders entries queued at once
+}
+
+// Outbox stores settlements before they are submitted and delivers them in
+// the background, retrying with backoff. Entries are keyed by
+// Settlement.Key, so a settlement enqueued twice is only paid once, and the
+// settler is trusted to pay a key at most once when it is resubmitted.
+// With a file, the outbox survives restarts. Confirmed entries are pruned
+// after outboxKeep.
+//
+// The outbox also keeps the deposits claimed by buy-ins, so a deposit is
+// never played twice, and names the played deposits a settlement consumes
+// on chain.
+type Outbox struct {
+	lock    sync.Mutex
+	path    string
+	settler Settler
+	entries map[string]*OutboxEntry
+	claims  map[string]*depositClaim // by deposit id
+	busy    map[string]bool          // entries a Process pass is delivering
+	seq     uint64                   // of the last entry queued
+	notify  chan struct{}
+}
+
+// NewOutbox returns an outbox delivering to settler, stored in the file
+// at path, or in memory only if path is empty.
+func NewOutbox(settler Settler, path string) (*Outbox, error) {
+	ob := &Outbox{
+		path:    path,
+		settler: settler,
+		entries: make(map[string]*OutboxEntry),
+		claims:  make(map[string]*depositClaim),
+		busy:    make(map[string]bool),
+		notify:  make(chan struct{}, 1),
+	}
+	if path == "" {
+		return ob, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ob, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file outboxFile
+	if len(b) > 0 && b[0] == '[' {
+		err = json.Unmarshal(b, &file.Entries)
+	} else {
+		err = json.Unmarshal(b, &file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range file.Entries {
+		ob.entries[e.Id] = e
+		ob.seq = max(ob.seq, e.Seq)
+	}
+	for id, c := range file.Claims {
+		ob.claims[id] = c
+	}
+	return ob, nil
+}
+
+var (
+	// outbox only queues until the server sets one up with a settler
+	outbox, _ = NewOutbox(nil, "")
+)
+
+// SetOutbox replaces the outbox used by all rooms.
+func SetOutbox(ob *Outbox) {
+	outbox = ob
+}
+
+// save writes all entries and claims to the outbox file. Called with the
+// lock held.
+func (ob *Outbox) save() error {
+	if ob.path == "" {
+		return nil
+	}
+
+	return writeJSON(ob.path, &outboxFile{Entries: ob.list(""), Claims: ob.claims})
+}
+
+// writeJSON replaces the file at path with v as JSON, so that a crash
+// leaves either the old file or the new one.
+func writeJSON(path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Enqueue durably records s for delivery. A settlement consumes the played
+// deposits of its game, or of its player for a cash-out, unless it names
+// its deposits already. If a settlement with the same key is already
+// queued, that entry is returned and s is dropped.
+func (ob *Outbox) Enqueue(s *Settlement) (*OutboxEntry, error) {
+	ob.lock.Lock()
+	defer ob.lock.Unlock()
+
+	consumed := s.Deposits == nil
+	if consumed {
+		s.Deposits = ob.played(s)
+	}
+	id := s.Key()
+	if e, ok := ob.entries[id]; ok {
+		if consumed {
+			s.Deposits = nil
+		}
+		return e, nil
+	}
+
+	now := time.Now()
+	e := &OutboxEntry{
+		Id:          id,
+		Settlement:  s,
+		Status:      SettlementPending,
+		NextAttempt: now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Seq:         ob.seq + 1,
+	}
+	if consumed {
+		for _, d := range s.Deposits {
+			ob.claims[d].Settlement = id
+		}
+	}
+	ob.entries[id] = e
+	ob.seq++
+	if err := ob.save(); err != nil {
+		delete(ob.entries, id)
+		if consumed {
+			for _, d := range s.Deposits {
+				ob.claims[d].Settlement = ""
+			}
+			s.Deposits = nil
+		}
+		return nil, err
+	}
+
+	select {
+	case ob.notify <- struct{}{}:
+	default:
+	}
+	return e, nil
+}
+
+// Get returns a copy of the entry with id.
+func (ob *Outbox) Get(id string) (OutboxEntry, error) {
+	ob.lock.Lock()
+	defer ob.lock.Unlock()
+
+	e, ok := ob.entries[id]
+	if !ok {
+		return OutboxEntry{}, errUnknownSettlement
+	}
+	return *e, nil
+}
+
+// List returns the entries with status, or all entries if status is empty,
+// oldest first.
+func (ob *Outbox) List(status string) []*OutboxEntry {
+	ob.lock.Lock()
+	defer ob.lock.Unlock()
+
+	var entries []*OutboxEntry
+	for _, e := range ob.list(status) {
+		c := *e
+		entries = append(entries, &c)
+	}
+	return entries
+}
+
+func (ob *Outbox) list(status string) []*OutboxEntry {
+	entries := make([]*OutboxEntry, 0, len(ob.entries))
+	for _, e := range ob.entries {
+		if status == "" || e.Status == status {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].CreatedAt.Equal(entries[j].CreatedAt) {
+			return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+		}
+		return entries[i].Seq < entries[j].Seq
+	})
+	return entries
+}
+
+// Retry moves a failed entry back to pending.
+func (ob *Outbox) Retry(id string) error {
+	ob.lock.Lock()
+	defer ob.lock.Unlock()
+
+	e, ok := ob.entries[id]
+	if !ok {
+		return errUnknownSettlement
+	}
+	if e.Status != SettlementFailed {
+		return nil
+	}
+	e.Status = SettlementPending
+	e.Attempts = 0
+	e.NextAttempt = time.Now()
+	e.UpdatedAt = time.Now()
+	return ob.save()
+}
+
+// Run delivers queued settlements until ctx is done.
+func (ob *Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(outboxPoll)
+	defer ticker.Stop()
+
+	for {
+		ob.Process(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-ob.notify:
+		}
+	}
+}
+
+// Process makes one delivery pass: due pending entries are submitted,
+// submitted entries are checked for confirmation and old confirmed entries
+// are pruned. An entry is saved as submitting before it is handed to the
+// settler, and one left submitting by a crash is submitted again under the
+// same key. Entries are delivered in the order they were queued, so a
+// game's cash-outs are paid before the end of the game.
+func (ob *Outbox) Process(ctx context.Context) {
+	if ob.settler == nil {
+		return
+	}
+
+	ob.lock.Lock()
+	now := time.Now()
+	var due []OutboxEntry
+	pruned := false
+	for _, e := range ob.list("") {
+		id := e.Id
+		switch {
+		case ob.busy[id] || e.NextAttempt.After(now):
+		case e.Status == SettlementPending || e.Status == SettlementSubmitting || e.Status == SettlementSubmitted:
+			ob.busy[id] = true
+			due = append(due, *e)
+		case e.Status == SettlementConfirmed && now.Sub(e.UpdatedAt) > outboxKeep:
+			delete(ob.entries, id)
+			for _, d := range e.Settlement.Deposits {
+				delete(ob.claims, d)
+			}
+			pruned = true
+		}
+	}
+	if pruned {
+		if err := ob.save(); err != nil {
+			log.Println("outbox save", err)
+		}
+	}
+	ob.lock.Unlock()
+
+	for _, e := range due {
+		ob.deliver(ctx, &e)
+		ob.lock.Lock()
+		delete(ob.busy, e.Id)
+		ob.lock.Unlock()
+	}
+}
+
+// deliver moves e, a copy of a due entry, one step towards confirmation.
+func (ob *Outbox) deliver(ctx context.Context, e *OutboxEntry) {
+	if e.Status == SettlementSubmitted {
+		status, err := ob.settler.Status(ctx, e.TxID)
+		ob.update(e.Id, func(e *OutboxEntry) {
+			switch {
+			case err != nil:
+				e.NextAttempt = time.Now().Add(outboxBackoff)
+				e.LastError = err.Error()
+			case status == SettlementFailed:
+				e.fail(errors.New("transaction failed"))
+			default:
+				e.Status = status
+			}
+		})
+		return
+	}
+
+	// a transaction from an earlier attempt may have landed after all
+	if e.TxID != "" {
+		if status, err := ob.settler.Status(ctx, e.TxID); err == nil && status != SettlementFailed {
+			ob.update(e.Id, func(e *OutboxEntry) {
+				e.Status = status
+			})
+			return
+		}
+	}
+
+	if err := ob.update(e.Id, func(e *OutboxEntry) {
+		e.Status = SettlementSubmitting
+	}); err != nil {
+		return
+	}
+	txID, err := ob.settler.Submit(ctx, e.Settlement)
+	ob.update(e.Id, func(e *OutboxEntry) {
+		switch {
+		case errors.Is(err, ErrSettled):
+			e.Status = SettlementConfirmed
+			e.LastError = ""
+		case err != nil:
+			e.fail(err)
+		default:
+			e.TxID = txID
+			e.Status = SettlementSubmitted
+			e.LastError = ""
+			e.NextAttempt = time.Now().Add(outboxBackoff)
+		}
+	})
+}
+
+// update applies f to the entry with id, if it is still there, and saves
+// the outbox.
+func (ob *Outbox) update(id string, f func(e *OutboxEntry)) error {
+	ob.lock.Lock()
+	defer ob.lock.Unlock()
+
+	e, ok := ob.entries[id]
+	if !ok {
+		return errUnknownSettlement
+	}
+	f(e)
+	e.UpdatedAt = time.Now()
+	if err := ob.save(); err != nil {
+		log.Println("outbox save", err)
+		return err
+	}
+	return nil
+}
+
+// played returns the ids of the played deposits s pays out that no
+// settlement consumed yet. Called with the lock held.
+func (ob *Outbox) played(s *Settlement) []string {
+	var ids []string
+	for id, c := range ob.claims {
+		if c.Game != s.GameID || !c.Played || c.Settlement != "" {
+			continue
+		}
+		if s.CashOut && (len(s.Players) != 1 || c.Player != s.Players[0]) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// claim durably records that deposit id buys player in to game, unless it
+// was claimed before.
+func (ob *Outbox) claim(id, game, player string) error {
+	ob.lock.Lock()
+	defer ob.lock.Unlock()
+
+	if _, ok := ob.claims[id]; ok {
+		return errDepositUsed
+	}
+	ob.claims[id] = &depositClaim{Game: game, Player: player}
+	if err := ob.save(); err != nil {
+		delete(ob.claims, id)
+		return err
+	}
+	return nil
+}
+
+// play marks the claimed deposit id as in play, for the next settlement
+// to consume.
+func (ob *Outbox) play(id string) {
+	ob.lock.Lock()
+	defer ob.lock.Unlock()
+
+	if c, ok := ob.claims[id]; ok && !c.Played {
+		c.Played = true
+		if err := ob.save(); err != nil {
+			log.Println("outbox save", err)
+		}
+	}
+}
+
+// unclaim gives back deposit id, unless a settlement consumes it.
+func (ob *Outbox) unclaim(id string) {
+	ob.lock.Lock()
+	defer ob.lock.Unlock()
+
+	if c, ok := ob.claims[id]; ok && c.Settlement == "" {
+		delete(ob.claims, id)
+		if err := ob.save(); err != nil {
+			log.Println("outbox save", err)
+		}
+	}
+}
+
+// fail records a failed attempt and schedules the next one, or gives up.
+func (e *OutboxEntry) fail(err error) {
+	e.Attempts++
+	e.LastError = err.Error()
+	if e.Attempts >= outboxMaxAttempts {
+		e.Status = SettlementFailed
+		return
+	}
+
+	e.Status = SettlementPending
+	backoff := outboxBackoff << (e.Attempts - 1)
+	if backoff > outboxMaxBackoff || backoff <= 0 {
+		backoff = outboxMaxBackoff
+	}
+	e.NextAttempt = time.Now().Add(backoff)
+}
@@ -0,0 +1,308 @@
+package poker
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+type failingSettler struct {
+	submits int
+}
+
+func (st *failingSettler) Submit(ctx context.Context, s *Settlement) (string, error) {
+	st.submits++
+	return "", errors.New("rpc unavailable")
+}
+
+func (st *failingSettler) Status(ctx context.Context, txID string) (string, error) {
+	return "", errUnknownTx
+}
+
+func (st *failingSettler) Deposit(ctx context.Context, id string) (*Deposit, error) {
+	return nil, errUnknownDeposit
+}
+
+func TestOutboxDeduplicates(t *testing.T) {
+	local, _ := NewLocalSettler("")
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	ob, err := NewOutbox(local, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Settlement{GameID: "g1", Players: []string{"a", "b"}, Chips: []int{10, 0}, Proof: "p"}
+	if _, err := ob.Enqueue(s); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ob.Enqueue(s); err != nil {
+		t.Fatal(err)
+	}
+	ob.Process(context.Background())
+	ob.Process(context.Background())
+
+	if n := len(local.Settlements()); n != 1 {
+		t.Fatalf("paid %d times, want 1", n)
+	}
+	e, err := ob.Get(s.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Status != SettlementSubmitted || e.TxID == "" {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+
+	// reloaded outbox knows the settlement and will not pay it again
+	reloaded, err := NewOutbox(local, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded.Enqueue(s)
+	reloaded.Process(context.Background())
+	if n := len(local.Settlements()); n != 1 {
+		t.Fatalf("paid %d times after reload, want 1", n)
+	}
+}
+
+func TestOutboxRetries(t *testing.T) {
+	st := &failingSettler{}
+	ob, _ := NewOutbox(st, "")
+
+	s := &Settlement{GameID: "g2", Players: []string{"a"}, Chips: []int{10}, Proof: "p"}
+	ob.Enqueue(s)
+	ob.Process(context.Background())
+
+	e, _ := ob.Get(s.Key())
+	if e.Status != SettlementPending || e.Attempts != 1 || !e.NextAttempt.After(time.Now()) {
+		t.Fatalf("unexpected entry after failure %+v", e)
+	}
+
+	// not due yet
+	ob.Process(context.Background())
+	if st.submits != 1 {
+		t.Fatalf("submitted %d times before backoff elapsed", st.submits)
+	}
+
+	for i := 1; i < outboxMaxAttempts; i++ {
+		ob.entries[s.Key()].NextAttempt = time.Now()
+		ob.Process(context.Background())
+	}
+	e, _ = ob.Get(s.Key())
+	if e.Status != SettlementFailed {
+		t.Fatalf("status %q after %d attempts, want failed", e.Status, e.Attempts)
+	}
+
+	if err := ob.Retry(s.Key()); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ = ob.Get(s.Key()); e.Status != SettlementPending {
+		t.Fatalf("status %q after retry, want pending", e.Status)
+	}
+}
+
+// hookSettler calls submit before each submission to the local settler.
+type hookSettler struct {
+	*LocalSettler
+	submit func(s *Settlement)
+}
+
+func (st *hookSettler) Submit(ctx context.Context, s *Settlement) (string, error) {
+	st.submit(s)
+	return st.LocalSettler.Submit(ctx, s)
+}
+
+func TestOutboxSubmitting(t *testing.T) {
+	local, _ := NewLocalSettler("")
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	var crashed *Outbox
+	st := &hookSettler{LocalSettler: local, submit: func(s *Settlement) {
+		// the outbox file as a server dying mid-submission would leave it
+		crashed, _ = NewOutbox(local, path)
+	}}
+	ob, _ := NewOutbox(st, path)
+
+	s := &Settlement{GameID: "g3", Players: []string{"a"}, Chips: []int{10}, Proof: "p"}
+	ob.Enqueue(s)
+	ob.Process(context.Background())
+	if e, _ := crashed.Get(s.Key()); e.Status != SettlementSubmitting {
+		t.Fatalf("status %q while submitting, want submitting", e.Status)
+	}
+
+	// after the restart the settlement is submitted again under its key,
+	// and the settler knows it was paid
+	crashed.Process(context.Background())
+	if e, _ := crashed.Get(s.Key()); e.Status != SettlementConfirmed || e.Attempts != 0 {
+		t.Fatalf("resubmitted entry %+v", e)
+	}
+	if n := len(local.Settlements()); n != 1 {
+		t.Fatalf("paid %d times, want 1", n)
+	}
+}
+
+func TestOutboxUpdatesInPlace(t *testing.T) {
+	local, _ := NewLocalSettler("")
+	var ob *Outbox
+	st := &hookSettler{LocalSettler: local, submit: func(s *Settlement) {
+		// changed by someone else while the settler is busy
+		ob.lock.Lock()
+		ob.entries[s.Key()].LastError = "noted"
+		ob.lock.Unlock()
+	}}
+	ob, _ = NewOutbox(st, "")
+
+	s := &Settlement{GameID: "g4", Players: []string{"a"}, Chips: []int{10}, Proof: "p"}
+	ob.Enqueue(s)
+	ob.Process(context.Background())
+	if e, _ := ob.Get(s.Key()); e.Status != SettlementSubmitted || e.LastError != "" || e.TxID == "" {
+		t.Fatalf("entry %+v", e)
+	}
+	ob.lock.Lock()
+	e := ob.entries[s.Key()]
+	ob.lock.Unlock()
+	ob.Process(context.Background()) // not due
+	ob.lock.Lock()
+	defer ob.lock.Unlock()
+	if ob.entries[s.Key()] != e {
+		t.Fatal("entry replaced by a stale copy")
+	}
+}
+
+func TestOutboxSameTranscript(t *testing.T) {
+	local, _ := NewLocalSettler("")
+	ob, _ := NewOutbox(local, "")
+
+	// a cashes out twice between the same hands, buying in again, and b
+	// once; the second cash-out of a's first buy-in is the same one
+	for _, c := range []struct{ player, deposit string }{{"a", "0xs1"}, {"a", "0xs2"}, {"b", "0xs3"}, {"a", "0xs1"}} {
+		ob.Enqueue(&Settlement{GameID: "g5", Players: []string{c.player}, Chips: []int{10}, Proof: "p", CashOut: true, Deposits: []string{c.deposit}})
+	}
+	ob.Process(context.Background())
+	if n := len(local.Settlements()); n != 3 {
+		t.Fatalf("paid %d cash-outs, want 3", n)
+	}
+}
+
+func TestOutboxEndGameOnce(t *testing.T) {
+	local, _ := NewLocalSettler("")
+	ob, _ := NewOutbox(local, "")
+
+	end := &Settlement{GameID: "g8", Players: []string{"a", "b"}, Chips: []int{10, 20}, Proof: "p"}
+	first, _ := ob.Enqueue(end)
+	if e, _ := ob.Enqueue(&Settlement{GameID: "g8", Players: []string{"a", "b"}, Chips: []int{10, 20}, Proof: "p2"}); e != first {
+		t.Fatal("the end of a game queued twice")
+	}
+	ob.Process(context.Background())
+	if n := len(local.Settlements()); n != 1 {
+		t.Fatalf("paid the end of a game %d times", n)
+	}
+
+	// nothing more of a game is paid once it is over
+	late := &Settlement{GameID: "g8", Players: []string{"a"}, Chips: []int{10}, Proof: "p", CashOut: true}
+	if _, err := local.Submit(context.Background(), late); err != errGameOver {
+		t.Fatalf("cash-out after the end: %v", err)
+	}
+	if _, err := local.Submit(context.Background(), end); err != ErrSettled {
+		t.Fatalf("end of game paid again: %v", err)
+	}
+}
+
+func TestOutboxPrunes(t *testing.T) {
+	local, _ := NewLocalSettler("")
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	ob, _ := NewOutbox(local, path)
+
+	s := &Settlement{GameID: "g6", Players: []string{"a"}, Chips: []int{10}, Proof: "p"}
+	ob.Enqueue(s)
+	ob.Process(context.Background())
+	ob.entries[s.Key()].NextAttempt = time.Now()
+	ob.Process(context.Background())
+	if e, _ := ob.Get(s.Key()); e.Status != SettlementConfirmed {
+		t.Fatalf("status %q, want confirmed", e.Status)
+	}
+
+	ob.Process(context.Background())
+	if _, err := ob.Get(s.Key()); err != nil {
+		t.Fatal("pruned a fresh confirmation")
+	}
+	ob.entries[s.Key()].UpdatedAt = time.Now().Add(-outboxKeep - time.Minute)
+	ob.Process(context.Background())
+	if _, err := ob.Get(s.Key()); err != errUnknownSettlement {
+		t.Fatal("confirmed entry kept")
+	}
+	if reloaded, _ := NewOutbox(local, path); len(reloaded.List("")) != 0 {
+		t.Fatal("confirmed entry kept in the file")
+	}
+}
+
+func TestOutboxClaims(t *testing.T) {
+	local, _ := NewLocalSettler("")
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	ob, _ := NewOutbox(local, path)
+
+	for _, d := range []string{"0xc1", "0xc2", "0xc3"} {
+		if err := ob.claim(d, "g7", "a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ob.claim("0xc4", "g7", "b")
+	ob.play("0xc1")
+	ob.play("0xc2")
+	ob.play("0xc4")
+	ob.unclaim("0xc2") // played, but not settled yet
+
+	// claims survive a restart
+	ob, _ = NewOutbox(local, path)
+	if err := ob.claim("0xc1", "g7", "a"); err != errDepositUsed {
+		t.Fatalf("claimed a deposit twice across a restart: %v", err)
+	}
+
+	// a cash-out consumes the player's played deposits only
+	s := &Settlement{GameID: "g7", Players: []string{"a"}, Chips: []int{10}, Proof: "p", CashOut: true}
+	ob.Enqueue(s)
+	if !slices.Equal(s.Deposits, []string{"0xc1"}) {
+		t.Fatalf("cash-out consumes %v", s.Deposits)
+	}
+	end := &Settlement{GameID: "g7", Players: []string{"b"}, Chips: []int{10}, Proof: "p"}
+	ob.Enqueue(end)
+	if !slices.Equal(end.Deposits, []string{"0xc4"}) {
+		t.Fatalf("end of game consumes %v", end.Deposits)
+	}
+
+	// a consumed deposit is not given back, and is spent once paid
+	ob.unclaim("0xc1")
+	if err := ob.claim("0xc1", "g7", "a"); err != errDepositUsed {
+		t.Fatal("consumed deposit given back")
+	}
+	ob.Process(context.Background())
+	local.AddDeposit(&Deposit{Id: "0xc1", GameID: "g7", Player: "a", Chips: 10})
+	if _, err := local.Deposit(context.Background(), "0xc1"); err != errDepositUsed {
+		t.Fatalf("spent deposit read back: %v", err)
+	}
+	again := &Settlement{GameID: "g7", Players: []string{"b"}, Chips: []int{10}, Proof: "p", CashOut: true, Deposits: []string{"0xc1"}}
+	if _, err := local.Submit(context.Background(), again); err != errDepositUsed {
+		t.Fatalf("deposit consumed twice: %v", err)
+	}
+}
+
+func TestOutboxOrder(t *testing.T) {
+	local, _ := NewLocalSettler("")
+	ob, _ := NewOutbox(local, "")
+
+	// a cash-out queued in the same instant as the end of its game is still
+	// paid first, before the game is over
+	cashOut := &Settlement{GameID: "g9", Players: []string{"a"}, Chips: []int{10}, Proof: "p", CashOut: true}
+	end := &Settlement{GameID: "g9", Players: []string{"b"}, Chips: []int{20}, Proof: "p"}
+	ob.Enqueue(cashOut)
+	ob.Enqueue(end)
+	now := time.Now()
+	for _, e := range ob.entries {
+		e.CreatedAt = now
+	}
+	ob.Process(context.Background())
+	if settlements := local.Settlements(); len(settlements) != 2 || !settlements[0].CashOut {
+		t.Fatalf("paid %+v", settlements)
+	}
+}
@@ -1,28 +1,123 @@
 package poker
 
 import (
+	"context"
+	"crypto/hmac"
+	"errors"
 	"fmt"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type Poker struct {
 	WebRoot string
 	Addr    string
-	OnAuth  func(conn *Conn, mechanism, text string) (*Occupant, error)
-	OnExit  func(o *Occupant)
+	// OnAuth is called once the client is authenticated. For AuthSui and
+	// AuthToken, text is the verified Sui address; for AuthPlain it is an
+	// unverified name.
+	OnAuth func(conn *Conn, mechanism, text string) (*Occupant, error)
+	OnExit func(o *Occupant)
+	// Tokens issues session tokens after AuthSui logins. A random-keyed
+	// issuer is used if nil.
+	Tokens *TokenIssuer
+	// Settler pays out finished games, see NewSuiSettler and NewLocalSettler.
+	Settler Settler
+	// OutboxFile stores settlements awaiting delivery; in memory if empty.
+	OutboxFile string
+	// HistoryDir stores the hand histories of every room, a file of JSON
+	// lines per room; the last hands of each room are kept in memory if
+	// empty.
+	HistoryDir string
+	// Events, if set, opens and closes rooms for the games created and
+	// ended on chain, see ChainWatcher.
+	Events EventSource
+	// CursorFile stores how far Events was read and the games still open;
+	// the events are read from the first one on every start if empty.
+	CursorFile string
+	// Rake is the house fee of new rooms; none if nil.
+	Rake *RakeConfig
+	// ChatFilter checks every chat message, see BlockedWords.
+	ChatFilter ChatFilter
+	// AdminToken guards the /admin endpoints, which are disabled if empty.
+	AdminToken string
+}
+
+// requireToken rejects requests without a valid "Authorization: Bearer"
+// session token and stores the token's address as "address".
+func (p *Poker) requireToken(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": errBadToken.Error()})
+		return
+	}
+	address, err := p.Tokens.Verify(token)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	c.Set("address", address)
+}
+
+// requireAdmin rejects requests without "Authorization: Bearer <AdminToken>".
+func (p *Poker) requireAdmin(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || p.AdminToken == "" || !hmac.Equal([]byte(token), []byte(p.AdminToken)) {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
 }
 
 func (p *Poker) ListenAndServe() error {
+	if p.Tokens == nil {
+		p.Tokens = NewTokenIssuer(nil, 0)
+	}
+	if p.Settler == nil {
+		return errors.New("no settler configured")
+	}
+	if p.Rake != nil {
+		if err := p.Rake.Validate(); err != nil {
+			return err
+		}
+	}
+	SetRake(p.Rake)
+	SetChatFilter(p.ChatFilter)
+	if p.HistoryDir != "" {
+		if err := os.MkdirAll(p.HistoryDir, 0755); err != nil {
+			return err
+		}
+	}
+	SetHistoryDir(p.HistoryDir)
+	ob, err := NewOutbox(p.Settler, p.OutboxFile)
+	if err != nil {
+		return err
+	}
+	SetOutbox(ob)
+	SetSettler(p.Settler)
+	go ob.Run(context.Background())
+	if p.Events != nil {
+		w, err := NewChainWatcher(p.Events, p.CursorFile)
+		if err != nil {
+			return err
+		}
+		go w.Run(context.Background())
+	}
+
+	return p.router().Run(fmt.Sprintf("%s", p.Addr)) // listen and serve on 0.0.0.0:8080 (for windows "localhost:8080")
+}
+
+// router returns the HTTP routes of the server.
+func (p *Poker) router() *gin.Engine {
 	r := gin.Default()
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"PUT", "GET", "POST", "OPTIONS"},
-		AllowHeaders:     []string{"Origin"},
+		AllowHeaders:     []string{"Origin", "Authorization"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 		//AllowOriginFunc: func(origin string) bool {
@@ -31,8 +126,12 @@ func (p *Poker) ListenAndServe() error {
 		MaxAge: 12 * time.Hour,
 	}))
 
-	r.GET("/reconnect/:user_addr", func(c *gin.Context) {
+	r.GET("/reconnect/:user_addr", p.requireToken, func(c *gin.Context) {
 		address := c.Param("user_addr")
+		if address != c.GetString("address") {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "address does not match token"})
+			return
+		}
 		playerInfo := GetPlayerInfo(address)
 		if playerInfo != nil {
 			c.JSON(http.StatusOK, gin.H{
@@ -48,10 +147,259 @@ func (p *Poker) ListenAndServe() error {
 			"chips":   0,
 		})
 	})
+	r.GET("/rooms/:id/hands", p.requireToken, func(c *gin.Context) {
+		histories, err := roomHistories(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if histories == nil && lookupRoom(c.Param("id")) == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "unknown room"})
+			return
+		}
+		address := c.GetString("address")
+		hands := []*HandHistory{}
+		for _, h := range histories {
+			if h.Seat(address) != nil {
+				hands = append(hands, h.View(address))
+			}
+		}
+		writeHistories(c, hands, address)
+	})
+	r.GET("/rooms/:id/hands/:hand", p.requireToken, func(c *gin.Context) {
+		h := roomHandHistory(c)
+		address := c.GetString("address")
+		if h == nil || h.Seat(address) == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "unknown hand"})
+			return
+		}
+		writeHistories(c, []*HandHistory{h.View(address)}, address)
+	})
+	r.GET("/rooms/:id/hands/:hand/replay", p.requireToken, func(c *gin.Context) {
+		h := roomHandHistory(c)
+		address := c.GetString("address")
+		if h == nil || h.Seat(address) == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "unknown hand"})
+			return
+		}
+		writeReplay(c, NewReplay(h.View(address), address))
+	})
+	r.GET("/events/:id", func(c *gin.Context) {
+		d := GetDirector(c.Param("id"))
+		if d == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": errUnknownDirector.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, d.View())
+	})
+	admin := r.Group("/admin", p.requireAdmin)
+	admin.GET("/settlements", func(c *gin.Context) {
+		c.JSON(http.StatusOK, outbox.List(c.Query("status")))
+	})
+	admin.GET("/settlements/:id", func(c *gin.Context) {
+		e, err := outbox.Get(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, e)
+	})
+	admin.POST("/settlements/:id/retry", func(c *gin.Context) {
+		if err := outbox.Retry(c.Param("id")); err != nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+	admin.GET("/rooms/:id/ledger", func(c *gin.Context) {
+		room := lookupRoom(c.Param("id"))
+		if room == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "unknown room"})
+			return
+		}
+		hand := -1
+		if h := c.Query("hand"); h != "" {
+			hand, _ = strconv.Atoi(h)
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"balances": room.ledger.Balances(),
+			"entries":  room.ledger.Entries(hand),
+		})
+	})
+	admin.GET("/rooms/:id/hands/:hand", func(c *gin.Context) {
+		h := roomHandHistory(c)
+		if h == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "unknown hand"})
+			return
+		}
+		writeHistories(c, []*HandHistory{h}, "")
+	})
+	admin.GET("/rooms/:id/hands/:hand/replay", func(c *gin.Context) {
+		h := roomHandHistory(c)
+		if h == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "unknown hand"})
+			return
+		}
+		writeReplay(c, NewReplay(h, ""))
+	})
+	mute := func(muted bool) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			room := lookupRoom(c.Param("id"))
+			if room == nil {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "unknown room"})
+				return
+			}
+			room.Mute(c.Param("player"), muted)
+			c.Status(http.StatusNoContent)
+		}
+	}
+	admin.PUT("/rooms/:id/muted/:player", mute(true))
+	admin.DELETE("/rooms/:id/muted/:player", mute(false))
+	admin.PUT("/rooms/:id/rake", func(c *gin.Context) {
+		room := lookupRoom(c.Param("id"))
+		if room == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "unknown room"})
+			return
+		}
+		cfg := &RakeConfig{}
+		if err := c.ShouldBindJSON(cfg); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := cfg.Validate(); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		room.SetRake(cfg)
+		c.Status(http.StatusNoContent)
+	})
+	admin.POST("/tournaments", func(c *gin.Context) {
+		var req struct {
+			Id  string `json:"id"`
+			Max int    `json:"max"`
+			TournamentConfig
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.Max <= 0 || req.Max > MaxN {
+			req.Max = 9
+		}
+		if err := req.Validate(req.Max); err != nil || req.Id == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprint("invalid tournament: ", err)})
+			return
+		}
+		if RoomExist(req.Id) {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "room exists"})
+			return
+		}
+		room := NewSitAndGo(req.Id, req.Max, &req.TournamentConfig)
+		setUpGame(room)
+		SetRoom(room)
+		c.JSON(http.StatusCreated, room.View(nil))
+	})
+	admin.POST("/events", func(c *gin.Context) {
+		var req struct {
+			Id        string `json:"id"`
+			TableSize int    `json:"table_size"`
+			Max       int    `json:"max"`
+			TournamentConfig
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		d := NewDirector(req.Id, req.TableSize, req.Max, &req.TournamentConfig)
+		max := req.Max
+		if max <= 0 {
+			max = len(req.Payouts)
+		}
+		if err := req.Validate(max); err != nil || req.Id == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprint("invalid tournament: ", err)})
+			return
+		}
+		if !SetDirector(d) {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "tournament exists"})
+			return
+		}
+		c.JSON(http.StatusCreated, d.View())
+	})
+	admin.POST("/events/:id/start", func(c *gin.Context) {
+		d := GetDirector(c.Param("id"))
+		if d == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": errUnknownDirector.Error()})
+			return
+		}
+		if err := d.Start(); err != nil {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, d.View())
+	})
 	r.GET("/ws", func(c *gin.Context) {
 		p.pokerHandler(c.Writer, c.Request)
 	})
-	return r.Run(fmt.Sprintf("%s", p.Addr)) // listen and serve on 0.0.0.0:8080 (for windows "localhost:8080")
+	return r
+}
+
+// roomHandHistory returns the hand named by the :id and :hand parameters.
+func roomHandHistory(c *gin.Context) *HandHistory {
+	id, err := strconv.ParseInt(c.Param("hand"), 10, 64)
+	if err != nil {
+		return nil
+	}
+	if room := lookupRoom(c.Param("id")); room != nil {
+		if h := room.HandHistory(id); h != nil {
+			return h
+		}
+	}
+	h, err := loadHistory(c.Param("id"), id)
+	if err != nil {
+		log.Println("hand history", c.Param("id"), err)
+	}
+	return h
+}
+
+// writeHistories responds with hands as JSON, or as PokerStars hand
+// histories for ?format=pokerstars.
+func writeHistories(c *gin.Context, hands []*HandHistory, hero string) {
+	if c.Query("format") != "pokerstars" {
+		if len(hands) == 1 && c.Param("hand") != "" {
+			c.JSON(http.StatusOK, hands[0])
+			return
+		}
+		c.JSON(http.StatusOK, hands)
+		return
+	}
+
+	texts := make([]string, len(hands))
+	for i, h := range hands {
+		texts[i] = h.PokerStars(hero)
+	}
+	c.String(http.StatusOK, strings.Join(texts, "\n\n"))
+}
+
+// writeReplay responds with the replay steps from ?from= (default 0) up to
+// ?to= (default the end). Stepping asks for one step at a time; seeking to
+// a step asks for all steps from 0 to it.
+func writeReplay(c *gin.Context, r *Replay) {
+	from, _ := strconv.Atoi(c.Query("from"))
+	to, err := strconv.Atoi(c.Query("to"))
+	if err != nil {
+		to = r.Len()
+	}
+	r.Seek(from)
+	messages := r.Step(to - r.Pos())
+	if messages == nil {
+		messages = []*Message{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"hand":     r.Hand.Id,
+		"steps":    r.Len(),
+		"from":     r.Pos() - len(messages),
+		"messages": messages,
+	})
 }
 
 func (p *Poker) pokerHandler(w http.ResponseWriter, r *http.Request) {
@@ -71,10 +419,51 @@ func (p *Poker) pokerHandler(w http.ResponseWriter, r *http.Request) {
 	conn := NewConn(ws, 128)
 	defer conn.Close()
 
-	auth := &Auth{}
-	if err := conn.ReadJSONTimeout(auth, readWait); err != nil {
+	// Clients may open with a Version before Auth; those that start with
+	// Auth directly speak ProtoV1.
+	hello := &struct {
+		Version
+		Auth
+	}{}
+	if err := conn.ReadJSONTimeout(hello, readWait); err != nil {
 		return
 	}
+	auth := &hello.Auth
+	if hello.Ver != "" {
+		conn.SetVersion(Negotiate(hello.Ver))
+		conn.SetEncoding(hello.Encoding)
+		if err := conn.WriteJSON(&Version{Ver: conn.Version(), Encoding: conn.Encoding()}); err != nil {
+			return
+		}
+		if err := conn.ReadJSONTimeout(auth, readWait); err != nil {
+			return
+		}
+	}
+
+	// a session token may also be given on the websocket URL
+	if token := r.URL.Query().Get("token"); token != "" && auth.Mechanism != AuthSui {
+		auth.Mechanism = AuthToken
+		auth.Text = token
+	}
+
+	switch auth.Mechanism {
+	case AuthSui:
+		address, err := challengeSui(conn, auth.Text)
+		if err != nil {
+			conn.WriteJSON(&AuthResp{Error: err.Error()})
+			return
+		}
+		auth.Text = address
+	case AuthToken:
+		address, err := p.Tokens.Verify(auth.Text)
+		if err != nil {
+			conn.WriteJSON(&AuthResp{Error: err.Error()})
+			return
+		}
+		auth.Text = address
+	default:
+		auth.Mechanism = AuthPlain
+	}
 
 	var o *Occupant
 	if p.OnAuth != nil {
@@ -84,12 +473,29 @@ func (p *Poker) pokerHandler(w http.ResponseWriter, r *http.Request) {
 			o = NewOccupant(strconv.FormatInt(time.Now().Unix(), 10), conn)
 			o.Name = auth.Text
 		}
-		o.Chips = 10000
 	}
 
-	if err := conn.WriteJSON(o); err != nil {
+	if auth.Mechanism != AuthPlain {
+		o.address = auth.Text
+	}
+	resp := &AuthResp{
+		Id:    o.Id,
+		Name:  o.Name,
+		Level: o.Level,
+		Chips: o.Chips,
+	}
+	if auth.Mechanism != AuthPlain {
+		resp.Address = auth.Text
+		if resp.Token, err = p.Tokens.Issue(auth.Text); err != nil {
+			return
+		}
+	}
+	if err := conn.WriteJSON(resp); err != nil {
 		return
 	}
+	if auth.LastSeq > 0 {
+		o.Resume(auth.LastSeq)
+	}
 
 	for {
 		message, _ := o.GetMessage(0)
@@ -103,11 +509,17 @@ func (p *Poker) pokerHandler(w http.ResponseWriter, r *http.Request) {
 		case MsgPresence:
 			handlePresence(o, message)
 		case MsgMessage:
+			handleChat(o, message)
 		}
 	}
 
 	// Do not leave wait reconnect
 	//o.Leave()
+	o.Unwatch()
+	o.unwaitAll()
+	if o.conn == conn {
+		o.offline.Store(true)
+	}
 
 	if p.OnExit != nil {
 		p.OnExit(o)
@@ -0,0 +1,151 @@
+package poker
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ProtoV1 is the original encoding: event data is comma-joined in Message.Class.
+	ProtoV1 = "1.0"
+	// ProtoV2 carries event data in typed payload fields.
+	ProtoV2 = "2.0"
+)
+
+var protoVersions = []string{ProtoV2, ProtoV1}
+
+// Negotiate picks the highest protocol version not newer than the one the
+// client asked for. Unknown or empty versions fall back to ProtoV1.
+func Negotiate(ver string) string {
+	major := func(v string) int {
+		n, _ := strconv.Atoi(strings.SplitN(v, ".", 2)[0])
+		return n
+	}
+	for _, v := range protoVersions {
+		if major(v) <= major(ver) {
+			return v
+		}
+	}
+	return ProtoV1
+}
+
+// DealEvent announces dealt cards: hole cards at preflop, board cards after.
+type DealEvent struct {
+	Cards []Card `json:"cards"`
+	Hand  int    `json:"hand,omitempty"`
+}
+
+// BetEvent is sent by the server after an occupant acts: Bet is its total
+// bet in the betting round and Chips what it has left.
+type BetEvent struct {
+	Pos    int    `json:"index,omitempty"`
+	Action string `json:"action,omitempty"`
+	Bet    int    `json:"bet"`
+	Chips  int    `json:"chips,omitempty"`
+}
+
+// BetRequest is sent by a ProtoV2 client to act: Action "fold" folds,
+// otherwise Amount chips are added to its bet.
+type BetRequest struct {
+	Action string `json:"action,omitempty"`
+	Amount int    `json:"amount"`
+}
+
+// ActionPrompt asks the occupant at Pos to act, with the bets it may make.
+// ToCall, MinRaise and MaxBet are chips to add to its bet.
+type ActionPrompt struct {
+	Pos      int      `json:"index"`
+	Bet      int      `json:"bet"`
+	ToCall   int      `json:"to_call"`
+	MinRaise int      `json:"min_raise,omitempty"`
+	MaxBet   int      `json:"max_bet"`
+	Options  []string `json:"options"`
+}
+
+// PotEvent is the main pot followed by any side pots.
+type PotEvent struct {
+	Pots []int `json:"pots"`
+}
+
+func newActionPrompt(room *Room, o *Occupant) *ActionPrompt {
+	prompt := &ActionPrompt{
+		Pos:    o.Pos,
+		Bet:    room.Bet,
+		ToCall: room.Bet - o.Bet,
+		MaxBet: o.Chips,
+	}
+	if prompt.ToCall > o.Chips {
+		prompt.ToCall = o.Chips
+	}
+
+	prompt.Options = append(prompt.Options, ActFold)
+	if prompt.ToCall == 0 {
+		prompt.Options = append(prompt.Options, ActCheck)
+	} else {
+		prompt.Options = append(prompt.Options, ActCall)
+	}
+	if o.Chips > prompt.ToCall {
+		// a raise is at least as big as the last one in the round
+		prompt.MinRaise = prompt.ToCall + max(room.raise, room.BB)
+		if prompt.MinRaise > o.Chips {
+			prompt.MinRaise = o.Chips
+		}
+		prompt.Options = append(prompt.Options, ActRaise)
+	}
+	prompt.Options = append(prompt.Options, ActAllin)
+
+	return prompt
+}
+
+// legal returns bet n, -1 for fold, made legal against the prompt. A bet
+// past the stack is all in, one short of the call folds, and a raise short
+// of the minimum only calls.
+func (prompt *ActionPrompt) legal(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n >= prompt.MaxBet:
+		return prompt.MaxBet
+	case n < prompt.ToCall:
+		return -1
+	case n > prompt.ToCall && n < prompt.MinRaise:
+		return prompt.ToCall
+	}
+	return n
+}
+
+// BetAmount returns the chips bet by a ActBet message, -1 for fold.
+func (message *Message) BetAmount() (int, error) {
+	if message.Act != nil {
+		if message.Act.Action == ActFold {
+			return -1, nil
+		}
+		return message.Act.Amount, nil
+	}
+	if len(message.Class) == 0 {
+		return 0, errors.New("empty bet")
+	}
+	return strconv.Atoi(message.Class)
+}
+
+// Encode returns the message in the wire format of protocol version ver.
+// ProtoV1 drops typed payloads; ProtoV2 drops the comma-joined Class they replace.
+func (message *Message) Encode(ver string) *Message {
+	hasPayload := message.Deal != nil || message.BetEvent != nil ||
+		message.Prompt != nil || message.Pots != nil
+	if !hasPayload {
+		return message
+	}
+
+	m := *message
+	if ver == ProtoV2 {
+		m.Class = ""
+	} else {
+		m.Deal = nil
+		m.BetEvent = nil
+		m.Prompt = nil
+		m.Pots = nil
+	}
+	return &m
+}
@@ -0,0 +1,136 @@
+package poker
+
+import (
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	for ver, want := range map[string]string{
+		"":    ProtoV1,
+		"x":   ProtoV1,
+		"1.0": ProtoV1,
+		"1.5": ProtoV1,
+		"2.0": ProtoV2,
+		"2.1": ProtoV2,
+		"3.0": ProtoV2,
+	} {
+		if got := Negotiate(ver); got != want {
+			t.Errorf("Negotiate(%q) = %q, want %q", ver, got, want)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	m := &Message{
+		Action:   ActBet,
+		Class:    "raise,20,980",
+		BetEvent: &BetEvent{Pos: 1, Action: ActRaise, Bet: 20, Chips: 980},
+	}
+	if v1 := m.Encode(ProtoV1); v1.BetEvent != nil || v1.Class != m.Class {
+		t.Fatalf("ProtoV1 %+v", v1)
+	}
+	if v2 := m.Encode(ProtoV2); v2.BetEvent != m.BetEvent || v2.Class != "" {
+		t.Fatalf("ProtoV2 %+v", v2)
+	}
+	if m.Class == "" || m.BetEvent == nil {
+		t.Fatal("encoding changed the message")
+	}
+
+	plain := &Message{Action: ActJoin, Class: "3"}
+	if plain.Encode(ProtoV2) != plain {
+		t.Fatal("message without payload copied")
+	}
+}
+
+func TestBetAmount(t *testing.T) {
+	for _, c := range []struct {
+		m    *Message
+		want int
+	}{
+		{&Message{Class: "30"}, 30},
+		{&Message{Class: "-1"}, -1},
+		{&Message{Act: &BetRequest{Amount: 30}}, 30},
+		{&Message{Act: &BetRequest{Action: ActFold, Amount: 30}}, -1},
+		{&Message{Act: &BetRequest{Action: ActCheck}}, 0},
+	} {
+		if n, err := c.m.BetAmount(); err != nil || n != c.want {
+			t.Errorf("%+v: bet %d, %v, want %d", c.m, n, err, c.want)
+		}
+	}
+	for _, m := range []*Message{{}, {Class: "call"}} {
+		if _, err := m.BetAmount(); err == nil {
+			t.Errorf("%+v: no error", m)
+		}
+	}
+}
+
+func TestActionPromptMinRaise(t *testing.T) {
+	room := newTestRoom(t, 1000, 1000, 1000, 60)
+	a, b, c, d := room.Occupants[0], room.Occupants[1], room.Occupants[2], room.Occupants[3]
+	room.raise = room.BB
+	room.betting(a.Pos, 5, EntryBlind)
+	room.betting(b.Pos, 10, EntryBlind)
+
+	p := newActionPrompt(room, c)
+	if p.ToCall != 10 || p.MinRaise != 20 || p.MaxBet != 1000 {
+		t.Fatalf("prompt %+v", p)
+	}
+
+	// c raises to 40: the next raise is at least 30 more
+	room.betting(c.Pos, 40, EntryBet)
+	if p := newActionPrompt(room, d); p.ToCall != 40 || p.MinRaise != 60 {
+		t.Fatalf("after a raise to 40: %+v", p)
+	}
+
+	// d goes all in for 60, short of a full raise
+	room.betting(d.Pos, 60, EntryBet)
+	if p := newActionPrompt(room, a); p.ToCall != 55 || p.MinRaise != 85 {
+		t.Fatalf("after a short all-in: %+v", p)
+	}
+
+	// a re-raises to 150, by 90
+	room.betting(a.Pos, 145, EntryBet)
+	if p := newActionPrompt(room, b); p.ToCall != 140 || p.MinRaise != 230 {
+		t.Fatalf("after a re-raise: %+v", p)
+	}
+
+	// a new round starts over from the big blind
+	room.ready()
+	if p := newActionPrompt(room, b); p.ToCall != 0 || p.MinRaise != 10 || len(p.Options) != 4 || p.Options[1] != ActCheck {
+		t.Fatalf("new round: %+v", p)
+	}
+}
+
+func TestActionPromptLegal(t *testing.T) {
+	room := newTestRoom(t, 1000, 1000, 1000)
+	a, b, c := room.Occupants[0], room.Occupants[1], room.Occupants[2]
+	room.raise = room.BB
+	room.betting(a.Pos, 5, EntryBlind)
+	room.betting(b.Pos, 10, EntryBlind)
+
+	p := newActionPrompt(room, c) // 10 to call, raise to at least 20
+	for _, tc := range []struct{ n, want int }{
+		{-1, -1},
+		{0, -1},  // checking a bet
+		{5, -1},  // short of the call
+		{10, 10}, // call
+		{15, 10}, // raise short of the minimum
+		{20, 20},
+		{5000, 1000}, // past the stack
+	} {
+		if got := p.legal(tc.n); got != tc.want {
+			t.Errorf("bet %d: %d, want %d", tc.n, got, tc.want)
+		}
+	}
+
+	room.betting(c.Pos, p.legal(5000), EntryBet)
+	if c.Chips != 0 || c.Action != ActAllin || room.Chips[c.Pos-1] != 1000 {
+		t.Fatalf("stack %d after betting past it", c.Chips)
+	}
+
+	// nothing to call: a check stands, a bet short of the big blind checks
+	room.ready()
+	if p := newActionPrompt(room, a); p.legal(0) != 0 || p.legal(5) != 0 {
+		t.Fatalf("checks not legal: %+v", p)
+	}
+}
@@ -0,0 +1,84 @@
+package poker
+
+import (
+	"fmt"
+	"sync"
+)
+
+// RakeConfig is the house fee taken from each pot at showdown.
+type RakeConfig struct {
+	Percent float64 `json:"percent"`
+	// Caps limits the rake of a hand by the number of players dealt in:
+	// the cap for the largest key not above that number applies. No key
+	// means no cap.
+	Caps map[int]int `json:"caps,omitempty"`
+	// NoFlopNoDrop takes no rake from hands that end before the flop.
+	NoFlopNoDrop bool `json:"no_flop_no_drop,omitempty"`
+}
+
+func (cfg *RakeConfig) Validate() error {
+	if cfg.Percent < 0 || cfg.Percent > 100 {
+		return fmt.Errorf("invalid rake percent %v", cfg.Percent)
+	}
+	for players, c := range cfg.Caps {
+		if players < 2 || players > MaxN || c < 0 {
+			return fmt.Errorf("invalid rake cap %d for %d players", c, players)
+		}
+	}
+	return nil
+}
+
+// cap returns the most rake taken from a hand dealt to players, or -1 if
+// there is no limit.
+func (cfg *RakeConfig) cap(players int) int {
+	c, best := -1, 0
+	for n, v := range cfg.Caps {
+		if n <= players && n > best {
+			c, best = v, n
+		}
+	}
+	return c
+}
+
+// take deducts the rake from pots, main pot first, and returns it. Pots
+// with a single player are uncalled bets and are not raked.
+func (cfg *RakeConfig) take(pots []handPot, players int, flop bool) (rake int) {
+	if cfg == nil || cfg.Percent == 0 || (cfg.NoFlopNoDrop && !flop) {
+		return 0
+	}
+
+	limit := cfg.cap(players)
+	for i := range pots {
+		if len(pots[i].OPos) < 2 {
+			continue
+		}
+		r := int(float64(pots[i].Pot) * cfg.Percent / 100)
+		if limit >= 0 && rake+r > limit {
+			r = limit - rake
+		}
+		pots[i].Pot -= r
+		rake += r
+	}
+	return
+}
+
+var (
+	rakeLock    sync.Mutex
+	defaultRake *RakeConfig
+)
+
+// SetRake sets the rake of rooms created from now on; nil takes none.
+func SetRake(cfg *RakeConfig) {
+	rakeLock.Lock()
+	defer rakeLock.Unlock()
+
+	defaultRake = cfg
+}
+
+// SetRake changes the room's rake from the next showdown on.
+func (room *Room) SetRake(cfg *RakeConfig) {
+	room.lock.Lock()
+	defer room.lock.Unlock()
+
+	room.Rake = cfg
+}
@@ -0,0 +1,95 @@
+package poker
+
+import (
+	"testing"
+)
+
+func TestRakeTake(t *testing.T) {
+	cfg := &RakeConfig{
+		Percent:      5,
+		Caps:         map[int]int{2: 10, 4: 30},
+		NoFlopNoDrop: true,
+	}
+	tests := []struct {
+		pots    []int
+		players int
+		flop    bool
+		rake    int
+		left    []int
+	}{
+		{pots: []int{100}, players: 6, flop: true, rake: 5, left: []int{95}},
+		{pots: []int{100}, players: 6, flop: false, rake: 0, left: []int{100}},
+		{pots: []int{1000}, players: 2, flop: true, rake: 10, left: []int{990}},
+		{pots: []int{1000}, players: 3, flop: true, rake: 10, left: []int{990}},
+		{pots: []int{400, 400}, players: 5, flop: true, rake: 30, left: []int{380, 390}},
+		{pots: []int{99}, players: 5, flop: true, rake: 4, left: []int{95}},
+	}
+	for i, test := range tests {
+		var pots []handPot
+		for _, p := range test.pots {
+			pots = append(pots, handPot{Pot: p, OPos: []int{1, 2}})
+		}
+		if rake := cfg.take(pots, test.players, test.flop); rake != test.rake {
+			t.Errorf("%d: rake %d, want %d", i, rake, test.rake)
+		}
+		for j, p := range pots {
+			if p.Pot != test.left[j] {
+				t.Errorf("%d: pot %d left %d, want %d", i, j, p.Pot, test.left[j])
+			}
+		}
+	}
+
+	// an uncalled bet is returned whole
+	pots := []handPot{{Pot: 200, OPos: []int{1, 2}}, {Pot: 50, OPos: []int{1}}}
+	if rake := (&RakeConfig{Percent: 10}).take(pots, 2, true); rake != 20 || pots[1].Pot != 50 {
+		t.Fatalf("rake %d, side pot %d", rake, pots[1].Pot)
+	}
+	if (*RakeConfig)(nil).take(pots, 2, true) != 0 {
+		t.Fatal("rake taken without a config")
+	}
+}
+
+func TestRakeShowdown(t *testing.T) {
+	room := newTestRoom(t, 1000, 1000)
+	room.Rake = &RakeConfig{Percent: 10}
+	for _, o := range room.Occupants {
+		room.ledger.Transfer(room.hand, EntryBuyIn, AccountCashier, PlayerAccount(o.Id), o.Chips)
+	}
+	room.hand++
+
+	a, b := room.Occupants[0], room.Occupants[1]
+	a.Cards, b.Cards = []Card{1, 2}, []Card{3, 4}
+	a.Hand, b.Hand = 200, 100
+	room.Cards = []Card{5, 6, 7}
+	room.remain, room.dealt = 2, 2
+
+	room.betting(a.Pos, 100, EntryBet)
+	room.betting(b.Pos, 100, EntryBet)
+	room.showdown()
+
+	if room.rake != 20 || a.Chips != 1080 || b.Chips != 900 {
+		t.Fatalf("rake %d, stacks %d %d", room.rake, a.Chips, b.Chips)
+	}
+	if n := room.ledger.Balance(AccountHouse); n != 20 {
+		t.Fatalf("house has %d, want 20", n)
+	}
+	if err := room.checkChips(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRakeValidate(t *testing.T) {
+	for _, cfg := range []*RakeConfig{
+		{Percent: -1},
+		{Percent: 101},
+		{Percent: 5, Caps: map[int]int{1: 10}},
+		{Percent: 5, Caps: map[int]int{3: -1}},
+	} {
+		if cfg.Validate() == nil {
+			t.Errorf("%+v validated", cfg)
+		}
+	}
+	if err := (&RakeConfig{Percent: 4.5, Caps: map[int]int{2: 5}}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,168 @@
+package poker
+
+import (
+	"errors"
+)
+
+var (
+	errNotSeated  = errors.New("not seated in the room")
+	errBelowBuyIn = errors.New("stack below the minimum buy-in")
+	errAboveBuyIn = errors.New("stack above the maximum buy-in")
+)
+
+// checkStack checks a stack of chips against the room's buy-in limits.
+func (room *Room) checkStack(chips int) error {
+	if room.MinChips > 0 && chips < room.MinChips {
+		return errBelowBuyIn
+	}
+	if room.MaxChips > 0 && chips > room.MaxChips {
+		return errAboveBuyIn
+	}
+	return nil
+}
+
+// Rebuy adds the chips escrowed by deposit to o's stack before the next
+// hand, topping it up or buying back in once busted. The stack it makes must
+// be within the room's buy-in limits.
+func (o *Occupant) Rebuy(room *Room, deposit string) error {
+	if room.Occupant(o.Id) != o {
+		return errNotSeated
+	}
+	if room.Tournament != nil {
+		return errTournamentRebuy
+	}
+	d, err := verifyDeposit(room.Id, o, deposit)
+	if err != nil {
+		return err
+	}
+
+	room.lock.Lock()
+	defer room.lock.Unlock()
+
+	// o may have left while the deposit was looked up
+	if room.Occupant(o.Id) != o {
+		unclaimDeposit(d.Id)
+		return errNotSeated
+	}
+	chips := room.stack(o) + d.Chips
+	for _, r := range o.rebuys {
+		chips += r.Chips
+	}
+	if err := room.checkStack(chips); err != nil {
+		unclaimDeposit(d.Id)
+		return err
+	}
+	o.rebuys = append(o.rebuys, d)
+	return nil
+}
+
+// stack returns o's chips, counting those it has bet in the hand being
+// played as still its own. Called with the lock held.
+func (room *Room) stack(o *Occupant) int {
+	chips := o.Chips
+	if h := room.history; h != nil {
+		for _, seat := range h.Seats {
+			if seat.Id == o.Id && seat.Chips > chips {
+				chips = seat.Chips
+			}
+		}
+	}
+	return chips
+}
+
+// AutoRebuy keeps the chips escrowed by deposit aside, to buy o back in the
+// first time its stack drops below the big blind between hands. An empty
+// deposit turns auto-rebuy off.
+func (o *Occupant) AutoRebuy(room *Room, deposit string) error {
+	if room.Occupant(o.Id) != o {
+		return errNotSeated
+	}
+	if room.Tournament != nil {
+		return errTournamentRebuy
+	}
+	var d *Deposit
+	if deposit != "" {
+		var err error
+		if d, err = verifyDeposit(room.Id, o, deposit); err != nil {
+			return err
+		}
+		if err := room.checkStack(d.Chips); err != nil {
+			unclaimDeposit(d.Id)
+			return err
+		}
+	}
+
+	room.lock.Lock()
+	defer room.lock.Unlock()
+
+	if room.Occupant(o.Id) != o {
+		if d != nil {
+			unclaimDeposit(d.Id)
+		}
+		return errNotSeated
+	}
+	if o.autoRebuy != nil {
+		unclaimDeposit(o.autoRebuy.Id)
+	}
+	o.autoRebuy = d
+	return nil
+}
+
+// rebuying reports whether o has chips coming before the next hand.
+func (o *Occupant) rebuying() bool {
+	return len(o.rebuys) > 0 || o.autoRebuy != nil
+}
+
+// dropRebuys gives back the deposits o has not played. Called with the
+// lock held.
+func (o *Occupant) dropRebuys() {
+	for _, d := range o.rebuys {
+		unclaimDeposit(d.Id)
+	}
+	o.rebuys = nil
+	if o.autoRebuy != nil {
+		unclaimDeposit(o.autoRebuy.Id)
+		o.autoRebuy = nil
+	}
+}
+
+// rebuy adds the chips of deposit d to o's stack, unless the stack grew
+// past the room's maximum since it was asked for. Called with the lock held.
+func (room *Room) rebuy(o *Occupant, d *Deposit) bool {
+	if room.MaxChips > 0 && o.Chips+d.Chips > room.MaxChips {
+		unclaimDeposit(d.Id)
+		o.SendError(2, errAboveBuyIn.Error())
+		return false
+	}
+	o.Chips += d.Chips
+	playDeposit(d.Id)
+	room.ledger.Transfer(room.hand, EntryBuyIn, AccountCashier, PlayerAccount(o.Id), d.Chips)
+	room.Broadcast(&Message{
+		From:     room.Id,
+		Type:     MsgPresence,
+		Action:   ActRebuy,
+		Chips:    d.Chips,
+		Occupant: o,
+	})
+	return true
+}
+
+// applyRebuys adds the chips bought since the last hand to the stacks, and
+// buys back in the players with auto-rebuy whose stack is below the big
+// blind.
+func (room *Room) applyRebuys() {
+	room.lock.Lock()
+	defer room.lock.Unlock()
+
+	room.Each(0, func(o *Occupant) bool {
+		for _, d := range o.rebuys {
+			room.rebuy(o, d)
+		}
+		o.rebuys = nil
+		if d := o.autoRebuy; d != nil && o.Chips < room.BB {
+			o.autoRebuy = nil
+			room.rebuy(o, d)
+		}
+		return true
+	})
+}
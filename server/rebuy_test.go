@@ -0,0 +1,139 @@
+package poker
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRebuy(t *testing.T) {
+	local, _ := NewLocalSettler("")
+	SetSettler(local)
+	defer SetSettler(nil)
+
+	room := newTestRoom(t, 600, 0)
+	room.MinChips, room.MaxChips = 200, 1000
+	a, b := room.Occupants[0], room.Occupants[1]
+	for _, d := range []*Deposit{
+		{Id: "0xr1", Player: "a", Chips: 500},
+		{Id: "0xr2", Player: "a", Chips: 300},
+		{Id: "0xr3", Player: "b", Chips: 800},
+		{Id: "0xr4", Player: "b", Chips: 2000},
+		{Id: "0xr5", Player: "a", Chips: 100},
+		{Id: "0xr6", Player: "c", Chips: 500},
+	} {
+		d.GameID = room.Id
+		local.AddDeposit(d)
+	}
+
+	if err := a.Rebuy(room, "0xr1"); err != errAboveBuyIn {
+		t.Fatalf("topped up past the maximum: %v", err)
+	}
+	if err := a.Rebuy(room, "0xr2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AutoRebuy(room, "0xr4"); err != errAboveBuyIn {
+		t.Fatalf("auto-rebuy past the maximum: %v", err)
+	}
+	if err := b.AutoRebuy(room, "0xr3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newTestOccupant("c", 0).Rebuy(room, "0xr6"); err != errNotSeated {
+		t.Fatalf("rebuy without a seat: %v", err)
+	}
+	if a.Chips != 600 || b.Chips != 0 {
+		t.Fatal("chips added during the hand")
+	}
+
+	room.checkAndEndGame()
+	if room.Occupant("b") == nil {
+		t.Fatal("game ended with b buying back in")
+	}
+
+	room.applyRebuys()
+	if a.Chips != 900 || b.Chips != 800 || a.rebuying() || b.rebuying() {
+		t.Fatalf("stacks %d and %d after rebuys", a.Chips, b.Chips)
+	}
+	if room.ledger.Balance(PlayerAccount("a")) != 300 || room.ledger.Balance(PlayerAccount("b")) != 800 {
+		t.Fatal("rebuys not in the ledger")
+	}
+	messages := received(t, b)
+	if len(messages) != 2 || messages[1].Action != ActRebuy || messages[1].Chips != 800 {
+		t.Fatal("table not told of the rebuys")
+	}
+
+	// a deposit not played is given back
+	if err := a.Rebuy(room, "0xr5"); err != nil {
+		t.Fatal(err)
+	}
+	a.Leave()
+	if err := claimDeposit("0xr5", room.Id, "a"); err != nil {
+		t.Fatal("deposit kept after leaving")
+	}
+	if err := claimDeposit("0xr1", room.Id, "a"); err != nil {
+		t.Fatal("rejected deposit kept")
+	}
+}
+
+// leavingSettler has the player leave while its deposit is looked up.
+type leavingSettler struct {
+	*LocalSettler
+	leave func()
+}
+
+func (st *leavingSettler) Deposit(ctx context.Context, id string) (*Deposit, error) {
+	st.leave()
+	return st.LocalSettler.Deposit(ctx, id)
+}
+
+func TestRebuyInHand(t *testing.T) {
+	local, _ := NewLocalSettler("")
+	SetSettler(local)
+	defer SetSettler(nil)
+
+	room := newTestRoom(t, 600, 600)
+	room.MinChips, room.MaxChips = 200, 1000
+	a, b := room.Occupants[0], room.Occupants[1]
+	local.AddDeposit(&Deposit{Id: "0xh1", GameID: room.Id, Player: "a", Chips: 500})
+	local.AddDeposit(&Deposit{Id: "0xh2", GameID: room.Id, Player: "b", Chips: 100})
+
+	// chips bet in the hand still count towards the stack
+	room.history = room.newHistory()
+	room.history.Seats = []*HistorySeat{{Pos: a.Pos, Id: a.Id, Chips: a.Chips}}
+	a.Chips -= 500
+	if err := a.Rebuy(room, "0xh1"); err != errAboveBuyIn {
+		t.Fatalf("topped up past the maximum with chips in the pot: %v", err)
+	}
+	room.history = nil
+
+	// b leaves while its deposit is looked up
+	SetSettler(&leavingSettler{LocalSettler: local, leave: func() { b.Leave() }})
+	if err := b.Rebuy(room, "0xh2"); err != errNotSeated {
+		t.Fatalf("rebuy after leaving: %v", err)
+	}
+	if b.rebuying() {
+		t.Fatal("rebuy kept for a player gone")
+	}
+	if err := claimDeposit("0xh2", room.Id, "b"); err != nil {
+		t.Fatal("deposit kept after leaving")
+	}
+}
+
+func TestBuyInLimits(t *testing.T) {
+	local, _ := NewLocalSettler("")
+	SetSettler(local)
+	defer SetSettler(nil)
+
+	room := newTestRoom(t, 0, 0)
+	room.DelOccupant(room.Occupants[1])
+	room.MinChips, room.MaxChips = 200, 1000
+	local.AddDeposit(&Deposit{Id: "0xl1", GameID: room.Id, Player: "d", Chips: 100})
+	local.AddDeposit(&Deposit{Id: "0xl2", GameID: room.Id, Player: "d", Chips: 1500})
+
+	d := newTestOccupant("d", 0)
+	if err := d.BuyIn(room, "0xl1", 0); err != errBelowBuyIn {
+		t.Fatalf("bought in below the minimum: %v", err)
+	}
+	if err := d.BuyIn(room, "0xl2", 0); err != errAboveBuyIn {
+		t.Fatalf("bought in above the maximum: %v", err)
+	}
+}
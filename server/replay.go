@@ -0,0 +1,280 @@
+package poker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Replay steps through the messages a table sent during a recorded hand.
+type Replay struct {
+	Hand     *HandHistory
+	messages []*Message
+	pos      int
+}
+
+// NewReplay replays h to hero, showing the hole cards h has. Replay a View
+// of the history to a player, or the history itself with no hero to an
+// admin.
+func NewReplay(h *HandHistory, hero string) *Replay {
+	return &Replay{
+		Hand:     h,
+		messages: h.Messages(hero),
+	}
+}
+
+// Len returns the number of steps in the replay.
+func (r *Replay) Len() int {
+	return len(r.messages)
+}
+
+// Pos returns the number of steps played.
+func (r *Replay) Pos() int {
+	return r.pos
+}
+
+// Step plays the next n steps, or goes back -n steps, and returns the
+// messages played forward.
+func (r *Replay) Step(n int) []*Message {
+	from := r.pos
+	r.Seek(r.pos + n)
+	if r.pos <= from {
+		return nil
+	}
+	return r.messages[from:r.pos]
+}
+
+// Seek moves to step pos and returns every message up to it, from which a
+// client rebuilds the table as it was then.
+func (r *Replay) Seek(pos int) []*Message {
+	if pos < 0 {
+		pos = 0
+	}
+	if pos > len(r.messages) {
+		pos = len(r.messages)
+	}
+	r.pos = pos
+	return r.messages[:pos]
+}
+
+// replayTable is the table state while a hand is replayed.
+type replayTable struct {
+	h      *HandHistory
+	stacks map[int]int
+	bets   map[int]int // on the current street
+	chips  []int       // put in the pot during the hand, by seat
+	bet    int
+	raise  int // size of the last full raise on the street
+	board  []Card
+}
+
+func (t *replayTable) room() *Room {
+	room := &Room{
+		Id:        t.h.Room,
+		SB:        t.h.SB,
+		BB:        t.h.BB,
+		Cards:     t.board,
+		Button:    t.h.Button,
+		Occupants: make([]*Occupant, t.h.Max),
+		Chips:     append([]int(nil), t.chips...),
+		Bet:       t.bet,
+		N:         len(t.h.Seats),
+		Max:       t.h.Max,
+	}
+	for _, pot := range calcPot(t.chips) {
+		room.Pot = append(room.Pot, pot.Pot)
+	}
+	for _, seat := range t.h.Seats {
+		room.Occupants[seat.Pos-1] = &Occupant{
+			Id:    seat.Id,
+			Name:  seat.Name,
+			Chips: t.stacks[seat.Pos],
+			Pos:   seat.Pos,
+			Bet:   t.bets[seat.Pos],
+			Cards: seat.Cards,
+		}
+	}
+	return room
+}
+
+// liveAction is the occupant action the table reported for a in its bet
+// events.
+func liveAction(a *HistoryAction) string {
+	switch {
+	case a.AllIn:
+		return ActAllin
+	case a.Action == HistorySmallBlind, a.Action == HistoryBigBlind, a.Action == HistoryBet:
+		return ActRaise
+	}
+	return a.Action
+}
+
+// prompt is the prompt the table sent the seat at pos to act.
+func (t *replayTable) prompt(pos int) *ActionPrompt {
+	room := &Room{Bet: t.bet, BB: t.h.BB, raise: t.raise}
+	o := &Occupant{Pos: pos, Bet: t.bets[pos], Chips: t.stacks[pos]}
+	return newActionPrompt(room, o)
+}
+
+// boardRank is the rank of the best hand of the seat with cards on the
+// board so far, as the table sent it with the board, or 0 without cards.
+func boardRank(cards, board []Card) int {
+	if len(cards) < 2 {
+		return 0
+	}
+	all := append(append([]Card(nil), cards...), board...)
+	switch len(all) {
+	case 5:
+		return Eva5Hand([5]Card(all)) >> 16
+	case 6:
+		return Eva6Hand([6]Card(all)) >> 16
+	case 7:
+		return Eva7Hand([7]Card(all)) >> 16
+	}
+	return 0
+}
+
+// Messages rebuilds the messages the table sent during the hand: state,
+// button, blinds, hole cards, action prompts and bets, the board and pots
+// after each street, and the showdown. Hole cards are dealt to every seat
+// with cards in h, one message each; the board comes with the rank of
+// hero's hand, if h has its cards.
+func (h *HandHistory) Messages(hero string) (messages []*Message) {
+	t := &replayTable{
+		h:      h,
+		stacks: make(map[int]int),
+		bets:   make(map[int]int),
+		chips:  make([]int, h.Max),
+		raise:  h.BB,
+	}
+	var heroCards []Card
+	if seat := h.Seat(hero); seat != nil {
+		heroCards = seat.Cards
+	}
+	for _, seat := range h.Seats {
+		t.stacks[seat.Pos] = seat.Chips
+	}
+	presence := func(action, class string) *Message {
+		m := &Message{
+			From:   h.Room,
+			Type:   MsgPresence,
+			Action: action,
+			Class:  class,
+		}
+		messages = append(messages, m)
+		return m
+	}
+	pots := func() {
+		var ps []string
+		var amounts []int
+		for _, pot := range calcPot(t.chips) {
+			ps = append(ps, strconv.Itoa(pot.Pot))
+			amounts = append(amounts, pot.Pot)
+		}
+		presence(ActPot, strings.Join(ps, ",")).Pots = &PotEvent{Pots: amounts}
+	}
+	deal := func(street string) {
+		t.bet = 0
+		t.bets = make(map[int]int)
+		t.raise = h.BB
+		var cards []Card
+		switch street {
+		case ActFlop:
+			t.board, cards = h.Board[:3], h.Board[:3]
+		case ActTurn:
+			t.board, cards = h.Board[:4], h.Board[3:4]
+		case ActRiver:
+			t.board, cards = h.Board[:5], h.Board[4:5]
+		}
+		rank := boardRank(heroCards, t.board)
+		class := make([]string, 0, len(cards)+1)
+		for _, card := range cards {
+			class = append(class, card.String())
+		}
+		class = append(class, strconv.Itoa(rank))
+		presence(street, strings.Join(class, ",")).Deal = &DealEvent{Cards: cards, Hand: rank}
+	}
+
+	dealt := false
+	holeCards := func() {
+		for _, seat := range h.Seats {
+			class := ""
+			if len(seat.Cards) > 1 {
+				class = seat.Cards[0].String() + "," + seat.Cards[1].String()
+			}
+			m := presence(ActPreflop, class)
+			m.To = seat.Id
+			m.Deal = &DealEvent{Cards: seat.Cards}
+		}
+		dealt = true
+	}
+
+	presence(ActState, "").Room = t.room()
+	presence(ActButton, strconv.Itoa(h.Button))
+
+	street := ActPreflop
+	for _, a := range h.Actions {
+		blind := a.Action == HistorySmallBlind || a.Action == HistoryBigBlind || a.Action == HistoryAnte
+		if !blind && !dealt {
+			holeCards()
+		}
+		for streetIndex(street) < streetIndex(a.Street) {
+			pots()
+			street = streets[streetIndex(street)+1]
+			deal(street)
+		}
+
+		if !blind {
+			presence(ActAction, fmt.Sprintf("%d,%d", a.Pos, t.bet)).Prompt = t.prompt(a.Pos)
+		}
+		t.stacks[a.Pos] -= a.Amount
+		t.bets[a.Pos] = a.Bet
+		t.chips[a.Pos-1] += a.Amount
+		if a.Bet > t.bet {
+			if a.Bet-t.bet >= t.raise {
+				t.raise = a.Bet - t.bet
+			}
+			t.bet = a.Bet
+		}
+		action := liveAction(a)
+		messages = append(messages, &Message{
+			Id:     h.Room,
+			Type:   MsgPresence,
+			From:   a.Id,
+			Action: ActBet,
+			Class:  action + "," + strconv.Itoa(a.Bet) + "," + strconv.Itoa(t.stacks[a.Pos]),
+			BetEvent: &BetEvent{
+				Pos:    a.Pos,
+				Action: action,
+				Bet:    a.Bet,
+				Chips:  t.stacks[a.Pos],
+			},
+		})
+	}
+	if !dealt {
+		holeCards()
+	}
+	// streets dealt with nobody left to act
+	for len(h.Board) > len(t.board) {
+		pots()
+		street = streets[streetIndex(street)+1]
+		deal(street)
+	}
+	pots()
+
+	// as at the live showdown, the room's chips are now what each seat won
+	t.chips = make([]int, h.Max)
+	for _, w := range h.Winners {
+		t.stacks[w.Pos] += w.Amount
+		t.chips[w.Pos-1] += w.Amount
+	}
+	t.bets = make(map[int]int)
+	t.bet = 0
+	room := t.room()
+	room.Pot = h.Pots
+	showdown := presence(ActShowdown, "")
+	showdown.Room = room
+	showdown.Hands = h.Shown
+	showdown.Rake = h.Rake
+	return
+}
@@ -0,0 +1,88 @@
+package poker
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestReplayMessages(t *testing.T) {
+	h := playHistoryHand(t).Histories()[0]
+	messages := h.View("b").Messages("b")
+
+	var actions []string
+	for _, m := range messages {
+		actions = append(actions, m.Action)
+	}
+	want := "state,button,bet,bet,preflop,preflop,preflop,action,bet,action,bet,action,bet,pot," +
+		"flop,action,bet,action,bet,action,bet,pot,turn,pot,river,pot,showdown"
+	if strings.Join(actions, ",") != want {
+		t.Fatalf("actions %s\nwant    %s", strings.Join(actions, ","), want)
+	}
+
+	if m := messages[2]; m.BetEvent.Pos != 1 || m.BetEvent.Bet != 5 || m.BetEvent.Chips != 995 || m.Class != "raise,5,995" {
+		t.Fatalf("small blind %+v %+v", m, m.BetEvent)
+	}
+	if m := messages[6]; m.To != "c" || len(m.Deal.Cards) != 0 {
+		t.Fatalf("another player's hole cards replayed %+v", m.Deal)
+	}
+	if m := messages[5]; m.To != "b" || m.Class != "SK,HK" {
+		t.Fatalf("own hole cards %+v", m)
+	}
+	if m := messages[13]; m.Pots.Pots[0] != 60 {
+		t.Fatalf("preflop pot %v", m.Pots.Pots)
+	}
+	if m := messages[14]; len(m.Deal.Cards) != 3 || m.Deal.Cards[0] != h.Board[0] ||
+		m.Deal.Hand != ThreeOfAKind || m.Class != fmt.Sprintf("CK,D3,H9,%d", ThreeOfAKind) {
+		t.Fatalf("flop %s %+v", m.Class, m.Deal)
+	}
+	if m := messages[len(messages)-3]; m.Action != ActRiver || m.Deal.Hand != ThreeOfAKind {
+		t.Fatalf("river %+v", m.Deal)
+	}
+
+	// the prompts as the table sent them: c to call the big blind, then
+	// alice to complete, then b to call alice's raise of 20
+	for i, want := range map[int]ActionPrompt{
+		7:  {Pos: 3, Bet: 10, ToCall: 10, MinRaise: 20, MaxBet: 1000},
+		9:  {Pos: 1, Bet: 10, ToCall: 5, MinRaise: 15, MaxBet: 995},
+		11: {Pos: 2, Bet: 30, ToCall: 20, MinRaise: 40, MaxBet: 990},
+	} {
+		p := messages[i].Prompt
+		if p == nil || p.Pos != want.Pos || p.Bet != want.Bet || p.ToCall != want.ToCall ||
+			p.MinRaise != want.MinRaise || p.MaxBet != want.MaxBet || len(p.Options) != 4 {
+			t.Errorf("prompt %d: %+v, want %+v", i, p, want)
+		}
+	}
+	if spectator := h.Messages(""); spectator[14].Deal.Hand != 0 {
+		t.Fatal("hand rank replayed without a hero")
+	}
+
+	showdown := messages[len(messages)-1]
+	if showdown.Room.Occupants[1].Chips != 1080 || showdown.Room.Occupants[0].Chips != 920 || len(showdown.Hands) == 0 {
+		t.Fatalf("showdown %+v", showdown.Room)
+	}
+}
+
+func TestReplayStepSeek(t *testing.T) {
+	r := NewReplay(playHistoryHand(t).Histories()[0], "")
+	n := r.Len()
+
+	if m := r.Step(1); len(m) != 1 || m[0].Action != ActState || r.Pos() != 1 {
+		t.Fatalf("step %v at %d", m, r.Pos())
+	}
+	if m := r.Step(2); len(m) != 2 || m[1].Action != ActBet {
+		t.Fatalf("step 2 %v", m)
+	}
+	if m := r.Step(-2); m != nil || r.Pos() != 1 {
+		t.Fatalf("step back %v at %d", m, r.Pos())
+	}
+	if m := r.Seek(n + 10); len(m) != n || r.Pos() != n {
+		t.Fatalf("seek past the end: %d messages at %d", len(m), r.Pos())
+	}
+	if m := r.Step(1); m != nil {
+		t.Fatal("stepped past the end")
+	}
+	if m := r.Seek(-1); len(m) != 0 || r.Pos() != 0 {
+		t.Fatal("seek before the start")
+	}
+}
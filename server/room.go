@@ -1,28 +1,20 @@
 package poker
 
 import (
-	"context"
 	"fmt"
-	"github.com/block-vision/sui-go-sdk/constant"
-	"github.com/block-vision/sui-go-sdk/models"
-	"github.com/block-vision/sui-go-sdk/signer"
-	"github.com/block-vision/sui-go-sdk/sui"
 	"github.com/ecodeclub/ekit/mapx"
 	"log"
 	"mental-poker/mental_poker"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
 	actionWait = 20 * time.Second
 	MaxN       = 10
-	mnemonic   = "fly happy jungle remind dune replace deer travel good man sleep faint"
-	// address 0x813f37c19631325b3bb81c4922e8244c53b42afd46c755ee3e8da779903bbfd9
-	MovePkgID     = "0x63aeecd28b9bc9cf5476bac7a6ad3e62e5427c15f0a210335df271e75c89baa9"
-	GameDataObjID = "0x0a84bc3803250c79790245cf2c8ea8f74f9aa48efe5f40a30a205d9233cc5126"
 )
 
 type Room struct {
@@ -40,15 +32,44 @@ type Room struct {
 	Max       int         `json:"max"`
 	MaxChips  int         `json:"maxchips"`
 	MinChips  int         `json:"minchips"`
+	Rake      *RakeConfig `json:"rake,omitempty"`
 	remain    int
+	dealt     int // occupants dealt into the current hand
 	allin     int
-	EndChan   chan int `json:"-"`
+	aggressor int          // last occupant to bet or raise in the current round
+	raise     int          // size of the last full raise in the current round
+	shown     map[int]bool // positions whose cards were tabled at showdown
+	EndChan   chan int     `json:"-"`
 	exitChan  chan interface{}
 	startChan chan struct{}
 	lock      sync.Mutex
 	//deck       *Deck
 	maskedDeck *DeckMasked
 	game       *mental_poker.Game
+	transcript *Transcript
+	hand       int          // number of the current or last hand
+	rake       int          // taken from the last hand
+	history    *HandHistory // of the hand being played
+	histories  []*HandHistory
+	idleChat   []*HistoryChat // sent since the last hand
+	ledger     *Ledger
+	spectators map[string]*Occupant
+	muted      map[string]bool // players who may not chat
+	watchLock  sync.Mutex      // guards spectators, muted and shown
+	reserved   map[int]*reservation
+	waiting    []*Occupant // for a seat, first come first served
+	sbPos      int         // blinds of the last hand
+	bbPos      int
+
+	MaxSpectators int `json:"max_spectators,omitempty"`
+	Spectators    int `json:"spectators,omitempty"`
+	MaxTimeouts   int `json:"max_timeouts,omitempty"` // in a row before sitting out
+
+	Ante       int         `json:"ante,omitempty"`
+	Tournament *Tournament `json:"tournament,omitempty"`
+	director   *Director   // of the multi-table tournament it is a table of
+
+	halted atomic.Bool // chips not conserved, see halt
 }
 
 func NewRoom(id string, max int, sb, bb int) *Room {
@@ -56,6 +77,10 @@ func NewRoom(id string, max int, sb, bb int) *Room {
 		max = 9 // default 9 occupants
 	}
 
+	rakeLock.Lock()
+	rake := defaultRake
+	rakeLock.Unlock()
+
 	room := &Room{
 		Id:        id,
 		Occupants: make([]*Occupant, max, MaxN),
@@ -65,11 +90,20 @@ func NewRoom(id string, max int, sb, bb int) *Room {
 		Pot:       make([]int, 1),
 		Timeout:   10,
 		Max:       max,
+		Rake:      rake,
 		lock:      sync.Mutex{},
 		//deck:      NewDeck(),
-		EndChan:   make(chan int),
-		exitChan:  make(chan interface{}, 1),
-		startChan: make(chan struct{}, 1),
+		EndChan:    make(chan int),
+		exitChan:   make(chan interface{}, 1),
+		startChan:  make(chan struct{}, 1),
+		transcript: newTranscript(),
+		ledger:     NewLedger(),
+		spectators: make(map[string]*Occupant),
+		muted:      make(map[string]bool),
+		reserved:   make(map[int]*reservation),
+
+		MaxSpectators: defaultMaxSpectators,
+		MaxTimeouts:   defaultMaxTimeouts,
 	}
 	go func() {
 		timer := time.NewTimer(time.Second * 6)
@@ -89,6 +123,14 @@ func NewRoom(id string, max int, sb, bb int) *Room {
 	return room
 }
 
+// exit stops the loop starting the room's hands.
+func (room *Room) exit() {
+	select {
+	case room.exitChan <- 0:
+	default:
+	}
+}
+
 func (room *Room) Cap() int {
 	return len(room.Occupants)
 }
@@ -103,27 +145,9 @@ func (room *Room) Occupant(id string) *Occupant {
 	return nil
 }
 
+// AddOccupant seats o at its reserved seat or the first free one.
 func (room *Room) AddOccupant(o *Occupant) int {
-	room.lock.Lock()
-	defer room.lock.Unlock()
-
-	// room not exists
-	if len(room.Id) == 0 {
-		return 0
-	}
-
-	for pos, _ := range room.Occupants {
-		if room.Occupants[pos] == nil {
-			room.Occupants[pos] = o
-			room.N++
-			//log.Println(room.N)
-			o.Room = room
-			o.Pos = pos + 1
-			break
-		}
-	}
-
-	return o.Pos
+	return room.AddOccupantAt(o, 0)
 }
 
 func (room *Room) DelOccupant(o *Occupant) {
@@ -145,12 +169,11 @@ func (room *Room) DelOccupant(o *Occupant) {
 		}
 	*/
 
+	room.offerSeats()
+
 	if room.N == 0 {
 		DelRoom(room)
-		select {
-		case room.exitChan <- 0:
-		default:
-		}
+		room.exit()
 	}
 
 	if room.remain <= 1 {
@@ -167,6 +190,9 @@ func (room *Room) Broadcast(message *Message) {
 			o.SendMessage(message)
 		}
 	}
+	for _, o := range room.spectatorList() {
+		o.SendMessage(message)
+	}
 }
 
 // start starts from 0
@@ -186,53 +212,72 @@ func (room *Room) Each(start int, f func(o *Occupant) (isContinue bool)) {
 }
 
 func (room *Room) start() {
-	var dealer *Occupant
-	// remove zero chips user
-	// 合约交互
-	room.Each(0, func(o *Occupant) bool {
-		if o.Chips < room.BB {
-			o.Leave()
+	if room.halted.Load() {
+		return
+	}
+	room.applyRebuys()
+
+	if room.Tournament != nil {
+		room.director.seat(room)
+		if !room.startTournamentHand() || !room.director.deal(room) {
+			return
 		}
-		return true
-	})
+	} else {
+		// remove zero chips user
+		// 合约交互
+		room.Each(0, func(o *Occupant) bool {
+			if o.Chips == 0 {
+				o.Leave()
+			} else if o.Chips < room.BB {
+				o.CashOut()
+			}
+			return true
+		})
+	}
 	room.lock.Lock()
-	if room.N < 2 {
+	if room.count(func(o *Occupant) bool { return !room.away(o) }) < 2 {
 		room.lock.Unlock()
 		return
 	}
-
-	room.setup()
-	// Select Dealer
-	button := room.Button - 1
-	room.Each((button+1)%room.Cap(), func(o *Occupant) bool {
-		room.Button = o.Pos
-		dealer = o
-		return false
-	})
-
-	if dealer == nil {
+	// a fresh game for the hand, before the blinds move: without one there
+	// is no hand
+	if err := room.SetUpGame(); err != nil {
+		log.Println("set up", room.Id, err)
+		room.lock.Unlock()
 		return
 	}
-
-	//room.deck.Shuffle()
-
-	// Small Blind
-	sb := dealer.Next()
-	if room.N == 2 { // one-to-one
-		sb = dealer
+	// Select Dealer and Blinds
+	sb, bb := room.dealIn()
+	if bb == nil {
+		room.lock.Unlock()
+		return
 	}
-	// Big Blind
-	bb := sb.Next()
 	bbPos := bb.Pos
 
+	room.setup()
+	room.hand++
+
+	//room.deck.Shuffle()
+
 	room.Pot = nil
 	room.Chips = make([]int, room.Max)
 	room.Bet = 0
+	room.raise = room.BB
 	room.Cards = nil
 	room.remain = 0
 	room.allin = 0
+	room.aggressor = 0
+	room.setShown(nil)
+	room.rake = 0
 	room.Each(0, func(o *Occupant) bool {
 		o.Bet = 0
+		o.muck = nil
+		if o.out {
+			o.Cards = nil
+			o.Hand = 0
+			o.Action = ""
+			return true
+		}
 		cards, err := room.DealCard(o, 2)
 		if err != nil {
 			//log.Println(err)
@@ -245,6 +290,8 @@ func (room *Room) start() {
 
 		return true
 	})
+	room.dealt = room.remain
+	room.history = room.newHistory()
 	room.lock.Unlock()
 
 	room.Broadcast(&Message{
@@ -254,11 +301,30 @@ func (room *Room) start() {
 		Class:  strconv.Itoa(room.Button),
 	})
 
-	room.betting(sb.Pos, room.SB)
-	room.betting(bb.Pos, room.BB)
+	if room.Ante > 0 {
+		room.Each(0, func(o *Occupant) bool {
+			if !o.out {
+				room.postAnte(o)
+			}
+			return true
+		})
+	}
+	// short stacks post what they have
+	if sb != nil && sb.Chips > 0 { // else dead
+		room.betting(sb.Pos, min(room.SB, sb.Chips), EntryBlind)
+	}
+	if bb.Chips > 0 {
+		room.betting(bb.Pos, min(room.BB, bb.Chips), EntryBlind)
+	}
+	room.Each(0, func(o *Occupant) bool {
+		if !o.out {
+			room.postOwed(o)
+		}
+		return true
+	})
 
 	// Round 1 : preflop
-	room.Each(sb.Pos-1, func(o *Occupant) bool {
+	room.Each(room.Button%room.Cap(), func(o *Occupant) bool {
 		class := ""
 		if len(o.Cards) > 0 {
 			class = o.Cards[0].String() + "," + o.Cards[1].String()
@@ -268,6 +334,7 @@ func (room *Room) start() {
 			Type:   MsgPresence,
 			Action: ActPreflop,
 			Class:  class, // todo 为什么空
+			Deal:   &DealEvent{Cards: o.Cards},
 		})
 		return true
 	})
@@ -303,6 +370,7 @@ func (room *Room) start() {
 			Type:   MsgPresence,
 			Action: ActFlop,
 			Class:  fmt.Sprintf("%s,%s,%s,%d", room.Cards[0], room.Cards[1], room.Cards[2], o.Hand>>16),
+			Deal:   &DealEvent{Cards: room.Cards[:3], Hand: o.Hand >> 16},
 		})
 
 		return true
@@ -335,6 +403,7 @@ func (room *Room) start() {
 			Type:   MsgPresence,
 			Action: ActTurn,
 			Class:  fmt.Sprintf("%s,%d", room.Cards[3], o.Hand>>16),
+			Deal:   &DealEvent{Cards: room.Cards[3:4], Hand: o.Hand >> 16},
 		})
 
 		return true
@@ -365,6 +434,7 @@ func (room *Room) start() {
 			Type:   MsgPresence,
 			Action: ActRiver,
 			Class:  fmt.Sprintf("%s,%d", room.Cards[4], o.Hand>>16),
+			Deal:   &DealEvent{Cards: room.Cards[4:5], Hand: o.Hand >> 16},
 		})
 
 		return true
@@ -372,13 +442,20 @@ func (room *Room) start() {
 	room.action(0)
 
 showdown:
-	room.showdown()
+	hands := room.showdown()
+	if err := room.checkChips(); err != nil {
+		room.halt(err)
+		return
+	}
+	room.archiveHistory()
 	// Final : Showdown
 	room.Broadcast(&Message{
 		From:   room.Id,
 		Type:   MsgPresence,
 		Action: ActShowdown,
 		Room:   room,
+		Hands:  hands,
+		Rake:   room.rake,
 	})
 	//log.Println("showdown end ", room.Id)
 	room.checkAndEndGame()
@@ -414,6 +491,8 @@ func (room *Room) CollectRevealTokens(o *Occupant, cards []string) ([]*mental_po
 		// todo encrypt token with users pk
 		for card, cardAndProof := range tokenResp.TokenMap {
 			dealCardMap[card].RevealToken = append(dealCardMap[card].RevealToken, cardAndProof)
+			proof := cardAndProof.PedersenProof
+			room.transcript.Add("reveal", card, cardAndProof.Token, proof.A, proof.B, proof.R, cardAndProof.PublicKey)
 		}
 	}
 	return mapx.Values(dealCardMap), nil
@@ -441,29 +520,38 @@ func (room *Room) action(pos int) {
 			if o.Pos == skip || o.Chips == 0 || len(o.Cards) == 0 {
 				return true
 			}
+			if room.sittingOut(o) {
+				room.betting(o.Pos, -1, EntryBet)
+				return true
+			}
 
+			prompt := newActionPrompt(room, o)
 			room.Broadcast(&Message{
 				From:   room.Id,
 				Type:   MsgPresence,
 				Action: ActAction,
 				Class:  fmt.Sprintf("%d,%d", o.Pos, room.Bet),
+				Prompt: prompt,
 			})
 
-			msg, _ := o.GetAction(time.Duration(room.Timeout) * time.Second)
+			msg, err := o.GetAction(time.Duration(room.Timeout) * time.Second)
 			if room.remain <= 1 {
 				return false
 			}
 
-			n := 0
-			// timeout or leave
-			if msg == nil || len(msg.Class) == 0 {
-				n = -1
-			} else {
-				n, _ = strconv.Atoi(msg.Class)
+			n := -1 // timeout or leave
+			if msg != nil {
+				o.timeouts = 0
+				if bet, err := msg.BetAmount(); err == nil {
+					n = bet
+				}
+			} else if err != nil {
+				room.timedOut(o)
 			}
 
-			if room.betting(o.Pos, n) {
+			if room.betting(o.Pos, prompt.legal(n), EntryBet) {
 				raised = o.Pos
+				room.aggressor = o.Pos
 				return false
 			}
 
@@ -493,13 +581,24 @@ func (room *Room) calc() (pots []handPot) {
 		Type:   MsgPresence,
 		Action: ActPot,
 		Class:  strings.Join(ps, ","),
+		Pots:   &PotEvent{Pots: room.Pot},
 	})
 
 	return
 }
 
-func (room *Room) showdown() {
+func (room *Room) showdown() (hands []*ShownHand) {
 	pots := room.calc()
+	if room.remain > 1 {
+		hands = room.showHands(pots)
+	}
+	shown := make(map[int]bool)
+	for _, hand := range hands {
+		if !hand.Muck {
+			shown[hand.Pos] = true
+		}
+	}
+	room.setShown(shown)
 
 	for i, _ := range room.Chips {
 		room.Chips[i] = 0
@@ -508,7 +607,18 @@ func (room *Room) showdown() {
 	room.lock.Lock()
 	defer room.lock.Unlock()
 
-	for _, pot := range pots {
+	room.rake = room.Rake.take(pots, room.dealt, len(room.Cards) >= 3)
+	room.ledger.Transfer(room.hand, EntryRake, AccountPot, AccountHouse, room.rake)
+	if h := room.history; h != nil {
+		h.Rake = room.rake
+		h.Shown = hands
+		for _, pot := range pots {
+			h.Pots = append(h.Pots, pot.Pot)
+		}
+	}
+
+	var last []int // winners of the pot before
+	for i, pot := range pots {
 		maxHand := 0
 		for _, pos := range pot.OPos {
 			o := room.Occupants[pos-1]
@@ -527,25 +637,39 @@ func (room *Room) showdown() {
 		}
 
 		if len(winners) == 0 {
-			//fmt.Println("!!!no winners!!!")
-			return
+			// everyone in the pot left, like a bettor cashing out before
+			// the raise was called: it goes to the players still contending
+			winners = last
 		}
+		if len(winners) == 0 {
+			log.Println("showdown", room.Id, room.hand, "no winner for pot", i)
+			continue
+		}
+		last = winners
 
-		for _, winner := range winners {
-			room.Chips[winner-1] += pot.Pot / len(winners)
+		for j, winner := range winners {
+			n := pot.Pot / len(winners)
+			if j == 0 {
+				n += pot.Pot % len(winners) // odd chips
+			}
+			room.Chips[winner-1] += n
+			room.recordWin(i, winner, n, len(pot.OPos) == 1 && pot.OPos[0] == winner)
 		}
-		room.Chips[winners[0]-1] += pot.Pot % len(winners) // odd chips
 	}
 
 	for i, _ := range room.Chips {
-		if room.Occupants[i] != nil {
-			room.Occupants[i].Chips += room.Chips[i]
+		if o := room.Occupants[i]; o != nil {
+			o.Chips += room.Chips[i]
+			room.ledger.Transfer(room.hand, EntryAward, AccountPot, PlayerAccount(o.Id), room.Chips[i])
 		}
 	}
+	return
 }
 
 func (room *Room) ready() {
 	room.Bet = 0
+	room.raise = room.BB
+	room.aggressor = 0
 	room.lock.Lock()
 	defer room.lock.Unlock()
 
@@ -562,7 +686,9 @@ func (room *Room) ready() {
 
 }
 
-func (room *Room) betting(pos, n int) (raised bool) {
+// betting applies an occupant's bet of n chips, or a fold if n < 0, and
+// records the chips moved to the pot as kind.
+func (room *Room) betting(pos, n int, kind string) (raised bool) {
 	if pos <= 0 {
 		return
 	}
@@ -574,7 +700,10 @@ func (room *Room) betting(pos, n int) (raised bool) {
 	if o == nil {
 		return
 	}
+	bet, chips := room.Bet, room.Chips[pos-1]
 	raised = o.Betting(n)
+	room.ledger.Transfer(room.hand, kind, PlayerAccount(o.Id), AccountPot, room.Chips[pos-1]-chips)
+	room.recordAction(o, kind, bet, room.Chips[pos-1]-chips, raised)
 	if o.Action == ActFold {
 		room.remain--
 	}
@@ -588,93 +717,53 @@ func (room *Room) betting(pos, n int) (raised bool) {
 		From:   o.Id,
 		Action: ActBet,
 		Class:  o.Action + "," + strconv.Itoa(o.Bet) + "," + strconv.Itoa(o.Chips),
+		BetEvent: &BetEvent{
+			Pos:    o.Pos,
+			Action: o.Action,
+			Bet:    o.Bet,
+			Chips:  o.Chips,
+		},
 	})
 
 	return
 }
 
+// checkAndEndGame settles the game on chain once at most one player has chips left.
 func (room *Room) checkAndEndGame() {
-	type UserSettle struct {
-		Player     string `json:"player"`
-		ChipAmount int    `json:"chip_amount"`
+	if room.Tournament != nil {
+		room.eliminate()
+		return
 	}
-	userSettles := []UserSettle{}
 
+	var players []*Occupant
 	hasChipUserCnt := 0
-	playerCnt := 0
 	for _, occupant := range room.Occupants {
 		if occupant != nil && occupant.player != nil {
-			playerCnt++
-			if occupant.Chips > 0 {
+			if occupant.Chips > 0 || occupant.rebuying() {
 				hasChipUserCnt++
 			}
-			userSettles = append(userSettles, UserSettle{
-				Player:     occupant.Id,
-				ChipAmount: occupant.Chips,
-			})
+			players = append(players, occupant)
 		}
 	}
-	//log.Println("userSettles:", userSettles, playerCnt, hasChipUserCnt)
-	if len(userSettles) != 2 {
-		//log.Println("num not match %d", len(userSettles))
-		return
-	}
 
 	// gameover
-	if playerCnt > 0 && hasChipUserCnt <= 1 {
-		room.Each(0, func(o *Occupant) bool {
-			o.Leave()
-			return true
-		})
-		//log.Println("checkAndEndGame leave users")
-		// call contract endgame
-		cli := sui.NewSuiClient(constant.SuiTestnetEndpoint)
-		signerAccount, err := signer.NewSignertWithMnemonic(mnemonic)
-		if err != nil {
-			log.Panicln(err)
-		}
-		rsp, err := cli.MoveCall(context.Background(), models.MoveCallRequest{
-			Signer:          signerAccount.Address,
-			PackageObjectId: MovePkgID,
-			Module:          "mental_poker",
-			Function:        "end_game",
-			TypeArguments:   []interface{}{},
-			Arguments: []interface{}{
-				room.game.GameID,
-				GameDataObjID,
-				userSettles[0].Player,
-				strconv.Itoa(int(userSettles[0].ChipAmount)),
-				userSettles[1].Player,
-				strconv.Itoa(int(userSettles[1].ChipAmount)),
-				"proof", // todo proof
-			},
-			//Gas:       &gasObj,
-			GasBudget: "100000000",
-		})
+	if len(players) == 0 || hasChipUserCnt > 1 {
+		return
+	}
 
-		if err != nil {
-			//log.Println(room.game.GameID, GameDataObjID)
-			//log.Println("checkAndEndGame MoveCall", err.Error())
-			return
-		}
-		// see the successful transaction url: https://explorer.sui.io/txblock/CD5hFB4bWFThhb6FtvKq3xAxRri72vsYLJAVd7p9t2sR?network=testnet
-		rsp2, err := cli.SignAndExecuteTransactionBlock(context.Background(), models.SignAndExecuteTransactionBlockRequest{
-			TxnMetaData: rsp,
-			PriKey:      signerAccount.PriKey,
-			// only fetch the effects field
-			Options: models.SuiTransactionBlockOptions{
-				ShowInput:    true,
-				ShowRawInput: true,
-				ShowEffects:  true,
-			},
-			RequestType: "WaitForLocalExecution",
-		})
-		if err != nil {
-			//log.Println("checkAndEndGame SignAndExecuteTransactionBlock", err.Error())
-			return
-		}
-		log.Println(rsp2)
+	s := room.settlement(players, false)
+	room.Each(0, func(o *Occupant) bool {
+		room.ledger.Transfer(room.hand, EntryCashOut, PlayerAccount(o.Id), AccountCashier, o.Chips)
+		o.Leave()
+		return true
+	})
+	//log.Println("checkAndEndGame leave users")
+	// call contract endgame
+	if err := room.enqueue(s); err != nil {
+		log.Println("checkAndEndGame", s.GameID, err)
+		return
 	}
+	room.transcript = newTranscript()
 }
 
 type roomlist struct {
@@ -725,6 +814,14 @@ func GetRoom(id string) *Room {
 	return room
 }
 
+// lookupRoom returns the room with id, or nil.
+func lookupRoom(id string) *Room {
+	rooms.lock.Lock()
+	defer rooms.lock.Unlock()
+
+	return rooms.M[id]
+}
+
 func RoomExist(id string) bool {
 	rooms.lock.Lock()
 	defer rooms.lock.Unlock()
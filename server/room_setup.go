@@ -21,8 +21,10 @@ func (room *Room) TryStart() {
 	}
 }
 
+// setup shuffles the deck of the game set up for the hand. Called with the
+// lock held.
 func (room *Room) setup() error {
-	room.SetUpGame()
+	room.transcript.Add("seed", room.game.SeedHex)
 	players := []*mental_poker.Player{}
 
 	room.Each(0, func(o *Occupant) bool {
@@ -74,6 +76,7 @@ func (room *Room) setup() error {
 				return verifyShuffleErr
 			}
 		}
+		room.transcript.Add("shuffle", append(shuffleResp.Cards, shuffleResp.ShuffleProof)...)
 		originCards = shuffleResp.Cards
 		finalCards = shuffleResp.Cards
 		finalProof = shuffleResp.ShuffleProof
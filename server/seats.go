@@ -0,0 +1,233 @@
+package poker
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// seatHold is how long a seat stays reserved for a player buying in, or
+// offered to a player from the waiting list.
+const seatHold = time.Minute
+
+var (
+	errSeatTaken = errors.New("seat is taken")
+	errNoSeat    = errors.New("no such seat")
+	errSignIn    = errors.New("sign in with a Sui address first")
+)
+
+// reservation holds a seat for the player id until it expires.
+type reservation struct {
+	id    string
+	timer *time.Timer
+}
+
+// seatFree reports whether the seat at pos may be taken by the player id.
+// Called with the lock held.
+func (room *Room) seatFree(pos int, id string) bool {
+	if room.Occupants[pos-1] != nil {
+		return false
+	}
+	r := room.reserved[pos]
+	return r == nil || r.id == id
+}
+
+// findSeat returns pos if the player id may take it, or for pos 0 the seat
+// reserved for id, or else the first free seat. It returns 0 if there is
+// no seat. Called with the lock held.
+func (room *Room) findSeat(pos int, id string) int {
+	if pos != 0 {
+		if pos < 0 || pos > room.Cap() || !room.seatFree(pos, id) {
+			return 0
+		}
+		return pos
+	}
+
+	for p, r := range room.reserved {
+		if r.id == id && room.Occupants[p-1] == nil {
+			return p
+		}
+	}
+	for p := 1; p <= room.Cap(); p++ {
+		if room.seatFree(p, id) {
+			return p
+		}
+	}
+	return 0
+}
+
+// AddOccupantAt seats o at pos, or anywhere for pos 0, and returns its
+// position, or 0 if the seat is taken or reserved for someone else.
+func (room *Room) AddOccupantAt(o *Occupant, pos int) int {
+	return room.seat(o, pos, func() {})
+}
+
+// seat is AddOccupantAt, calling sit with the lock held once o has its
+// seat, before a hand can deal it in.
+func (room *Room) seat(o *Occupant, pos int, sit func()) int {
+	room.lock.Lock()
+	defer room.lock.Unlock()
+
+	// room not exists
+	if len(room.Id) == 0 {
+		return 0
+	}
+
+	pos = room.findSeat(pos, o.Id)
+	if pos == 0 {
+		return 0
+	}
+	room.Occupants[pos-1] = o
+	room.N++
+	o.Room = room
+	o.Pos = pos
+	sit()
+
+	room.unreserve(o.Id)
+	room.unwait(o)
+	return pos
+}
+
+// Reserve holds the seat at pos, or any free seat for pos 0, for o while it
+// buys in, and returns the seat. A player holds at most one seat per room,
+// and only a signed-in player, who can buy in, holds one.
+func (room *Room) Reserve(o *Occupant, pos int) (int, error) {
+	if o.address == "" {
+		return 0, errSignIn
+	}
+	return room.holdSeat(o.Id, pos)
+}
+
+// holdSeat reserves the seat at pos, or any free seat for pos 0, for the
+// player id, who is not seated yet.
+func (room *Room) holdSeat(id string, pos int) (int, error) {
+	room.lock.Lock()
+	defer room.lock.Unlock()
+
+	if room.Occupant(id) != nil {
+		return 0, errSeated
+	}
+	if pos < 0 || pos > room.Cap() {
+		return 0, errNoSeat
+	}
+	room.unreserve(id)
+	if p := room.findSeat(pos, id); p == 0 && pos == 0 {
+		return 0, errRoomFull
+	} else if p == 0 {
+		return 0, errSeatTaken
+	} else {
+		pos = p
+	}
+
+	room.reserve(pos, id)
+	return pos, nil
+}
+
+// reserve holds the seat at pos for the player id. Called with the lock held.
+func (room *Room) reserve(pos int, id string) {
+	r := &reservation{id: id}
+	r.timer = time.AfterFunc(seatHold, func() {
+		room.expire(pos, r)
+	})
+	room.reserved[pos] = r
+}
+
+// unreserve releases any seat held for the player id. Called with the lock
+// held.
+func (room *Room) unreserve(id string) {
+	for pos, r := range room.reserved {
+		if r.id == id {
+			r.timer.Stop()
+			delete(room.reserved, pos)
+		}
+	}
+}
+
+// expire releases a reservation nobody used and offers the seat to the
+// waiting list.
+func (room *Room) expire(pos int, r *reservation) {
+	room.lock.Lock()
+	defer room.lock.Unlock()
+
+	if room.reserved[pos] != r {
+		return
+	}
+	delete(room.reserved, pos)
+	room.offerSeats()
+}
+
+// Wait puts o, signed in, on the room's waiting list and returns its place
+// in line, or 0 if it was offered a seat right away.
+func (room *Room) Wait(o *Occupant) (int, error) {
+	if o.address == "" {
+		return 0, errSignIn
+	}
+
+	room.lock.Lock()
+	defer room.lock.Unlock()
+
+	if room.Occupant(o.Id) != nil {
+		return 0, errSeated
+	}
+	if room.waitingIndex(o) < 0 {
+		room.waiting = append(room.waiting, o)
+	}
+	room.offerSeats()
+	return room.waitingIndex(o) + 1, nil
+}
+
+// Unwait takes o off the room's waiting list.
+func (room *Room) Unwait(o *Occupant) {
+	room.lock.Lock()
+	defer room.lock.Unlock()
+
+	room.unwait(o)
+}
+
+// unwaitAll takes o off every waiting list, once its connection is gone.
+func (o *Occupant) unwaitAll() {
+	for _, room := range Rooms() {
+		room.Unwait(o)
+	}
+}
+
+func (room *Room) unwait(o *Occupant) {
+	if i := room.waitingIndex(o); i >= 0 {
+		room.waiting = append(room.waiting[:i], room.waiting[i+1:]...)
+	}
+}
+
+func (room *Room) waitingIndex(o *Occupant) int {
+	for i, w := range room.waiting {
+		if w.Id == o.Id {
+			return i
+		}
+	}
+	return -1
+}
+
+// offerSeats reserves each free seat for the next player on the waiting
+// list and tells it so. A player that does not take its seat in time loses
+// its place. Called with the lock held.
+func (room *Room) offerSeats() {
+	for pos := 1; pos <= room.Cap() && len(room.waiting) > 0; pos++ {
+		if room.Occupants[pos-1] != nil || room.reserved[pos] != nil {
+			continue
+		}
+		o := room.waiting[0]
+		room.waiting = room.waiting[1:]
+		room.reserve(pos, o.Id)
+		o.SendMessage(&Message{
+			From:   room.Id,
+			Type:   MsgPresence,
+			Action: ActOffer,
+			Class:  strconv.Itoa(pos),
+		})
+	}
+}
+
+// seated reports whether o holds a seat at a table.
+func (o *Occupant) seated() bool {
+	room := o.Room
+	return room != nil && room.Occupant(o.Id) == o
+}
@@ -0,0 +1,106 @@
+package poker
+
+import (
+	"testing"
+)
+
+func TestSeatSelection(t *testing.T) {
+	room := NewRoom(t.Name(), 3, 5, 10)
+	stopRoom(t, room)
+	a, b, c, d := newTestOccupant("a", 1000), newTestOccupant("b", 1000), newTestOccupant("c", 1000), newTestOccupant("d", 1000)
+
+	if pos := room.AddOccupantAt(a, 2); pos != 2 || room.Occupants[1] != a {
+		t.Fatalf("a seated at %d, want 2", pos)
+	}
+	if pos := room.AddOccupantAt(b, 2); pos != 0 {
+		t.Fatal("b took a's seat")
+	}
+	if _, err := room.Reserve(c, 2); err != errSeatTaken {
+		t.Fatalf("reserving a taken seat: %v", err)
+	}
+	if pos, err := room.Reserve(c, 3); err != nil || pos != 3 {
+		t.Fatalf("reserve: %d, %v", pos, err)
+	}
+	if pos := room.AddOccupantAt(b, 3); pos != 0 {
+		t.Fatal("b took c's reserved seat")
+	}
+	if pos := room.AddOccupant(b); pos != 1 {
+		t.Fatalf("b seated at %d, want 1", pos)
+	}
+	if pos := room.AddOccupant(d); pos != 0 {
+		t.Fatal("d took c's reserved seat")
+	}
+	if pos := room.AddOccupant(c); pos != 3 || len(room.reserved) != 0 {
+		t.Fatalf("c seated at %d, want its reserved 3", pos)
+	}
+	if _, err := room.Reserve(d, 0); err != errRoomFull {
+		t.Fatalf("reserving in a full room: %v", err)
+	}
+	if _, err := room.Reserve(a, 0); err != errSeated {
+		t.Fatalf("reserving while seated: %v", err)
+	}
+}
+
+func TestWaitingList(t *testing.T) {
+	room := newTestRoom(t, 1000, 1000)
+	e, f := newTestOccupant("e", 1000), newTestOccupant("f", 1000)
+
+	if place, err := room.Wait(e); err != nil || place != 1 {
+		t.Fatalf("e waiting at %d, %v", place, err)
+	}
+	if place, _ := room.Wait(f); place != 2 {
+		t.Fatalf("f waiting at %d, want 2", place)
+	}
+	if _, err := room.Wait(room.Occupants[0]); err != errSeated {
+		t.Fatalf("seated occupant waiting: %v", err)
+	}
+
+	room.DelOccupant(room.Occupants[0])
+	messages := received(t, e)
+	if len(messages) != 1 || messages[0].Action != ActOffer || messages[0].Class != "1" {
+		t.Fatalf("e got %d messages, want an offer of seat 1", len(messages))
+	}
+	if len(received(t, f)) != 0 {
+		t.Fatal("f offered a seat out of turn")
+	}
+	if pos := room.AddOccupant(f); pos != 0 {
+		t.Fatal("f took the seat offered to e")
+	}
+
+	// e does not take the seat in time
+	room.expire(1, room.reserved[1])
+	messages = received(t, f)
+	if len(messages) != 1 || messages[0].Action != ActOffer || messages[0].Class != "1" {
+		t.Fatalf("f got %d messages, want an offer of seat 1", len(messages))
+	}
+	if pos := room.AddOccupant(f); pos != 1 || len(room.waiting) != 0 || len(room.reserved) != 0 {
+		t.Fatalf("f seated at %d, want 1", pos)
+	}
+	if pos := room.AddOccupant(e); pos != 0 {
+		t.Fatal("e seated after its offer expired")
+	}
+}
+
+func TestWaitingListSignIn(t *testing.T) {
+	room := newTestRoom(t, 1000, 1000)
+	SetRoom(room)
+	defer DelRoom(room)
+
+	plain := newTestOccupant("plain", 0)
+	plain.address = ""
+	if _, err := room.Reserve(plain, 0); err != errSignIn {
+		t.Fatalf("plain connection reserved a seat: %v", err)
+	}
+	if _, err := room.Wait(plain); err != errSignIn {
+		t.Fatalf("plain connection waiting: %v", err)
+	}
+
+	// a waiter whose connection closed gives up its place
+	e, f := newTestOccupant("e", 0), newTestOccupant("f", 0)
+	room.Wait(e)
+	room.Wait(f)
+	e.unwaitAll()
+	if place, _ := room.Wait(f); place != 1 {
+		t.Fatalf("f waiting at %d after e left, want 1", place)
+	}
+}
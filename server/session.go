@@ -0,0 +1,101 @@
+package poker
+
+import (
+	"slices"
+	"sync"
+)
+
+const (
+	// replayBuffer is how many outgoing messages are kept per occupant for resume.
+	replayBuffer = 256
+)
+
+// session numbers the messages sent to one occupant and keeps the most
+// recent ones so a reconnecting client can be sent what it missed.
+type session struct {
+	lock sync.Mutex
+	seq  uint64
+	buf  []*Message
+}
+
+func newSession() *session {
+	return &session{
+		buf: make([]*Message, 0, replayBuffer),
+	}
+}
+
+// send stamps a copy of message with the next sequence number, records it
+// and hands it to write. The message is recorded even if write fails, so it
+// can be replayed on resume.
+func (s *session) send(message *Message, write func(m *Message) error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	m := message.snapshot()
+	s.seq++
+	m.Seq = s.seq
+	if len(s.buf) == replayBuffer {
+		copy(s.buf, s.buf[1:])
+		s.buf = s.buf[:replayBuffer-1]
+	}
+	s.buf = append(s.buf, m)
+
+	return write(m)
+}
+
+// snapshot returns a copy of the message that shares nothing later hands
+// change, such as the room's cards and bets. An embedded room or occupant
+// must already be a snapshot from View.
+func (message *Message) snapshot() *Message {
+	m := *message
+	m.Rooms = slices.Clone(message.Rooms)
+	if message.Hands != nil {
+		m.Hands = make([]*ShownHand, len(message.Hands))
+		for i, hand := range message.Hands {
+			h := *hand
+			h.Cards = slices.Clone(hand.Cards)
+			m.Hands[i] = &h
+		}
+	}
+	if deal := message.Deal; deal != nil {
+		m.Deal = &DealEvent{Cards: slices.Clone(deal.Cards), Hand: deal.Hand}
+	}
+	if bet := message.BetEvent; bet != nil {
+		b := *bet
+		m.BetEvent = &b
+	}
+	if prompt := message.Prompt; prompt != nil {
+		p := *prompt
+		p.Options = slices.Clone(prompt.Options)
+		m.Prompt = &p
+	}
+	if pots := message.Pots; pots != nil {
+		m.Pots = &PotEvent{Pots: slices.Clone(pots.Pots)}
+	}
+	return &m
+}
+
+// replay writes every recorded message after last. It returns false if
+// some of those messages are no longer buffered, or last is unknown.
+func (s *session) replay(last uint64, write func(m *Message) error) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if last > s.seq {
+		return false
+	}
+	if last == s.seq {
+		return true
+	}
+	if len(s.buf) == 0 || s.buf[0].Seq > last+1 {
+		return false
+	}
+	for _, m := range s.buf {
+		if m.Seq > last {
+			if err := write(m); err != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
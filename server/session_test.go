@@ -0,0 +1,143 @@
+package poker
+
+import (
+	"errors"
+	"testing"
+)
+
+// recorder collects the messages written by a session.
+type recorder []*Message
+
+func (r *recorder) write(m *Message) error {
+	*r = append(*r, m)
+	return nil
+}
+
+func (r recorder) seqs() (seqs []uint64) {
+	for _, m := range r {
+		seqs = append(seqs, m.Seq)
+	}
+	return
+}
+
+func equalSeqs(a []uint64, from, to uint64) bool {
+	if uint64(len(a)) != to-from+1 {
+		return false
+	}
+	for i, seq := range a {
+		if seq != from+uint64(i) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSessionSeq(t *testing.T) {
+	s := newSession()
+	var sent recorder
+	m := &Message{Action: ActBet}
+	for i := 0; i < 3; i++ {
+		if err := s.send(m, sent.write); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !equalSeqs(sent.seqs(), 1, 3) {
+		t.Fatalf("seqs %v", sent.seqs())
+	}
+	if m.Seq != 0 {
+		t.Fatal("send stamped the caller's message")
+	}
+
+	// a failed write is still recorded for replay
+	fail := errors.New("closed")
+	if err := s.send(m, func(*Message) error { return fail }); err != fail {
+		t.Fatalf("send error %v", err)
+	}
+	var replayed recorder
+	if !s.replay(3, replayed.write) || !equalSeqs(replayed.seqs(), 4, 4) {
+		t.Fatalf("replayed %v", replayed.seqs())
+	}
+}
+
+func TestSessionReplay(t *testing.T) {
+	s := newSession()
+	var sent recorder
+	for i := 0; i < 5; i++ {
+		s.send(&Message{Action: ActBet}, sent.write)
+	}
+
+	for last, want := range map[uint64][2]uint64{0: {1, 5}, 2: {3, 5}, 4: {5, 5}} {
+		var replayed recorder
+		if !s.replay(last, replayed.write) || !equalSeqs(replayed.seqs(), want[0], want[1]) {
+			t.Errorf("replay from %d: %v", last, replayed.seqs())
+		}
+	}
+
+	var replayed recorder
+	if !s.replay(5, replayed.write) || len(replayed) != 0 {
+		t.Fatalf("replay from the last seq: %v", replayed.seqs())
+	}
+	if s.replay(6, replayed.write) {
+		t.Fatal("replayed from an unknown seq")
+	}
+}
+
+func TestSessionOverflow(t *testing.T) {
+	s := newSession()
+	var sent recorder
+	for i := 0; i < replayBuffer+10; i++ {
+		s.send(&Message{Action: ActBet}, sent.write)
+	}
+	if len(s.buf) != replayBuffer {
+		t.Fatalf("%d buffered", len(s.buf))
+	}
+
+	var replayed recorder
+	if s.replay(9, replayed.write) {
+		t.Fatal("replayed past the buffer")
+	}
+	replayed = nil
+	if !s.replay(10, replayed.write) || !equalSeqs(replayed.seqs(), 11, replayBuffer+10) {
+		t.Fatalf("replayed %d from the oldest buffered", len(replayed))
+	}
+}
+
+func TestSessionSnapshot(t *testing.T) {
+	room := newTestRoom(t, 1000, 1000)
+	a, b := room.Occupants[0], room.Occupants[1]
+	room.Cards = cards("SK", "HK", "DK")
+	room.Pot = []int{20}
+	room.Chips[0], room.Chips[1] = 10, 10
+	a.Cards = cards("SA", "HA")
+
+	a.SendMessage(&Message{Action: ActState, Room: room})
+	a.SendMessage(&Message{Action: ActFlop, Deal: &DealEvent{Cards: room.Cards[:3]}})
+	a.SendMessage(&Message{Action: ActPot, Pots: &PotEvent{Pots: room.Pot}})
+
+	// the next hand reuses the room's slices
+	room.Cards[0], room.Pot[0], room.Chips[0] = ParseCard("S2"), 0, 0
+	a.Cards[0] = ParseCard("C2")
+	b.Chips = 0
+
+	var replayed recorder
+	if !a.session.replay(0, replayed.write) || len(replayed) != 3 {
+		t.Fatalf("replayed %d", len(replayed))
+	}
+	state, flop, pot := replayed[0], replayed[1], replayed[2]
+	if r := state.Room; r.Cards[0].String() != "SK" || r.Pot[0] != 20 || r.Chips[0] != 10 ||
+		r.Occupants[0].Cards[0].String() != "SA" || r.Occupants[1].Chips != 1000 {
+		t.Fatalf("replayed room %+v", r)
+	}
+	if flop.Deal.Cards[0].String() != "SK" || pot.Pots.Pots[0] != 20 {
+		t.Fatal("replayed payloads changed")
+	}
+}
+
+func TestConnClose(t *testing.T) {
+	c := &Conn{send: make(chan frame, 1), version: ProtoV1, encoding: EncodingJSON}
+	c.Close()
+	c.Close()
+	if err := c.WriteJSON(&Message{}); err != errConnClosed {
+		t.Fatalf("wrote to a closed connection: %v", err)
+	}
+}
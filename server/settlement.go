@@ -0,0 +1,87 @@
+package poker
+
+import (
+	"log"
+	"strings"
+)
+
+// Settlement is the result of a game, or of one player cashing out of it,
+// to be paid out on chain. Chips are the amounts paid to Players out of the
+// game's pool: their stacks at a cash game, where a deposit buys as many
+// chips as it holds, and their prizes at a tournament.
+type Settlement struct {
+	GameID  string   `json:"game_id"`
+	Players []string `json:"players"`
+	Chips   []int    `json:"chips"`
+	Proof   string   `json:"proof"` // transcript digest, see Transcript
+	CashOut bool     `json:"cash_out,omitempty"`
+
+	// Deposits are the ids of the deposits the settlement consumes on chain,
+	// set by Outbox.Enqueue.
+	Deposits []string `json:"deposits,omitempty"`
+}
+
+// Key identifies the settlement in the outbox and on chain, where it keeps
+// the settlement from being paid twice. The end of a game, which ends once,
+// is keyed by the game. A cash-out is keyed by the game, the player and the
+// deposits it pays out, which no later cash-out plays again, or the
+// transcript for a stack bought in without deposits.
+func (s *Settlement) Key() string {
+	if !s.CashOut {
+		return s.GameID
+	}
+	key := s.GameID + ":" + strings.Join(s.Players, ",") + ":"
+	if len(s.Deposits) == 0 {
+		return key + s.Proof
+	}
+	return key + strings.Join(s.Deposits, ",")
+}
+
+func (room *Room) settlement(players []*Occupant, cashOut bool) *Settlement {
+	s := &Settlement{
+		GameID:  room.Id,
+		Proof:   room.transcript.Digest(),
+		CashOut: cashOut,
+	}
+	if room.game != nil {
+		s.GameID = room.game.GameID
+	}
+	for _, o := range players {
+		s.Players = append(s.Players, o.Id)
+		s.Chips = append(s.Chips, o.Chips)
+	}
+	return s
+}
+
+// CashOut settles the occupant's stack on chain and removes it from the
+// room, without ending the game for the others.
+func (o *Occupant) CashOut() {
+	room := o.Room
+	if room == nil {
+		return
+	}
+	if room.Occupant(o.Id) == nil {
+		o.Leave()
+		return
+	}
+	if room.Tournament != nil {
+		o.leaveTournament(room)
+		return
+	}
+
+	s := room.settlement([]*Occupant{o}, true)
+	room.ledger.Transfer(room.hand, EntryCashOut, PlayerAccount(o.Id), AccountCashier, o.Chips)
+	o.Leave()
+	if err := room.enqueue(s); err != nil {
+		log.Println("cash out", s.GameID, o.Id, err)
+	}
+}
+
+// enqueue queues s for payment, unless the room was halted.
+func (room *Room) enqueue(s *Settlement) error {
+	if room.halted.Load() {
+		return errHalted
+	}
+	_, err := outbox.Enqueue(s)
+	return err
+}
@@ -0,0 +1,378 @@
+package poker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/block-vision/sui-go-sdk/models"
+	"github.com/block-vision/sui-go-sdk/signer"
+	"github.com/block-vision/sui-go-sdk/sui"
+)
+
+const (
+	SettlementPending    = "pending"
+	SettlementSubmitting = "submitting" // handed to the settler, maybe sent
+	SettlementSubmitted  = "submitted"
+	SettlementConfirmed  = "confirmed"
+	SettlementFailed     = "failed"
+)
+
+var (
+	errUnknownTx = errors.New("unknown settlement transaction")
+	errGameOver  = errors.New("game already over")
+
+	// ErrSettled is returned by Settler.Submit for a settlement whose key
+	// was already paid out.
+	ErrSettled = errors.New("settlement already paid")
+)
+
+// Settler pays out game results.
+type Settler interface {
+	// Submit settles s and returns the id of the resulting transaction.
+	// Submitting a settlement again must not pay it twice: Submit returns
+	// ErrSettled once s.Key() has been paid.
+	Submit(ctx context.Context, s *Settlement) (string, error)
+	// Status returns the settlement status of a transaction returned by Submit.
+	Status(ctx context.Context, txID string) (string, error)
+	// Deposit looks up a buy-in escrowed on chain.
+	Deposit(ctx context.Context, id string) (*Deposit, error)
+}
+
+var (
+	settler Settler
+)
+
+// SetSettler replaces the settler used to verify buy-ins.
+func SetSettler(s Settler) {
+	settler = s
+}
+
+// SuiSettler settles through the mental_poker Move module.
+type SuiSettler struct {
+	cfg    *ChainConfig
+	signer *signer.Signer
+}
+
+func NewSuiSettler(cfg *ChainConfig) (*SuiSettler, error) {
+	signerAccount, err := cfg.Signer()
+	if err != nil {
+		return nil, err
+	}
+	return &SuiSettler{
+		cfg:    cfg,
+		signer: signerAccount,
+	}, nil
+}
+
+// moveCall returns the call settling s: end_game, or cash_out for a single
+// player leaving. Both take (game_id, game_data, players, chips, proof, key,
+// deposits), see move/sources/mental_poker.move.
+func (st *SuiSettler) moveCall(s *Settlement) models.MoveCallRequest {
+	function := "end_game"
+	if s.CashOut {
+		function = "cash_out"
+	}
+	chips := make([]string, 0, len(s.Chips))
+	for _, c := range s.Chips {
+		chips = append(chips, strconv.Itoa(c)) // u64 arguments are JSON strings
+	}
+	deposits := append([]string{}, s.Deposits...)
+
+	return models.MoveCallRequest{
+		Signer:          st.signer.Address,
+		PackageObjectId: st.cfg.PackageID,
+		Module:          "mental_poker",
+		Function:        function,
+		TypeArguments:   []interface{}{},
+		Arguments: []interface{}{
+			s.GameID,
+			st.cfg.GameDataID,
+			s.Players,
+			chips,
+			s.Proof,
+			s.Key(),
+			deposits,
+		},
+		//Gas:       &gasObj,
+		GasBudget: st.cfg.GasBudget,
+	}
+}
+
+// CheckPackage makes sure the configured package has the end_game and
+// cash_out moveCall sends. A package published before cash_out, whose
+// end_game took two players as separate arguments, fails every settlement.
+func (st *SuiSettler) CheckPackage(ctx context.Context) error {
+	cli := sui.NewSuiClient(st.cfg.Endpoint)
+	for _, function := range []string{"end_game", "cash_out"} {
+		fn, err := cli.SuiGetNormalizedMoveFunction(ctx, models.GetNormalizedMoveFunctionRequest{
+			Package:      st.cfg.PackageID,
+			ModuleName:   "mental_poker",
+			FunctionName: function,
+		})
+		if err != nil {
+			return fmt.Errorf("package %s: mental_poker::%s: %w", st.cfg.PackageID, function, err)
+		}
+		if !settleParams(fn.Parameters) {
+			return fmt.Errorf("package %s: mental_poker::%s is out of date, publish move/ and set package_id and game_data_id", st.cfg.PackageID, function)
+		}
+	}
+	return nil
+}
+
+// settleParams reports whether the normalized Move parameters are those of
+// a settlement, (game_id, game_data, players, chips, proof, key, deposits,
+// ctx), telling players and deposits by their vector type.
+func settleParams(params []interface{}) bool {
+	vector := func(p interface{}) bool {
+		m, _ := p.(map[string]interface{})
+		_, ok := m["Vector"]
+		return ok
+	}
+	return len(params) == 8 && vector(params[2]) && vector(params[3]) && vector(params[6])
+}
+
+// Submit settles s with moveCall.
+func (st *SuiSettler) Submit(ctx context.Context, s *Settlement) (string, error) {
+	cli := sui.NewSuiClient(st.cfg.Endpoint)
+	rsp, err := cli.MoveCall(ctx, st.moveCall(s))
+	if err != nil {
+		return "", err
+	}
+	// see the successful transaction url: https://explorer.sui.io/txblock/CD5hFB4bWFThhb6FtvKq3xAxRri72vsYLJAVd7p9t2sR?network=testnet
+	rsp2, err := cli.SignAndExecuteTransactionBlock(ctx, models.SignAndExecuteTransactionBlockRequest{
+		TxnMetaData: rsp,
+		PriKey:      st.signer.PriKey,
+		// only fetch the effects field
+		Options: models.SuiTransactionBlockOptions{
+			ShowInput:    true,
+			ShowRawInput: true,
+			ShowEffects:  true,
+		},
+		RequestType: "WaitForLocalExecution",
+	})
+	if err != nil {
+		return "", err
+	}
+	if status := rsp2.Effects.Status; status.Status == "failure" && moveAbortCode(status.Error) == moveSettled {
+		return "", ErrSettled
+	}
+	return rsp2.Digest, nil
+}
+
+// moveSettled is the mental_poker abort code for a settlement key already
+// paid out.
+const moveSettled = 8
+
+var moveAbort = regexp.MustCompile(`MoveAbort\(.*Identifier\("mental_poker"\).*, (\d+)\) in command`)
+
+// moveAbortCode returns the abort code in a failed transaction's error, or
+// -1 if it did not abort in the mental_poker module.
+func moveAbortCode(err string) int {
+	m := moveAbort.FindStringSubmatch(err)
+	if m == nil {
+		return -1
+	}
+	code, _ := strconv.Atoi(m[1])
+	return code
+}
+
+func (st *SuiSettler) Status(ctx context.Context, txID string) (string, error) {
+	cli := sui.NewSuiClient(st.cfg.Endpoint)
+	rsp, err := cli.SuiGetTransactionBlock(ctx, models.SuiGetTransactionBlockRequest{
+		Digest: txID,
+		Options: models.SuiTransactionBlockOptions{
+			ShowEffects: true,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	switch rsp.Effects.Status.Status {
+	case "success":
+		return SettlementConfirmed, nil
+	case "failure":
+		return SettlementFailed, nil
+	default:
+		return SettlementSubmitted, nil
+	}
+}
+
+// Deposit reads a mental_poker::Deposit object.
+func (st *SuiSettler) Deposit(ctx context.Context, id string) (*Deposit, error) {
+	cli := sui.NewSuiClient(st.cfg.Endpoint)
+	rsp, err := cli.SuiGetObject(ctx, models.SuiGetObjectRequest{
+		ObjectId: id,
+		Options: models.SuiObjectDataOptions{
+			ShowType:    true,
+			ShowContent: true,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rsp.Error != nil && rsp.Error.Code == "deleted" {
+		return nil, errDepositUsed // consumed by a settlement
+	}
+	if rsp.Error != nil || rsp.Data == nil || rsp.Data.Content == nil {
+		return nil, errUnknownDeposit
+	}
+	if rsp.Data.Type != st.cfg.PackageID+"::mental_poker::Deposit" {
+		return nil, errUnknownDeposit
+	}
+
+	fields := rsp.Data.Content.Fields
+	d := &Deposit{Id: id}
+	d.GameID, _ = fields["game_id"].(string)
+	d.Player, _ = fields["player"].(string)
+	amount, _ := fields["amount"].(string) // u64 fields are JSON strings
+	if d.Chips, err = strconv.Atoi(amount); err != nil {
+		return nil, errUnknownDeposit
+	}
+	return d, nil
+}
+
+// LocalSettler records settlements instead of sending them to a chain. If
+// it has a file, settlements are appended to it as JSON lines and reloaded
+// on start. Like the chain, it spends the deposits a settlement consumes,
+// and settles nothing more of a game once it has ended.
+type LocalSettler struct {
+	lock        sync.Mutex
+	path        string
+	settlements []*Settlement
+	settled     map[string]bool // by Settlement.Key
+	over        map[string]bool // games ended, by GameID
+	deposits    map[string]*Deposit
+	spent       map[string]bool // deposits consumed by settlements
+}
+
+// NewLocalSettler returns a settler backed by the file at path, or kept
+// in memory only if path is empty.
+func NewLocalSettler(path string) (*LocalSettler, error) {
+	st := &LocalSettler{
+		path:     path,
+		settled:  make(map[string]bool),
+		over:     make(map[string]bool),
+		deposits: make(map[string]*Deposit),
+		spent:    make(map[string]bool),
+	}
+	if path == "" {
+		return st, nil
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		s := &Settlement{}
+		if err := json.Unmarshal(scanner.Bytes(), s); err != nil {
+			return nil, err
+		}
+		st.add(s)
+	}
+	return st, scanner.Err()
+}
+
+func (st *LocalSettler) Submit(ctx context.Context, s *Settlement) (string, error) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	if st.settled[s.Key()] {
+		return "", ErrSettled
+	}
+	for _, d := range s.Deposits {
+		if st.spent[d] {
+			return "", errDepositUsed
+		}
+	}
+	if st.over[s.GameID] {
+		return "", errGameOver
+	}
+	if st.path != "" {
+		b, err := json.Marshal(s)
+		if err != nil {
+			return "", err
+		}
+		f, err := os.OpenFile(st.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		if _, err := f.Write(append(b, '\n')); err != nil {
+			return "", err
+		}
+	}
+
+	st.add(s)
+	return fmt.Sprintf("local-%d", len(st.settlements)), nil
+}
+
+// add records the settlement s as paid. Called with the lock held.
+func (st *LocalSettler) add(s *Settlement) {
+	st.settlements = append(st.settlements, s)
+	st.settled[s.Key()] = true
+	if !s.CashOut {
+		st.over[s.GameID] = true
+	}
+	for _, d := range s.Deposits {
+		st.spent[d] = true
+	}
+}
+
+func (st *LocalSettler) Status(ctx context.Context, txID string) (string, error) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	var n int
+	if _, err := fmt.Sscanf(txID, "local-%d", &n); err != nil || n < 1 || n > len(st.settlements) {
+		return "", errUnknownTx
+	}
+	return SettlementConfirmed, nil
+}
+
+func (st *LocalSettler) Deposit(ctx context.Context, id string) (*Deposit, error) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	d, ok := st.deposits[id]
+	if !ok {
+		return nil, errUnknownDeposit
+	}
+	if st.spent[id] {
+		return nil, errDepositUsed
+	}
+	c := *d
+	return &c, nil
+}
+
+// AddDeposit makes a buy-in known to the settler, standing in for a
+// deposit made on chain.
+func (st *LocalSettler) AddDeposit(d *Deposit) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	st.deposits[d.Id] = d
+}
+
+// Settlements returns every settlement recorded so far.
+func (st *LocalSettler) Settlements() []*Settlement {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	return append([]*Settlement(nil), st.settlements...)
+}
@@ -0,0 +1,232 @@
+package poker
+
+import (
+	"context"
+	"mental-poker/mental_poker"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// newTestOccupant returns an occupant signed in as id.
+func newTestOccupant(id string, chips int) *Occupant {
+	o := &Occupant{
+		Id:      id,
+		Chips:   chips,
+		address: id,
+		Actions: make(chan *Message),
+		conn: &Conn{
+			send:     make(chan frame, 128),
+			version:  ProtoV1,
+			encoding: EncodingJSON,
+		},
+		session: newSession(),
+		chat:    &chatState{},
+		offline: &atomic.Bool{},
+	}
+	return o
+}
+
+// stopRoom stops the room's start loop once the test is over, so it does
+// not outlive the test or deal it a hand.
+func stopRoom(t *testing.T, room *Room) {
+	t.Cleanup(room.exit)
+}
+
+func newTestRoom(t *testing.T, chips ...int) *Room {
+	room := NewRoom(t.Name(), len(chips), 5, 10)
+	stopRoom(t, room)
+	room.game = mental_poker.NewGame(room.Id, nil, "")
+	for i, c := range chips {
+		o := newTestOccupant(string(rune('a'+i)), c)
+		o.SetPlayer(mental_poker.NewPlayer(room.game))
+		room.AddOccupant(o)
+	}
+	return room
+}
+
+func TestCheckAndEndGame(t *testing.T) {
+	local, err := NewLocalSettler(filepath.Join(t.TempDir(), "settlements.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ob, _ := NewOutbox(local, "")
+	SetOutbox(ob)
+
+	room := newTestRoom(t, 300, 0, 0)
+	room.checkAndEndGame()
+	ob.Process(context.Background())
+
+	settlements := local.Settlements()
+	if len(settlements) != 1 {
+		t.Fatalf("got %d settlements, want 1", len(settlements))
+	}
+	s := settlements[0]
+	if s.CashOut || len(s.Players) != 3 || s.Chips[0] != 300 || s.Proof == "" {
+		t.Fatalf("unexpected settlement %+v", s)
+	}
+
+	// reloaded from file
+	reloaded, err := NewLocalSettler(local.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.Settlements()) != 1 {
+		t.Fatal("settlement not persisted")
+	}
+	if status, err := reloaded.Status(context.Background(), "local-1"); err != nil || status != SettlementConfirmed {
+		t.Fatalf("status %q, %v", status, err)
+	}
+}
+
+func TestCheckAndEndGameInProgress(t *testing.T) {
+	local, _ := NewLocalSettler("")
+	ob, _ := NewOutbox(local, "")
+	SetOutbox(ob)
+
+	room := newTestRoom(t, 300, 200, 0)
+	room.checkAndEndGame()
+	ob.Process(context.Background())
+
+	if len(local.Settlements()) != 0 {
+		t.Fatal("settled a game still in progress")
+	}
+}
+
+// moveParams returns the parameter types of the named functions in the Move
+// module, without the trailing TxContext.
+func moveParams(t *testing.T, functions ...string) map[string][]string {
+	t.Helper()
+	b, err := os.ReadFile("../move/sources/mental_poker.move")
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := make(map[string][]string)
+	for _, m := range regexp.MustCompile(`(?s)public fun (\w+)\(([^)]*)\)`).FindAllStringSubmatch(string(b), -1) {
+		for _, param := range strings.Split(m[2], ",") {
+			_, typ, ok := strings.Cut(param, ":")
+			if typ = strings.TrimSpace(typ); ok && !strings.HasSuffix(typ, "TxContext") {
+				params[m[1]] = append(params[m[1]], typ)
+			}
+		}
+	}
+	for _, f := range functions {
+		if params[f] == nil {
+			t.Fatalf("no %s in the Move module", f)
+		}
+	}
+	return params
+}
+
+func TestSettlementMoveCall(t *testing.T) {
+	local, _ := NewLocalSettler("")
+	ob, _ := NewOutbox(local, "")
+	SetOutbox(ob)
+
+	room := newTestRoom(t, 300, 200, 0)
+	for _, o := range room.Occupants[:2] {
+		ob.claim("0xd"+o.Id, room.Id, o.Id)
+		ob.play("0xd" + o.Id)
+	}
+	room.Occupants[1].CashOut()
+	room.checkAndEndGame()
+	ob.Process(context.Background())
+
+	settlements := local.Settlements()
+	if len(settlements) != 2 || settlements[0].CashOut == settlements[1].CashOut {
+		t.Fatalf("want a cash-out and an end of game, got %d settlements", len(settlements))
+	}
+	for _, s := range settlements {
+		want := "0xda" // the end of game takes what the cash-out left
+		if s.CashOut {
+			want = "0xdb"
+		}
+		if len(s.Deposits) != 1 || s.Deposits[0] != want {
+			t.Fatalf("settlement %+v consumes %v, want %s", s, s.Deposits, want)
+		}
+	}
+
+	cfg := &ChainConfig{PackageID: "0x1", GameDataID: "0x2", GasBudget: "100"}
+	st := &SuiSettler{cfg: cfg, signer: newTestSigner(1)}
+	params := moveParams(t, "end_game", "cash_out")
+	for _, s := range settlements {
+		call := st.moveCall(s)
+		want := params[call.Function]
+		if call.Module != "mental_poker" || call.PackageObjectId != cfg.PackageID || len(call.Arguments) != len(want) {
+			t.Fatalf("%s call %+v, Move parameters %v", call.Function, call, want)
+		}
+
+		var players, chips []string
+		strs := []string{s.Proof, s.Key()} // the String parameters in order
+		for i, typ := range want {
+			arg := call.Arguments[i]
+			var ok bool
+			switch typ {
+			case "ID":
+				ok = arg == s.GameID && s.GameID != ""
+			case "&mut GameData":
+				ok = arg == cfg.GameDataID
+			case "vector<address>":
+				players, ok = arg.([]string)
+			case "vector<u64>":
+				chips, ok = arg.([]string)
+				for _, c := range chips {
+					if _, err := strconv.ParseUint(c, 10, 64); err != nil {
+						ok = false
+					}
+				}
+			case "String":
+				if ok = len(strs) > 0 && arg == strs[0]; ok {
+					strs = strs[1:]
+				}
+			case "vector<Deposit>":
+				var deposits []string
+				deposits, ok = arg.([]string)
+				ok = ok && deposits != nil && slices.Equal(deposits, s.Deposits)
+			default:
+				t.Fatalf("%s: unexpected Move parameter %s", call.Function, typ)
+			}
+			if !ok {
+				t.Errorf("%s: argument %d %#v is not a %s", call.Function, i, arg, typ)
+			}
+		}
+		if len(strs) > 0 || len(s.Proof) != 64 {
+			t.Errorf("%s: proof or key missing", call.Function)
+		}
+		if len(players) != len(chips) || (s.CashOut && len(players) != 1) {
+			t.Errorf("%s: %d players, %d chips", call.Function, len(players), len(chips))
+		}
+	}
+	for _, s := range settlements {
+		if call := st.moveCall(s); s.CashOut && (call.Function != "cash_out" || call.Arguments[3].([]string)[0] != "200") {
+			t.Fatalf("cash out %+v", call)
+		}
+	}
+}
+
+func TestSettleParams(t *testing.T) {
+	vector := func(of interface{}) interface{} { return map[string]interface{}{"Vector": of} }
+	ref := func(to string) interface{} {
+		return map[string]interface{}{"MutableReference": map[string]interface{}{"Struct": to}}
+	}
+	id := map[string]interface{}{"Struct": "ID"}
+	str := map[string]interface{}{"Struct": "String"}
+
+	settle := []interface{}{id, ref("GameData"), vector("Address"), vector("U64"), str, str, vector(map[string]interface{}{"Struct": "Deposit"}), ref("TxContext")}
+	if !settleParams(settle) {
+		t.Fatal("current end_game refused")
+	}
+	// the first published end_game: (game_id, game_data, player1, chips1, player2, chips2, proof, ctx)
+	legacy := []interface{}{id, ref("GameData"), "Address", "U64", "Address", "U64", str, ref("TxContext")}
+	if settleParams(legacy) {
+		t.Fatal("two player end_game accepted")
+	}
+	if settleParams(settle[:7]) {
+		t.Fatal("end_game without deposits accepted")
+	}
+}
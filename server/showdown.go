@@ -0,0 +1,96 @@
+package poker
+
+// ShownHand is one occupant's result at showdown, in the order hands were tabled.
+type ShownHand struct {
+	Pos   int    `json:"index"`
+	Id    string `json:"id"`
+	Cards []Card `json:"cards,omitempty"`
+	Hand  int    `json:"hand,omitempty"`
+	Muck  bool   `json:"muck,omitempty"`
+}
+
+// showOrder returns the occupants still holding cards in showdown order:
+// the last aggressor of the final betting round first, otherwise the first
+// occupant left of the button, then clockwise.
+func (room *Room) showOrder() (order []*Occupant) {
+	start := room.Button % room.Cap()
+	if room.aggressor > 0 {
+		if o := room.Occupants[room.aggressor-1]; o != nil && len(o.Cards) > 0 {
+			start = room.aggressor - 1
+		}
+	}
+
+	room.Each(start, func(o *Occupant) bool {
+		if len(o.Cards) > 0 {
+			order = append(order, o)
+		}
+		return true
+	})
+	return
+}
+
+// showHands tables the remaining hands in showdown order. An occupant that
+// could still win or tie one of its pots must show; a beaten hand is mucked
+// when the occupant chose to muck it, or has auto-muck turned on and did not
+// choose to show it.
+func (room *Room) showHands(pots []handPot) (hands []*ShownHand) {
+	best := make([]int, len(pots)) // best shown hand per pot
+
+	for _, o := range room.showOrder() {
+		mustShow := false
+		for i, pot := range pots {
+			for _, pos := range pot.OPos {
+				if pos == o.Pos && o.Hand >= best[i] {
+					mustShow = true
+				}
+			}
+		}
+
+		hand := &ShownHand{
+			Pos: o.Pos,
+			Id:  o.Id,
+		}
+		if !mustShow && room.mucks(o) {
+			hand.Muck = true
+			hands = append(hands, hand)
+			continue
+		}
+
+		hand.Cards = o.Cards
+		hand.Hand = o.Hand
+		hands = append(hands, hand)
+		for i, pot := range pots {
+			for _, pos := range pot.OPos {
+				if pos == o.Pos && o.Hand > best[i] {
+					best[i] = o.Hand
+				}
+			}
+		}
+	}
+	return
+}
+
+// mucks reports whether o mucks a beaten hand at showdown.
+func (room *Room) mucks(o *Occupant) bool {
+	room.lock.Lock()
+	defer room.lock.Unlock()
+
+	if o.muck != nil {
+		return *o.muck
+	}
+	return o.AutoMuck
+}
+
+// MuckHand chooses whether o mucks or shows its hand at the showdown of the
+// current hand, if it is beaten.
+func (o *Occupant) MuckHand(muck bool) {
+	room := o.Room
+	if room == nil || room.Occupant(o.Id) != o {
+		return
+	}
+
+	room.lock.Lock()
+	defer room.lock.Unlock()
+
+	o.muck = &muck
+}
@@ -0,0 +1,123 @@
+package poker
+
+import (
+	"testing"
+)
+
+func TestShowOrder(t *testing.T) {
+	room := newTestRoom(t, 100, 100, 100, 100)
+	for _, i := range []int{0, 1, 3} {
+		room.Occupants[i].Cards = cards("SA", "HA")
+	}
+	order := func() (pos []int) {
+		for _, o := range room.showOrder() {
+			pos = append(pos, o.Pos)
+		}
+		return
+	}
+	equal := func(a, b []int) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	// no bet on the river: left of the button first
+	room.Button = 1
+	if got := order(); !equal(got, []int{2, 4, 1}) {
+		t.Fatalf("show order %v, want [2 4 1]", got)
+	}
+	// the last aggressor first
+	room.aggressor = 4
+	if got := order(); !equal(got, []int{4, 1, 2}) {
+		t.Fatalf("show order %v, want [4 1 2]", got)
+	}
+	// an aggressor who folded does not count
+	room.aggressor = 3
+	if got := order(); !equal(got, []int{2, 4, 1}) {
+		t.Fatalf("show order %v, want [2 4 1]", got)
+	}
+}
+
+func TestShowHands(t *testing.T) {
+	room := newTestRoom(t, 100, 100, 100)
+	for i, hand := range []int{300, 200, 250} {
+		o := room.Occupants[i]
+		o.Cards = cards("SA", "HA")
+		o.Hand = hand
+		o.AutoMuck = true
+	}
+	// the third player is all in for the main pot only
+	pots := []handPot{{Pot: 300, OPos: []int{1, 2, 3}}, {Pot: 200, OPos: []int{1, 2}}}
+	mucked := func(hands []*ShownHand) (pos []int) {
+		for _, h := range hands {
+			if h.Muck {
+				if h.Cards != nil || h.Hand != 0 {
+					t.Fatalf("mucked hand %d shown", h.Pos)
+				}
+				pos = append(pos, h.Pos)
+			}
+		}
+		return
+	}
+
+	// the winner shows first: everyone else is beaten
+	room.Button = 3
+	if m := mucked(room.showHands(pots)); len(m) != 2 || m[0] != 2 || m[1] != 3 {
+		t.Fatalf("mucked %v, want [2 3]", m)
+	}
+
+	// each beats the hands shown before in its pots, so must show
+	room.Button = 1
+	if m := mucked(room.showHands(pots)); len(m) != 0 {
+		t.Fatalf("mucked %v, want none", m)
+	}
+
+	// a tie must show
+	room.Occupants[1].Hand = 300
+	room.Button = 3
+	if m := mucked(room.showHands(pots)); len(m) != 1 || m[0] != 3 {
+		t.Fatalf("mucked %v, want [3]", m)
+	}
+}
+
+func TestMuckChoice(t *testing.T) {
+	room := newTestRoom(t, 100, 100)
+	a, b := room.Occupants[0], room.Occupants[1]
+	a.Cards, a.Hand = cards("SA", "HA"), 300
+	b.Cards, b.Hand = cards("SK", "HK"), 200
+	pots := []handPot{{Pot: 200, OPos: []int{1, 2}}}
+	room.Button = 2
+
+	if hands := room.showHands(pots); hands[1].Muck {
+		t.Fatal("b mucked without choosing to")
+	}
+
+	// the choice for the hand wins over the preference
+	b.MuckHand(true)
+	if hands := room.showHands(pots); !hands[1].Muck {
+		t.Fatal("b did not muck")
+	}
+	b.AutoMuck = true
+	b.MuckHand(false)
+	if hands := room.showHands(pots); hands[1].Muck || len(hands[1].Cards) != 2 {
+		t.Fatal("b did not show")
+	}
+
+	// a winning hand shows whatever the choice
+	a.MuckHand(true)
+	if hands := room.showHands(pots); hands[0].Muck {
+		t.Fatal("the winner mucked")
+	}
+
+	// the choice lasts one hand
+	b.muck = nil
+	if hands := room.showHands(pots); !hands[1].Muck {
+		t.Fatal("b not auto-mucked")
+	}
+}
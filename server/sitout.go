@@ -0,0 +1,311 @@
+package poker
+
+import (
+	"strconv"
+)
+
+// defaultMaxTimeouts is how many actions in a row a player may let time out
+// before it is sat out.
+const defaultMaxTimeouts = 2
+
+// nextSeat returns the first occupant after the seat at pos, going round
+// the table, for which f is true, or nil.
+func (room *Room) nextSeat(pos int, f func(o *Occupant) bool) *Occupant {
+	for i := 1; i <= room.Cap(); i++ {
+		if o := room.Occupants[(pos+i-1)%room.Cap()]; o != nil && f(o) {
+			return o
+		}
+	}
+	return nil
+}
+
+// prevSeat returns the first occupant before the seat at pos, going back
+// round the table, for which f is true, or nil.
+func (room *Room) prevSeat(pos int, f func(o *Occupant) bool) *Occupant {
+	for i := 1; i <= room.Cap(); i++ {
+		if o := room.Occupants[(pos-i-1+2*room.Cap())%room.Cap()]; o != nil && f(o) {
+			return o
+		}
+	}
+	return nil
+}
+
+// between calls f for each seat strictly after from and before to, going
+// round the table.
+func (room *Room) between(from, to int, f func(pos int)) {
+	if from == 0 || from == to {
+		return
+	}
+	for pos := from%room.Cap() + 1; pos != to; pos = pos%room.Cap() + 1 {
+		f(pos)
+	}
+}
+
+// away reports whether o is dealt out of hands while sitting out. Tournament
+// players are dealt in regardless, and fold.
+func (room *Room) away(o *Occupant) bool {
+	return o.SittingOut && room.Tournament == nil
+}
+
+// playing reports whether o is dealt into the next hand wherever the blinds
+// fall.
+func (room *Room) playing(o *Occupant) bool {
+	return !room.away(o) && !o.WaitBB
+}
+
+// dealIn places the button and blinds of the next hand, among the occupants
+// not sitting out, and returns the blinds. sb is nil for a dead small blind.
+// A player waiting for the big blind is dealt in when the big blind reaches
+// it, and sitting out players the blinds pass owe them. bb is nil if fewer
+// than two players would be dealt in. Called with the lock held.
+//
+// The big blind moves forward one player every hand, the small blind goes
+// to last hand's big blind and the button to last hand's small blind, empty
+// seats or not, so each player posts each blind once an orbit.
+func (room *Room) dealIn() (sb, bb *Occupant) {
+	active, waiting := 0, 0
+	room.Each(0, func(o *Occupant) bool {
+		if room.playing(o) {
+			active++
+		} else if !room.away(o) {
+			waiting++
+		}
+		return true
+	})
+	if active+waiting < 2 {
+		return
+	}
+	if active < 2 {
+		// nobody to wait for
+		room.Each(0, func(o *Occupant) bool {
+			if !room.away(o) {
+				o.WaitBB = false
+				o.clearOwed()
+			}
+			return true
+		})
+		active += waiting
+	}
+
+	var skip *Occupant // may not come in on the small blind
+	sitting := func(o *Occupant) bool { return !room.away(o) }
+	dealt := func(o *Occupant) bool { return (room.playing(o) || o == bb) && o != skip }
+	sbPos := 0
+	if room.bbPos == 0 {
+		// first hand: the button moves to the next player
+		dealer := room.nextSeat(room.Button, room.playing)
+		room.Button = dealer.Pos
+		sb = dealer
+		if active > 2 {
+			sb = room.nextSeat(dealer.Pos, room.playing)
+		}
+		sbPos = sb.Pos
+		bb = room.nextSeat(sb.Pos, sitting)
+	} else {
+		bb = room.nextSeat(room.bbPos, sitting)
+		if o := room.Occupants[room.bbPos-1]; o != nil && o != bb && o.Owed > 0 {
+			skip = o
+		}
+		if room.count(dealt) < 2 {
+			skip = nil
+		}
+		if room.count(dealt) == 2 {
+			// heads up the button posts the small blind
+			sb = room.nextSeat(bb.Pos, dealt)
+			sbPos = sb.Pos
+			room.Button = sb.Pos
+		} else {
+			sbPos = room.bbPos
+			if o := room.Occupants[sbPos-1]; o != nil && dealt(o) {
+				sb = o
+			}
+			room.Button = room.sbPos
+			if room.Button == sbPos || room.Button == bb.Pos {
+				// out of heads up: the button goes back before the small blind
+				room.Button = room.prevSeat(sbPos, func(o *Occupant) bool {
+					return dealt(o) && o != bb
+				}).Pos
+			}
+		}
+	}
+	bb.WaitBB = false
+
+	room.between(room.sbPos, sbPos, func(pos int) {
+		if o := room.Occupants[pos-1]; o != nil && room.away(o) {
+			o.missedSB = true
+			o.Owed = o.owed(room)
+		}
+	})
+	if o := room.Occupants[sbPos-1]; o != nil && room.away(o) {
+		o.missedSB = true
+		o.Owed = o.owed(room)
+	}
+	room.between(room.bbPos, bb.Pos, func(pos int) {
+		if o := room.Occupants[pos-1]; o != nil && room.away(o) {
+			o.missedBB = true
+			o.Owed = o.owed(room)
+		}
+	})
+	room.sbPos, room.bbPos = sbPos, bb.Pos
+
+	// posting a blind settles what was owed
+	if sb != nil {
+		sb.clearOwed()
+	}
+	bb.clearOwed()
+	room.Each(0, func(o *Occupant) bool {
+		o.out = !dealt(o)
+		return true
+	})
+	return
+}
+
+// count returns the number of occupants for which f is true.
+func (room *Room) count(f func(o *Occupant) bool) (n int) {
+	for _, o := range room.Occupants {
+		if o != nil && f(o) {
+			n++
+		}
+	}
+	return
+}
+
+// owed returns the blinds o missed while sitting out.
+func (o *Occupant) owed(room *Room) (n int) {
+	if o.missedSB {
+		n += room.SB
+	}
+	if o.missedBB {
+		n += room.BB
+	}
+	return
+}
+
+func (o *Occupant) clearOwed() {
+	o.missedSB = false
+	o.missedBB = false
+	o.Owed = 0
+}
+
+// postOwed has o post the blinds it missed: the big blind live, counting
+// towards its bet, and the small blind dead, straight into the pot.
+func (room *Room) postOwed(o *Occupant) {
+	room.lock.Lock()
+	defer room.lock.Unlock()
+
+	if !o.missedSB && !o.missedBB {
+		return
+	}
+	live, dead := 0, 0
+	if o.missedBB {
+		live = min(room.BB, o.Chips)
+	}
+	if o.missedSB {
+		dead = min(room.SB, o.Chips-live)
+	}
+	o.clearOwed()
+
+	o.Chips -= dead
+	room.Chips[o.Pos-1] += dead
+	if live > 0 {
+		o.Betting(live)
+	}
+	if o.Chips == 0 {
+		o.Action = ActAllin
+		room.allin++
+	}
+	room.ledger.Transfer(room.hand, EntryBlind, PlayerAccount(o.Id), AccountPot, live+dead)
+	if h := room.history; h != nil {
+		action := HistoryBigBlind
+		if live == 0 {
+			action = HistorySmallBlind
+		}
+		h.Actions = append(h.Actions, &HistoryAction{
+			Street: room.street(),
+			Pos:    o.Pos,
+			Id:     o.Id,
+			Action: action,
+			Amount: live + dead,
+			Bet:    o.Bet,
+			AllIn:  o.Action == ActAllin,
+		})
+	}
+
+	room.Broadcast(&Message{
+		Id:     room.Id,
+		Type:   MsgPresence,
+		From:   o.Id,
+		Action: ActBet,
+		Class:  o.Action + "," + strconv.Itoa(o.Bet) + "," + strconv.Itoa(o.Chips),
+		BetEvent: &BetEvent{
+			Pos:    o.Pos,
+			Action: o.Action,
+			Bet:    o.Bet,
+			Chips:  o.Chips,
+		},
+	})
+}
+
+// SitOut keeps o seated but deals it out of the hands that follow. A hand
+// it is playing is folded when its turn comes.
+func (o *Occupant) SitOut() {
+	room := o.Room
+	if room == nil || room.Occupant(o.Id) != o {
+		return
+	}
+
+	room.lock.Lock()
+	o.SittingOut = true
+	o.WaitBB = false
+	room.lock.Unlock()
+
+	room.Broadcast(&Message{
+		From:     room.Id,
+		Type:     MsgPresence,
+		Action:   ActSitOut,
+		Occupant: o,
+	})
+}
+
+// SitIn deals o back in from the next hand, posting the blinds it missed,
+// or if wait is true once the big blind reaches it, owing nothing.
+func (o *Occupant) SitIn(wait bool) {
+	room := o.Room
+	if room == nil || room.Occupant(o.Id) != o {
+		return
+	}
+
+	room.lock.Lock()
+	o.SittingOut = false
+	o.WaitBB = wait && o.Owed > 0
+	o.timeouts = 0
+	room.lock.Unlock()
+
+	room.Broadcast(&Message{
+		From:     room.Id,
+		Type:     MsgPresence,
+		Action:   ActSitIn,
+		Occupant: o,
+	})
+}
+
+// sittingOut reports whether o has sat out.
+func (room *Room) sittingOut(o *Occupant) bool {
+	room.lock.Lock()
+	defer room.lock.Unlock()
+
+	return o.SittingOut
+}
+
+// timedOut counts an action o let time out, and sits it out after
+// MaxTimeouts in a row.
+func (room *Room) timedOut(o *Occupant) {
+	room.lock.Lock()
+	o.timeouts++
+	sitOut := room.MaxTimeouts > 0 && o.timeouts >= room.MaxTimeouts && !o.SittingOut
+	room.lock.Unlock()
+
+	if sitOut {
+		o.SitOut()
+	}
+}
@@ -0,0 +1,157 @@
+package poker
+
+import (
+	"testing"
+)
+
+func TestMissedBlinds(t *testing.T) {
+	room := newTestRoom(t, 1000, 1000, 1000, 1000)
+	a, d := room.Occupants[0], room.Occupants[3]
+	d.SitOut()
+
+	if sb, bb := room.dealIn(); room.Button != a.Pos || sb.Pos != 2 || bb.Pos != 3 || !d.out || d.Owed != 0 {
+		t.Fatalf("first hand: button %d, blinds %d/%d", room.Button, sb.Pos, bb.Pos)
+	}
+	// the big blind passes d
+	if sb, bb := room.dealIn(); sb.Pos != 3 || bb != a || d.Owed != room.BB {
+		t.Fatalf("second hand: blinds %d/%d, d owes %d", sb.Pos, bb.Pos, d.Owed)
+	}
+	// and then the small blind
+	if sb, _ := room.dealIn(); sb != a || d.Owed != room.SB+room.BB {
+		t.Fatalf("third hand: small blind %d, d owes %d", sb.Pos, d.Owed)
+	}
+
+	d.SitIn(false)
+	if room.dealIn(); d.out || d.Owed != room.SB+room.BB {
+		t.Fatal("d not dealt in to post")
+	}
+	room.Bet = room.BB
+	room.postOwed(d)
+	if d.Chips != 1000-room.SB-room.BB || d.Bet != room.BB || room.Chips[3] != room.SB+room.BB || d.Owed != 0 {
+		t.Fatalf("d posted %d, bet %d", room.Chips[3], d.Bet)
+	}
+	if b := room.ledger.Balance(PlayerAccount(d.Id)); b != -room.SB-room.BB {
+		t.Fatalf("ledger has d at %d", b)
+	}
+}
+
+func TestWaitForBigBlind(t *testing.T) {
+	room := newTestRoom(t, 1000, 1000, 1000, 1000)
+	a, d := room.Occupants[0], room.Occupants[3]
+	d.WaitBB, d.missedBB, d.Owed = true, true, room.BB
+
+	if _, bb := room.dealIn(); bb.Pos != 3 || !d.out {
+		t.Fatal("d dealt in before the big blind")
+	}
+	if _, bb := room.dealIn(); bb != d || d.out || d.WaitBB || d.Owed != 0 {
+		t.Fatal("d not dealt in on the big blind")
+	}
+	if a.out {
+		t.Fatal("a dealt out")
+	}
+
+	// nobody to wait for
+	room = newTestRoom(t, 1000, 1000)
+	b := room.Occupants[1]
+	b.WaitBB, b.missedBB, b.Owed = true, true, room.BB
+	if sb, bb := room.dealIn(); sb == nil || sb.Pos != 1 || bb != b || b.out || b.Owed != 0 {
+		t.Fatal("heads up player kept waiting")
+	}
+
+	room.Occupants[0].SitOut()
+	if _, bb := room.dealIn(); bb != nil {
+		t.Fatal("hand dealt with one player")
+	}
+}
+
+func TestTimeoutSitOut(t *testing.T) {
+	room := newTestRoom(t, 1000, 1000)
+	a := room.Occupants[0]
+
+	room.timedOut(a)
+	if a.SittingOut {
+		t.Fatal("sat out after one timeout")
+	}
+	room.timedOut(a)
+	if !a.SittingOut {
+		t.Fatalf("not sat out after %d timeouts", room.MaxTimeouts)
+	}
+	messages := received(t, room.Occupants[1])
+	if len(messages) != 1 || messages[0].Action != ActSitOut || !messages[0].Occupant.SittingOut {
+		t.Fatal("table not told a sat out")
+	}
+
+	a.SitIn(false)
+	if a.SittingOut || a.WaitBB || a.timeouts != 0 {
+		t.Fatal("a not sat back in")
+	}
+}
+
+func TestDeadButton(t *testing.T) {
+	room := newTestRoom(t, 1000, 1000, 1000, 1000, 1000)
+	a, c := room.Occupants[0], room.Occupants[2]
+
+	hand := func(button, sbPos, bbPos int) {
+		t.Helper()
+		sb, bb := room.dealIn()
+		if sb == nil && sbPos != 0 || sb != nil && sb.Pos != sbPos || bb.Pos != bbPos || room.Button != button {
+			t.Fatalf("hand: button %d, blinds %v/%d, want %d, %d/%d", room.Button, sb, bb.Pos, button, sbPos, bbPos)
+		}
+	}
+	hand(1, 2, 3)
+	hand(2, 3, 4)
+	room.DelOccupant(c)
+	// the button stays on c's empty seat
+	hand(3, 4, 5)
+	hand(4, 5, 1)
+	room.DelOccupant(a)
+	// nobody posts the small blind on a's empty seat
+	hand(5, 0, 2)
+	hand(1, 2, 4)
+}
+
+func TestHeadsUpTransition(t *testing.T) {
+	room := newTestRoom(t, 1000, 1000, 1000)
+	a, b, c := room.Occupants[0], room.Occupants[1], room.Occupants[2]
+
+	if sb, bb := room.dealIn(); room.Button != 1 || sb != b || bb != c {
+		t.Fatal("first hand")
+	}
+	room.DelOccupant(b)
+	// heads up the button posts the small blind, and c does not post the
+	// big blind twice
+	if sb, bb := room.dealIn(); room.Button != 3 || sb != c || bb != a {
+		t.Fatalf("heads up: button %d, blinds %d/%d", room.Button, sb.Pos, bb.Pos)
+	}
+	if sb, bb := room.dealIn(); room.Button != 1 || sb != a || bb != c {
+		t.Fatalf("heads up: button %d, blinds %d/%d", room.Button, sb.Pos, bb.Pos)
+	}
+
+	// d joins and posts; the button moves back off a's big blind
+	d := newTestOccupant("d", 1000)
+	room.AddOccupantAt(d, 2)
+	d.missedBB, d.Owed = true, room.BB
+	if sb, bb := room.dealIn(); room.Button != 2 || sb != c || bb != a || d.out || d.Owed != room.BB {
+		t.Fatalf("three handed: button %d, blinds %d/%d", room.Button, sb.Pos, bb.Pos)
+	}
+
+	// a new player on the small blind waits a hand, leaving c and d heads up
+	e := newTestOccupant("e", 1000)
+	room.DelOccupant(a)
+	room.AddOccupantAt(e, 1)
+	e.missedBB, e.Owed = true, room.BB
+	d.clearOwed()
+	if sb, bb := room.dealIn(); sb != c || bb != d || room.Button != 3 || !e.out {
+		t.Fatal("e dealt in on the small blind")
+	}
+}
+
+func TestStartWithoutGame(t *testing.T) {
+	room := newTestRoom(t, 1000, 1000, 1000)
+	room.game = nil // the mental poker service is down in tests
+
+	room.start()
+	if room.hand != 0 || room.Button != 0 || room.bbPos != 0 {
+		t.Fatalf("hand %d dealt, button %d, big blind %d", room.hand, room.Button, room.bbPos)
+	}
+}
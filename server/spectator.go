@@ -0,0 +1,91 @@
+package poker
+
+import (
+	"errors"
+)
+
+const defaultMaxSpectators = 50
+
+var (
+	errSpectatorsFull = errors.New("too many spectators")
+	errSeated         = errors.New("already seated in the room")
+)
+
+// AddSpectator lets o watch the room without taking a seat.
+func (room *Room) AddSpectator(o *Occupant) error {
+	room.watchLock.Lock()
+	defer room.watchLock.Unlock()
+
+	if _, ok := room.spectators[o.Id]; ok {
+		room.spectators[o.Id] = o
+		return nil
+	}
+	if len(room.spectators) >= room.MaxSpectators {
+		return errSpectatorsFull
+	}
+	room.spectators[o.Id] = o
+	room.Spectators = len(room.spectators)
+	return nil
+}
+
+func (room *Room) DelSpectator(o *Occupant) {
+	room.watchLock.Lock()
+	defer room.watchLock.Unlock()
+
+	if room.spectators[o.Id] == o {
+		delete(room.spectators, o.Id)
+		room.Spectators = len(room.spectators)
+	}
+}
+
+// spectatorCount returns Spectators, which changes under the watch lock.
+func (room *Room) spectatorCount() int {
+	room.watchLock.Lock()
+	defer room.watchLock.Unlock()
+
+	return room.Spectators
+}
+
+// spectatorList returns the room's spectators.
+func (room *Room) spectatorList() []*Occupant {
+	room.watchLock.Lock()
+	defer room.watchLock.Unlock()
+
+	spectators := make([]*Occupant, 0, len(room.spectators))
+	for _, o := range room.spectators {
+		spectators = append(spectators, o)
+	}
+	return spectators
+}
+
+// Watch makes o a spectator of room, no longer watching any other room, and
+// sends it the room state. Spectators get every message broadcast to the
+// room, seeing only the cards shown at showdown.
+func (o *Occupant) Watch(room *Room) error {
+	if room.Occupant(o.Id) != nil {
+		return errSeated
+	}
+	if o.watching != nil && o.watching != room {
+		o.Unwatch()
+	}
+	if err := room.AddSpectator(o); err != nil {
+		return err
+	}
+	o.watching = room
+
+	o.SendMessage(&Message{
+		From:   room.Id,
+		Type:   MsgPresence,
+		Action: ActState,
+		Room:   room,
+	})
+	return nil
+}
+
+// Unwatch stops o watching a room.
+func (o *Occupant) Unwatch() {
+	if room := o.watching; room != nil {
+		room.DelSpectator(o)
+		o.watching = nil
+	}
+}
@@ -0,0 +1,98 @@
+package poker
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// received decodes the messages sent to a test occupant.
+func received(t *testing.T, o *Occupant) (messages []*Message) {
+	for {
+		select {
+		case f := <-o.conn.send:
+			m := &Message{}
+			if err := json.Unmarshal(f.payload, m); err != nil {
+				t.Fatal(err)
+			}
+			messages = append(messages, m)
+		default:
+			return
+		}
+	}
+}
+
+func TestSpectator(t *testing.T) {
+	room := newTestRoom(t, 1000, 1000)
+	room.Occupants[0].Cards = cards("SA", "HA")
+	room.Occupants[1].Cards = cards("SK", "HK")
+
+	s := newTestOccupant("s", 0)
+	if err := s.Watch(room); err != nil {
+		t.Fatal(err)
+	}
+	if room.Spectators != 1 || room.N != 2 || s.Pos != 0 || s.player != nil {
+		t.Fatal("spectator took a seat")
+	}
+
+	room.Broadcast(&Message{Type: MsgPresence, Action: ActPot, Room: room})
+	messages := received(t, s)
+	if len(messages) != 2 || messages[0].Action != ActState || messages[1].Action != ActPot {
+		t.Fatalf("spectator got %d messages", len(messages))
+	}
+	for _, m := range messages {
+		for _, o := range m.Room.Occupants {
+			if o != nil && len(o.Cards) > 0 {
+				t.Fatalf("spectator sees %s's cards", o.Id)
+			}
+		}
+		if m.Room.Occupants[0].Id != "a" || m.Room.Occupants[1].Chips != 1000 {
+			t.Fatal("seat map missing")
+		}
+	}
+
+	if err := room.Occupants[0].Watch(room); err != errSeated {
+		t.Fatalf("seated occupant watching: %v", err)
+	}
+
+	s.Unwatch()
+	room.Broadcast(&Message{Type: MsgPresence, Action: ActPot})
+	if len(received(t, s)) != 0 || room.Spectators != 0 {
+		t.Fatal("message sent after unwatch")
+	}
+}
+
+func TestSpectatorCap(t *testing.T) {
+	room := newTestRoom(t, 1000, 1000)
+	room.MaxSpectators = 1
+
+	if err := newTestOccupant("s1", 0).Watch(room); err != nil {
+		t.Fatal(err)
+	}
+	if err := newTestOccupant("s2", 0).Watch(room); err != errSpectatorsFull {
+		t.Fatalf("watching a full room: %v", err)
+	}
+}
+
+// TestSpectatorCount is meant for -race: spectators come and go while the
+// room is sent to players.
+func TestSpectatorCount(t *testing.T) {
+	room := newTestRoom(t, 1000, 1000)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			s := newTestOccupant(fmt.Sprintf("s%d", i), 0)
+			room.AddSpectator(s)
+			room.DelSpectator(s)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		room.View(nil)
+		room.marshalProto()
+	}
+	<-done
+	if n := room.View(nil).Spectators; n != 0 {
+		t.Fatalf("%d spectators", n)
+	}
+}
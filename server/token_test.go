@@ -0,0 +1,98 @@
+package poker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// signClaims returns a token for claims signed by issuer.
+func signClaims(t *testing.T, issuer *TokenIssuer, claims *tokenClaims) string {
+	t.Helper()
+	b, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + issuer.sign(payload)
+}
+
+func TestTokenIssuer(t *testing.T) {
+	issuer := NewTokenIssuer([]byte("secret"), time.Hour)
+	token, err := issuer.Issue("0xa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address, err := issuer.Verify(token); err != nil || address != "0xa" {
+		t.Fatalf("verified %q, %v", address, err)
+	}
+
+	expired := signClaims(t, issuer, &tokenClaims{Address: "0xa", Expires: time.Now().Add(-time.Minute).Unix()})
+	if _, err := issuer.Verify(expired); err != errExpiredToken {
+		t.Fatalf("expired token: %v", err)
+	}
+
+	forged := signClaims(t, issuer, &tokenClaims{Address: "0xb", Expires: time.Now().Add(time.Hour).Unix()})
+	payload, mac, _ := strings.Cut(token, ".")
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+	tampered := []byte(token)
+	tampered[len(tampered)-1] ^= 1
+	for name, token := range map[string]string{
+		"tampered mac":     string(tampered),
+		"tampered claims":  forgedPayload + "." + mac,
+		"no mac":           payload,
+		"empty":            "",
+		"not a token":      "abc",
+		"no address":       signClaims(t, issuer, &tokenClaims{Expires: time.Now().Add(time.Hour).Unix()}),
+		"other secret":     signClaims(t, NewTokenIssuer([]byte("other"), time.Hour), &tokenClaims{Address: "0xa", Expires: time.Now().Add(time.Hour).Unix()}),
+		"random secret":    mustIssue(t, NewTokenIssuer(nil, 0), "0xa"),
+		"malformed claims": "e30." + issuer.sign("e30") + "x",
+	} {
+		if address, err := issuer.Verify(token); err != errBadToken {
+			t.Errorf("%s: verified %q, %v", name, address, err)
+		}
+	}
+}
+
+func mustIssue(t *testing.T, issuer *TokenIssuer, address string) string {
+	t.Helper()
+	token, err := issuer.Issue(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestRequireToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	p := &Poker{Tokens: NewTokenIssuer([]byte("secret"), time.Hour)}
+	r := p.router()
+
+	for name, c := range map[string]struct {
+		auth string
+		code int
+	}{
+		"missing token": {"", http.StatusUnauthorized},
+		"not bearer":    {mustIssue(t, p.Tokens, "0xa"), http.StatusUnauthorized},
+		"bad token":     {"Bearer abc", http.StatusUnauthorized},
+		"other secret":  {"Bearer " + mustIssue(t, NewTokenIssuer([]byte("other"), time.Hour), "0xa"), http.StatusUnauthorized},
+		"other address": {"Bearer " + mustIssue(t, p.Tokens, "0xb"), http.StatusForbidden},
+		"valid token":   {"Bearer " + mustIssue(t, p.Tokens, "0xa"), http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/reconnect/0xa", nil)
+		if c.auth != "" {
+			req.Header.Set("Authorization", c.auth)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != c.code {
+			t.Errorf("%s: status %d, want %d: %s", name, w.Code, c.code, w.Body)
+		}
+	}
+}
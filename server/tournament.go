@@ -0,0 +1,416 @@
+package poker
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	errTournamentStarted = errors.New("tournament already started")
+	errTournamentBuyIn   = errors.New("deposit is not the tournament buy-in")
+	errTournamentRebuy   = errors.New("no rebuys in a tournament")
+)
+
+// BlindLevel is a stage of a tournament's blind schedule.
+type BlindLevel struct {
+	SB      int `json:"sb"`
+	BB      int `json:"bb"`
+	Ante    int `json:"ante,omitempty"`
+	Minutes int `json:"minutes"` // the last level lasts until the end
+}
+
+// TournamentConfig is the structure of a sit-and-go: everyone buys in for
+// the same deposit and starts with the same stack, and the prize pool of
+// all buy-ins is paid out by finishing place.
+type TournamentConfig struct {
+	BuyIn  int          `json:"buy_in"`
+	Stack  int          `json:"stack"`
+	Levels []BlindLevel `json:"levels"`
+	// Payouts are the percentages of the prize pool paid to first place,
+	// second place and so on. They add up to 100.
+	Payouts []int `json:"payouts"`
+}
+
+// Validate checks the config for a tournament of max players.
+func (cfg *TournamentConfig) Validate(max int) error {
+	if cfg.BuyIn <= 0 || cfg.Stack <= 0 {
+		return fmt.Errorf("invalid buy-in %d or stack %d", cfg.BuyIn, cfg.Stack)
+	}
+	if len(cfg.Levels) == 0 {
+		return errors.New("no blind levels")
+	}
+	for i, l := range cfg.Levels {
+		if l.SB <= 0 || l.BB < l.SB || l.Ante < 0 || l.Minutes <= 0 && i < len(cfg.Levels)-1 {
+			return fmt.Errorf("invalid blind level %d", i+1)
+		}
+	}
+	if len(cfg.Payouts) == 0 || len(cfg.Payouts) > max {
+		return fmt.Errorf("invalid payouts for %d players", max)
+	}
+	total := 0
+	for _, p := range cfg.Payouts {
+		if p <= 0 {
+			return fmt.Errorf("invalid payout %d%%", p)
+		}
+		total += p
+	}
+	if total != 100 {
+		return fmt.Errorf("payouts add up to %d%%", total)
+	}
+	return nil
+}
+
+// TournamentResult is a player's finishing place and prize. Players busted
+// in the same hand with the same stack share a place, and the prizes of the
+// places they take.
+type TournamentResult struct {
+	Place int    `json:"place"`
+	Id    string `json:"id"`
+	Prize int    `json:"prize,omitempty"`
+}
+
+// Tournament is the state of a sit-and-go played in a room.
+type Tournament struct {
+	Config   *TournamentConfig `json:"config"`
+	Level    int               `json:"level"` // index in Config.Levels
+	Start    time.Time         `json:"start,omitempty"`
+	Entrants []string          `json:"entrants,omitempty"`
+	// Results are in order of elimination until the tournament is over,
+	// then by place.
+	Results  []*TournamentResult `json:"results,omitempty"`
+	Finished bool                `json:"finished,omitempty"`
+
+	stacks map[string]int // at the start of the current hand
+
+	// lock guards the fields above for views, which may be taken with the
+	// room's lock held. They change with both locks held.
+	lock sync.Mutex
+}
+
+// view returns a copy of the tournament that later hands do not change.
+func (t *Tournament) view() *Tournament {
+	if t == nil {
+		return nil
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	v := &Tournament{
+		Config:   t.Config,
+		Level:    t.Level,
+		Start:    t.Start,
+		Entrants: slices.Clone(t.Entrants),
+		Results:  make([]*TournamentResult, len(t.Results)),
+		Finished: t.Finished,
+	}
+	for i, r := range t.Results {
+		result := *r
+		v.Results[i] = &result
+	}
+	return v
+}
+
+// NewSitAndGo returns a room of max seats playing the tournament cfg. It
+// starts once every seat has bought in.
+func NewSitAndGo(id string, max int, cfg *TournamentConfig) *Room {
+	level := cfg.Levels[0]
+	room := NewRoom(id, max, level.SB, level.BB)
+	room.Ante = level.Ante
+	room.Rake = nil
+	room.Tournament = &Tournament{Config: cfg}
+	return room
+}
+
+// running reports whether the tournament has started and is not over.
+func (t *Tournament) running() bool {
+	return t != nil && !t.Start.IsZero() && !t.Finished
+}
+
+// level returns the blind level at now.
+func (t *Tournament) level(now time.Time) int {
+	elapsed := now.Sub(t.Start)
+	last := len(t.Config.Levels) - 1
+	for i, l := range t.Config.Levels[:last] {
+		d := time.Duration(l.Minutes) * time.Minute
+		if elapsed < d {
+			return i
+		}
+		elapsed -= d
+	}
+	return last
+}
+
+// prizes returns the prize of each place: the payout percentages of the
+// pool, with the chips lost rounding down going to first place.
+func (t *Tournament) prizes() []int {
+	pool := t.Config.BuyIn * len(t.Entrants)
+	prizes := make([]int, len(t.Config.Payouts))
+	paid := 0
+	for i, p := range t.Config.Payouts {
+		prizes[i] = pool * p / 100
+		paid += prizes[i]
+	}
+	prizes[0] += pool - paid
+	return prizes
+}
+
+// placeBusted returns the results of the players busted in one hand, in
+// order of elimination, with left players still in. Those who started the
+// hand with more chips, as in stacks, place higher; those who started it
+// level share the highest of their places.
+func placeBusted(busted []*Occupant, stacks map[string]int, left int) []*TournamentResult {
+	busted = slices.Clone(busted)
+	sort.SliceStable(busted, func(i, j int) bool {
+		return stacks[busted[i].Id] < stacks[busted[j].Id]
+	})
+	results := make([]*TournamentResult, len(busted))
+	var place int
+	for i := len(busted) - 1; i >= 0; i-- {
+		id := busted[i].Id
+		if i == len(busted)-1 || stacks[id] != stacks[busted[i+1].Id] {
+			place = left + len(busted) - i
+		}
+		results[i] = &TournamentResult{Place: place, Id: id}
+	}
+	return results
+}
+
+// payout orders the results by place, gives them their prizes and has s pay
+// them out of the prize pool. Players sharing a place split the prizes of
+// the places they take, the odd chips going to the first of them.
+//
+// Settlement chips are amounts of the game's pool, so a tournament settles
+// the prizes of the buy-ins, never the tournament stacks.
+func (t *Tournament) payout(s *Settlement) {
+	sort.SliceStable(t.Results, func(i, j int) bool {
+		return t.Results[i].Place < t.Results[j].Place
+	})
+	prizes := t.prizes()
+	for i := 0; i < len(t.Results); {
+		n := 1
+		for i+n < len(t.Results) && t.Results[i+n].Place == t.Results[i].Place {
+			n++
+		}
+		pool := 0
+		for place := t.Results[i].Place; place < t.Results[i].Place+n && place <= len(prizes); place++ {
+			pool += prizes[place-1]
+		}
+		for j, r := range t.Results[i : i+n] {
+			r.Prize = pool / n
+			if j == 0 {
+				r.Prize += pool % n
+			}
+		}
+		i += n
+	}
+	for _, r := range t.Results {
+		s.Players = append(s.Players, r.Id)
+		s.Chips = append(s.Chips, r.Prize)
+	}
+}
+
+// startTournamentHand starts the tournament once every seat has bought
+// in, and moves the blinds to the level of the clock. It reports whether a
+// hand may be dealt.
+func (room *Room) startTournamentHand() bool {
+	room.lock.Lock()
+	defer room.lock.Unlock()
+
+	t := room.Tournament
+	if t.Finished {
+		return false
+	}
+	if t.Start.IsZero() {
+		if room.N < room.Cap() {
+			return false
+		}
+		t.lock.Lock()
+		t.Start = time.Now()
+		room.Each(0, func(o *Occupant) bool {
+			t.Entrants = append(t.Entrants, o.Id)
+			return true
+		})
+		t.lock.Unlock()
+	}
+
+	if level := t.level(time.Now()); level != t.Level {
+		t.lock.Lock()
+		t.Level = level
+		t.lock.Unlock()
+		l := t.Config.Levels[level]
+		room.SB, room.BB, room.Ante = l.SB, l.BB, l.Ante
+		room.Broadcast(&Message{
+			From:   room.Id,
+			Type:   MsgPresence,
+			Action: ActLevel,
+			Class:  fmt.Sprintf("%d,%d,%d,%d", level+1, l.SB, l.BB, l.Ante),
+			Room:   room,
+		})
+	}
+
+	t.stacks = make(map[string]int)
+	room.Each(0, func(o *Occupant) bool {
+		t.stacks[o.Id] = o.Chips
+		return true
+	})
+	return true
+}
+
+// postAnte has o put the ante in the pot, dead.
+func (room *Room) postAnte(o *Occupant) {
+	room.lock.Lock()
+	defer room.lock.Unlock()
+
+	n := min(room.Ante, o.Chips)
+	if n <= 0 {
+		return
+	}
+	o.Chips -= n
+	room.Chips[o.Pos-1] += n
+	o.Action = ActAnte
+	if o.Chips == 0 {
+		o.Action = ActAllin
+		room.allin++
+	}
+	room.ledger.Transfer(room.hand, EntryAnte, PlayerAccount(o.Id), AccountPot, n)
+	if h := room.history; h != nil {
+		h.Actions = append(h.Actions, &HistoryAction{
+			Street: room.street(),
+			Pos:    o.Pos,
+			Id:     o.Id,
+			Action: HistoryAnte,
+			Amount: n,
+			AllIn:  o.Chips == 0,
+		})
+	}
+
+	room.Broadcast(&Message{
+		Id:     room.Id,
+		Type:   MsgPresence,
+		From:   o.Id,
+		Action: ActBet,
+		Class:  o.Action + "," + strconv.Itoa(o.Bet) + "," + strconv.Itoa(o.Chips),
+		BetEvent: &BetEvent{
+			Pos:    o.Pos,
+			Action: o.Action,
+			Bet:    o.Bet,
+			Chips:  o.Chips,
+		},
+	})
+}
+
+// eliminate removes the players who lost their last chip, placing those
+// who started the hand with more chips higher and those level together, and
+// ends the tournament once one player is left. At a multi-table tournament the director places
+// them in the whole field.
+func (room *Room) eliminate() {
+	t := room.Tournament
+	if !t.running() {
+		return
+	}
+
+	room.lock.Lock()
+	var busted []*Occupant
+	left := 0
+	room.Each(0, func(o *Occupant) bool {
+		if o.Chips == 0 {
+			busted = append(busted, o)
+		} else {
+			left++
+		}
+		return true
+	})
+	sort.SliceStable(busted, func(i, j int) bool {
+		return t.stacks[busted[i].Id] < t.stacks[busted[j].Id]
+	})
+	if d := room.director; d != nil {
+		room.lock.Unlock()
+		d.handDone(room, busted)
+		return
+	}
+	t.lock.Lock()
+	t.Results = append(t.Results, placeBusted(busted, t.stacks, left)...)
+	t.lock.Unlock()
+	room.lock.Unlock()
+
+	for _, o := range busted {
+		room.Broadcast(&Message{
+			From:     room.Id,
+			Type:     MsgPresence,
+			Action:   ActEliminated,
+			Class:    strconv.Itoa(room.place(o.Id)),
+			Occupant: o,
+		})
+		o.Leave()
+	}
+	if left <= 1 {
+		room.finishTournament()
+	}
+}
+
+// place returns the finishing place of the player id, or 0.
+func (room *Room) place(id string) int {
+	room.lock.Lock()
+	defer room.lock.Unlock()
+
+	for _, r := range room.Tournament.Results {
+		if r.Id == id {
+			return r.Place
+		}
+	}
+	return 0
+}
+
+// finishTournament places the last player first, pays the prizes on chain
+// and closes the room.
+func (room *Room) finishTournament() {
+	room.lock.Lock()
+	t := room.Tournament
+	t.lock.Lock()
+	room.Each(0, func(o *Occupant) bool {
+		t.Results = append(t.Results, &TournamentResult{Place: 1, Id: o.Id})
+		return true
+	})
+	s := room.settlement(nil, false)
+	t.payout(s)
+	t.Finished = true
+	t.lock.Unlock()
+	room.lock.Unlock()
+
+	room.Broadcast(&Message{
+		From:   room.Id,
+		Type:   MsgPresence,
+		Action: ActFinished,
+		Room:   room,
+	})
+	if err := room.enqueue(s); err != nil {
+		log.Println("tournament", s.GameID, err)
+	}
+	room.Close()
+}
+
+// leaveTournament takes o out of a tournament that has not started, giving
+// back its buy-in. Once started, players leaving keep their seat and are
+// blinded out.
+func (o *Occupant) leaveTournament(room *Room) {
+	t := room.Tournament
+	if !t.Start.IsZero() {
+		return
+	}
+
+	s := room.settlement(nil, true)
+	s.Players = []string{o.Id}
+	s.Chips = []int{t.Config.BuyIn}
+	room.ledger.Transfer(room.hand, EntryCashOut, PlayerAccount(o.Id), AccountCashier, o.Chips)
+	o.Leave()
+	if err := room.enqueue(s); err != nil {
+		log.Println("tournament", s.GameID, o.Id, err)
+	}
+}
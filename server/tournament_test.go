@@ -0,0 +1,229 @@
+package poker
+
+import (
+	"context"
+	"mental-poker/mental_poker"
+	"testing"
+	"time"
+)
+
+func testTournamentConfig() *TournamentConfig {
+	return &TournamentConfig{
+		BuyIn: 100,
+		Stack: 1500,
+		Levels: []BlindLevel{
+			{SB: 10, BB: 20, Minutes: 10},
+			{SB: 20, BB: 40, Minutes: 10},
+			{SB: 50, BB: 100, Ante: 10},
+		},
+		Payouts: []int{65, 35},
+	}
+}
+
+func newTestSitAndGo(t *testing.T, chips ...int) *Room {
+	room := NewSitAndGo(t.Name(), len(chips), testTournamentConfig())
+	stopRoom(t, room)
+	room.game = mental_poker.NewGame(room.Id, nil, "")
+	for i, c := range chips {
+		o := newTestOccupant(string(rune('a'+i)), c)
+		o.SetPlayer(mental_poker.NewPlayer(room.game))
+		room.AddOccupant(o)
+	}
+	return room
+}
+
+func TestTournamentConfig(t *testing.T) {
+	if err := testTournamentConfig().Validate(3); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []func(cfg *TournamentConfig){
+		func(cfg *TournamentConfig) { cfg.Stack = 0 },
+		func(cfg *TournamentConfig) { cfg.Levels = nil },
+		func(cfg *TournamentConfig) { cfg.Levels[0].Minutes = 0 },
+		func(cfg *TournamentConfig) { cfg.Levels[1].BB = 10 },
+		func(cfg *TournamentConfig) { cfg.Payouts = []int{50, 30} },
+		func(cfg *TournamentConfig) { cfg.Payouts = []int{50, 30, 10, 10} },
+	} {
+		cfg := testTournamentConfig()
+		f(cfg)
+		if err := cfg.Validate(3); err == nil {
+			t.Errorf("invalid config accepted: %+v", cfg)
+		}
+	}
+}
+
+func TestTournamentRegistration(t *testing.T) {
+	local, _ := NewLocalSettler("")
+	SetSettler(local)
+	defer SetSettler(nil)
+	ob, _ := NewOutbox(local, "")
+	old := outbox
+	SetOutbox(ob)
+	defer SetOutbox(old)
+
+	room := newTestSitAndGo(t, 0, 0, 0)
+	room.DelOccupant(room.Occupants[1])
+	room.DelOccupant(room.Occupants[2])
+	for _, d := range []*Deposit{
+		{Id: "0xt1", Player: "x", Chips: 50},
+		{Id: "0xt2", Player: "x", Chips: 100},
+	} {
+		d.GameID = room.Id
+		local.AddDeposit(d)
+	}
+
+	x := newTestOccupant("x", 0)
+	if err := x.BuyIn(room, "0xt1", 0); err != errTournamentBuyIn {
+		t.Fatalf("bought in for another amount: %v", err)
+	}
+	if err := x.BuyIn(room, "0xt2", 0); err != nil {
+		t.Fatal(err)
+	}
+	if x.Chips != 1500 || room.N != 2 {
+		t.Fatalf("x registered with %d chips", x.Chips)
+	}
+	if room.startTournamentHand() || !room.Tournament.Start.IsZero() {
+		t.Fatal("tournament started before the table filled")
+	}
+	if err := x.Rebuy(room, "0xt1"); err != errTournamentRebuy {
+		t.Fatalf("rebuy in a tournament: %v", err)
+	}
+
+	// leaving before the start gives back the buy-in
+	x.CashOut()
+	ob.Process(context.Background())
+	settlements := local.Settlements()
+	if room.Occupant("x") != nil || len(settlements) != 1 || !settlements[0].CashOut || settlements[0].Chips[0] != 100 {
+		t.Fatalf("unregistering: %+v", settlements)
+	}
+}
+
+func TestTournamentLevels(t *testing.T) {
+	room := newTestSitAndGo(t, 1500, 1500, 1500)
+	if !room.startTournamentHand() {
+		t.Fatal("full table not started")
+	}
+	tm := room.Tournament
+	if len(tm.Entrants) != 3 || tm.Level != 0 || room.BB != 20 {
+		t.Fatalf("started at level %d with %d entrants", tm.Level, len(tm.Entrants))
+	}
+
+	tm.Start = time.Now().Add(-25 * time.Minute)
+	room.startTournamentHand()
+	if tm.Level != 2 || room.SB != 50 || room.BB != 100 || room.Ante != 10 {
+		t.Fatalf("level %d, blinds %d/%d ante %d after 25 minutes", tm.Level+1, room.SB, room.BB, room.Ante)
+	}
+	messages := received(t, room.Occupants[0])
+	if len(messages) != 1 || messages[0].Action != ActLevel || messages[0].Class != "3,50,100,10" {
+		t.Fatal("table not told of the new level")
+	}
+
+	room.Occupants[1].Chips = 5
+	room.postAnte(room.Occupants[0])
+	room.postAnte(room.Occupants[1])
+	if room.Occupants[0].Chips != 1490 || room.Occupants[1].Action != ActAllin || room.allin != 1 || room.Chips[1] != 5 {
+		t.Fatal("antes not posted")
+	}
+	if room.ledger.Balance(AccountPot) != 15 {
+		t.Fatal("antes not in the ledger")
+	}
+}
+
+func TestTournamentResults(t *testing.T) {
+	local, _ := NewLocalSettler("")
+	ob, _ := NewOutbox(local, "")
+	old := outbox
+	SetOutbox(ob)
+	defer SetOutbox(old)
+
+	room := newTestSitAndGo(t, 1500, 1500, 1500, 1500)
+	room.startTournamentHand()
+	a, b, c, d := room.Occupants[0], room.Occupants[1], room.Occupants[2], room.Occupants[3]
+
+	// a busts first
+	a.Chips, b.Chips = 0, 3000
+	room.checkAndEndGame()
+	if room.Occupant("a") != nil || room.place("a") != 4 {
+		t.Fatalf("a eliminated in place %d", room.place("a"))
+	}
+	messages := received(t, b)
+	if len(messages) != 2 || messages[0].Action != ActEliminated || messages[0].Class != "4" {
+		t.Fatal("table not told of the elimination")
+	}
+
+	// b and c bust in the same hand; b started it with more chips
+	view := room.View(nil).Tournament
+	room.Tournament.stacks = map[string]int{"b": 3000, "c": 1500, "d": 1500}
+	b.Chips, c.Chips, d.Chips = 0, 0, 6000
+	room.checkAndEndGame()
+	if len(view.Results) != 1 || view.Finished {
+		t.Fatal("view changed by later hands")
+	}
+	ob.Process(context.Background())
+
+	results := room.Tournament.Results
+	if !room.Tournament.Finished || len(results) != 4 {
+		t.Fatalf("%d results", len(results))
+	}
+	want := []TournamentResult{{1, "d", 260}, {2, "b", 140}, {3, "c", 0}, {4, "a", 0}}
+	for i, r := range results {
+		if *r != want[i] {
+			t.Errorf("place %d: %+v, want %+v", i+1, r, want[i])
+		}
+	}
+	settlements := local.Settlements()
+	if len(settlements) != 1 || settlements[0].CashOut || len(settlements[0].Players) != 4 || settlements[0].Chips[0] != 260 {
+		t.Fatalf("settlements %+v", settlements)
+	}
+	paid := 0
+	for _, chips := range settlements[0].Chips {
+		paid += chips
+	}
+	if paid != 400 {
+		t.Fatalf("paid %d of a prize pool of 400", paid)
+	}
+	if room.Occupant("d") != nil || RoomExist(t.Name()) {
+		t.Fatal("room not closed")
+	}
+}
+
+func TestTournamentTies(t *testing.T) {
+	local, _ := NewLocalSettler("")
+	ob, _ := NewOutbox(local, "")
+	old := outbox
+	SetOutbox(ob)
+	defer SetOutbox(old)
+
+	room := newTestSitAndGo(t, 1500, 1500, 1500)
+	room.startTournamentHand()
+	a, b, c := room.Occupants[0], room.Occupants[1], room.Occupants[2]
+
+	// b and c bust in the same hand, level: they share second place
+	a.Chips, b.Chips, c.Chips = 4500, 0, 0
+	room.checkAndEndGame()
+	eliminated := 0
+	for _, m := range received(t, a) {
+		if m.Action == ActEliminated {
+			if m.Class != "2" {
+				t.Fatalf("%s eliminated in place %s", m.Occupant.Id, m.Class)
+			}
+			eliminated++
+		}
+	}
+	if eliminated != 2 {
+		t.Fatalf("table told of %d eliminations", eliminated)
+	}
+	ob.Process(context.Background())
+
+	// the prizes of second and third place, 105 and 0, are split
+	want := []TournamentResult{{1, "a", 195}, {2, "b", 53}, {2, "c", 52}}
+	results := room.Tournament.Results
+	if len(results) != len(want) {
+		t.Fatalf("%d results", len(results))
+	}
+	for i, r := range results {
+		if *r != want[i] {
+			t.Errorf("result %d: %+v, want %+v", i+1, r, want[i])
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package poker
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"sync"
+)
+
+// Transcript is a running hash of the mental poker protocol messages of a
+// game: the deck seed, every shuffle with its proof and every reveal token.
+// Its digest is submitted with the settlement as the game's proof.
+type Transcript struct {
+	lock sync.Mutex
+	h    hash.Hash
+}
+
+func newTranscript() *Transcript {
+	return &Transcript{
+		h: sha256.New(),
+	}
+}
+
+// Add appends a record. Each part is length-prefixed so records cannot be
+// confused with one another.
+func (t *Transcript) Add(kind string, parts ...string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var n [8]byte
+	for _, part := range append([]string{kind}, parts...) {
+		binary.BigEndian.PutUint64(n[:], uint64(len(part)))
+		t.h.Write(n[:])
+		t.h.Write([]byte(part))
+	}
+}
+
+// Digest returns the hex encoded hash of everything added so far.
+func (t *Transcript) Digest() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return hex.EncodeToString(t.h.Sum(nil))
+}
@@ -0,0 +1,94 @@
+package poker
+
+import (
+	"slices"
+)
+
+// View returns the message as seen by viewer. Any embedded room or occupant
+// is replaced by a snapshot that only carries the cards viewer may see.
+func (message *Message) View(viewer *Occupant) *Message {
+	if message.Room == nil && message.Occupant == nil {
+		return message
+	}
+
+	m := *message
+	if m.Room != nil {
+		m.Room = m.Room.View(viewer)
+	}
+	if m.Occupant != nil {
+		m.Occupant = m.Occupant.View(viewer)
+	}
+	return &m
+}
+
+// View returns a snapshot of the room for viewer, which later hands do not
+// change. Viewer sees its own cards; other occupants' cards are hidden unless
+// they were shown at showdown. A nil viewer, or one not seated in the room,
+// only sees shown cards.
+func (room *Room) View(viewer *Occupant) *Room {
+	r := &Room{
+		Id:        room.Id,
+		SB:        room.SB,
+		BB:        room.BB,
+		Cards:     slices.Clone(room.Cards),
+		Pot:       slices.Clone(room.Pot),
+		Timeout:   room.Timeout,
+		Button:    room.Button,
+		Occupants: make([]*Occupant, len(room.Occupants)),
+		Chips:     slices.Clone(room.Chips),
+		Bet:       room.Bet,
+		N:         room.N,
+		Max:       room.Max,
+		MaxChips:  room.MaxChips,
+		MinChips:  room.MinChips,
+		Rake:      room.Rake,
+
+		MaxSpectators: room.MaxSpectators,
+		Spectators:    room.spectatorCount(),
+		MaxTimeouts:   room.MaxTimeouts,
+
+		Ante:       room.Ante,
+		Tournament: room.Tournament.view(),
+	}
+	for i, o := range room.Occupants {
+		if o != nil {
+			r.Occupants[i] = o.View(viewer)
+		}
+	}
+	return r
+}
+
+// View returns a copy of the occupant as seen by viewer.
+func (o *Occupant) View(viewer *Occupant) *Occupant {
+	occupant := *o
+	occupant.Cards = slices.Clone(o.Cards)
+	occupant.RevealCards = slices.Clone(o.RevealCards)
+	if viewer != nil && viewer.Id == o.Id {
+		return &occupant
+	}
+
+	occupant.RevealCards = nil
+	if o.Room == nil || !o.Room.shownCards()[o.Pos] {
+		occupant.Cards = nil
+		occupant.Hand = 0
+	}
+	return &occupant
+}
+
+// setShown records the positions whose cards were tabled at showdown, nil
+// for none.
+func (room *Room) setShown(shown map[int]bool) {
+	room.watchLock.Lock()
+	defer room.watchLock.Unlock()
+
+	room.shown = shown
+}
+
+// shownCards returns the positions whose cards were tabled at showdown. The
+// map is never changed once set.
+func (room *Room) shownCards() map[int]bool {
+	room.watchLock.Lock()
+	defer room.watchLock.Unlock()
+
+	return room.shown
+}
@@ -0,0 +1,75 @@
+package poker
+
+import (
+	"testing"
+)
+
+func TestRoomView(t *testing.T) {
+	room := newTestRoom(t, 1000, 1000)
+	a, b := room.Occupants[0], room.Occupants[1]
+	a.Cards, a.Hand = cards("SA", "HA"), 300
+	b.Cards, b.Hand = cards("SK", "HK"), 200
+	s := newTestOccupant("s", 0)
+	if err := s.Watch(room); err != nil {
+		t.Fatal(err)
+	}
+
+	hidden := func(r *Room, pos int) bool {
+		o := r.Occupants[pos-1]
+		return o.Cards == nil && o.Hand == 0 && o.RevealCards == nil
+	}
+
+	// before showdown each player only sees its own cards
+	v := room.View(a)
+	if hidden(v, a.Pos) || !hidden(v, b.Pos) {
+		t.Fatal("a sees the wrong cards before showdown")
+	}
+	for _, viewer := range []*Occupant{s, nil} {
+		if v := room.View(viewer); !hidden(v, a.Pos) || !hidden(v, b.Pos) {
+			t.Fatalf("%v sees cards before showdown", viewer)
+		}
+	}
+
+	// at showdown b mucks: only a's cards are tabled
+	room.setShown(map[int]bool{a.Pos: true})
+	v = room.View(b)
+	if hidden(v, a.Pos) || hidden(v, b.Pos) {
+		t.Fatal("b does not see a's shown cards")
+	}
+	for _, viewer := range []*Occupant{s, nil} {
+		if v := room.View(viewer); hidden(v, a.Pos) || !hidden(v, b.Pos) {
+			t.Fatalf("%v sees the wrong cards at showdown", viewer)
+		}
+	}
+	if a.Cards == nil || b.Cards == nil {
+		t.Fatal("view changed the room")
+	}
+
+	// the next hand hides them again
+	room.setShown(nil)
+	if v := room.View(b); !hidden(v, a.Pos) {
+		t.Fatal("a's cards still shown")
+	}
+}
+
+func TestMessageView(t *testing.T) {
+	room := newTestRoom(t, 1000, 1000)
+	a, b := room.Occupants[0], room.Occupants[1]
+	a.Cards, b.Cards = cards("SA", "HA"), cards("SK", "HK")
+
+	m := &Message{Action: ActJoin, Occupant: a, Room: room}
+	if v := m.View(b); v.Occupant.Cards != nil || v.Room.Occupants[0].Cards != nil {
+		t.Fatal("b sees a's cards in a message")
+	}
+	if v := m.View(a); v.Occupant.Cards == nil || v.Room.Occupants[0].Cards == nil {
+		t.Fatal("a does not see its own cards")
+	}
+	if v := m.View(nil); v.Occupant.Cards != nil || m.Occupant != a {
+		t.Fatal("message not redacted for a nil viewer")
+	}
+
+	plain := &Message{Action: ActBet}
+	if plain.View(b) != plain {
+		t.Fatal("message without state copied")
+	}
+}
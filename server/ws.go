@@ -5,6 +5,7 @@ import (
 	"github.com/gorilla/websocket"
 	"log"
 	"net/http"
+	"strconv"
 )
 
 const (
@@ -22,14 +23,37 @@ const (
 	ActRiver    = "river"
 	ActShowdown = "showdown"
 	ActPot      = "pot"
+	ActMuck     = "muck"
 
 	ActActive    = "active"
 	ActJoin      = "join"
 	ActReconnect = "reconnect"
+	ActResume    = "resume"
 	ActLeave     = "gone"
 	ActBet       = "bet"
 	ActButton    = "button"
 	ActState     = "state"
+	ActWatch     = "watch"
+	ActUnwatch   = "unwatch"
+	ActReserve   = "reserve"
+	ActWait      = "wait"
+	ActUnwait    = "unwait"
+	ActOffer     = "offer"
+	ActSitOut    = "sitout"
+	ActSitIn     = "sitin"
+	ActRebuy     = "rebuy"
+	ActAutoRebuy = "autorebuy"
+
+	ActAnte       = "ante"
+	ActLevel      = "level"      // the tournament blinds went up
+	ActEliminated = "eliminated" // from the tournament, class the place
+	ActFinished   = "finished"   // the tournament, with the results
+
+	ActRegister    = "register"   // for a multi-table tournament
+	ActUnregister  = "unregister" // before it starts
+	ActMove        = "move"       // to another table, class its room id
+	ActHandForHand = "handforhand"
+	ActFinalTable  = "finaltable"
 
 	ActAction = "action"
 	ActReady  = "ready"
@@ -59,23 +83,42 @@ type Message struct {
 	Room     *Room     `json:"room,omitempty"`
 	Rooms    []*Room   `json:"rooms,omitempty"`
 	Chips    int       `json:"chips,omitempty"`
+	Seq      uint64    `json:"seq,omitempty"`
+	Deposit  string    `json:"deposit,omitempty"` // buy-in deposit object id, for join
+
+	Hands []*ShownHand `json:"hands,omitempty"`
+	Rake  int          `json:"rake,omitempty"` // taken at showdown
+
+	// typed payloads, ProtoV2 only
+	Deal     *DealEvent    `json:"deal,omitempty"`
+	BetEvent *BetEvent     `json:"bet,omitempty"`
+	Prompt   *ActionPrompt `json:"prompt,omitempty"`
+	Pots     *PotEvent     `json:"pots,omitempty"`
+	Act      *BetRequest   `json:"act,omitempty"` // from the client
+
+	Error *Error `json:"-"` // on proto connections; JSON ones get the bare Error
 }
 
 type Version struct {
 	//Id  string `json:"id"`
-	Ver string `json:"version"`
+	Ver      string `json:"version"`
+	Encoding string `json:"encoding,omitempty"`
 }
 
 type Auth struct {
 	Mechanism string `json:"mechanism"`
 	Text      string `json:"text"`
+	LastSeq   uint64 `json:"last_seq,omitempty"` // last message seq seen, to resume a session
 }
 
 type AuthResp struct {
-	Id    string `json:"id"`
-	Name  string `json:"name"`
-	Level int    `json:"level"`
-	Chips int    `json:"chips"`
+	Id      string `json:"id"`
+	Name    string `json:"name"`
+	Level   int    `json:"level"`
+	Chips   int    `json:"chips"`
+	Address string `json:"address,omitempty"` // verified Sui address, AuthSui and AuthToken only
+	Token   string `json:"token,omitempty"`   // session token for reconnecting with AuthToken
+	Error   string `json:"error,omitempty"`
 }
 
 type Error struct {
@@ -104,7 +147,11 @@ func handlePresence(o *Occupant, message *Message) {
 		if room == nil {
 			log.Panic("room not found", message.To)
 		}
-		o.JoinRoom(room, message.Chips)
+		// class: the seat to take, any free one if empty
+		pos, _ := strconv.Atoi(message.Class)
+		if err := o.BuyIn(room, message.Deposit, pos); err != nil {
+			o.SendError(2, err.Error())
+		}
 	//if room := o.Join(message.To); room == nil {
 	//	o.SendError(1, "room not found")
 	//	return
@@ -117,9 +164,109 @@ func handlePresence(o *Occupant, message *Message) {
 		if room == nil {
 			log.Panic("room not found", message.To)
 		}
-		o.JoinRoom(room, message.Chips)
+		if err := o.BuyIn(room, message.Deposit, 0); err != nil {
+			o.SendError(2, err.Error())
+		}
+	case ActResume:
+		// class: the last message seq the client has seen
+		if last, err := strconv.ParseUint(message.Class, 10, 64); err == nil {
+			o.Resume(last)
+		}
+	case ActWatch:
+		room := lookupRoom(message.To)
+		if room == nil {
+			o.SendError(1, "room not found")
+			return
+		}
+		if err := o.Watch(room); err != nil {
+			o.SendError(2, err.Error())
+		}
+	case ActUnwatch:
+		o.Unwatch()
+	case ActReserve:
+		// class: the seat to hold while buying in, any free one if empty
+		room := lookupRoom(message.To)
+		if room == nil {
+			o.SendError(1, "room not found")
+			return
+		}
+		pos, _ := strconv.Atoi(message.Class)
+		pos, err := room.Reserve(o, pos)
+		if err != nil {
+			o.SendError(2, err.Error())
+			return
+		}
+		o.SendMessage(&Message{
+			From:   room.Id,
+			Type:   MsgPresence,
+			Action: ActReserve,
+			Class:  strconv.Itoa(pos),
+		})
+	case ActWait:
+		room := lookupRoom(message.To)
+		if room == nil {
+			o.SendError(1, "room not found")
+			return
+		}
+		place, err := room.Wait(o)
+		if err != nil {
+			o.SendError(2, err.Error())
+			return
+		}
+		if place > 0 {
+			o.SendMessage(&Message{
+				From:   room.Id,
+				Type:   MsgPresence,
+				Action: ActWait,
+				Class:  strconv.Itoa(place),
+			})
+		}
+	case ActUnwait:
+		if room := lookupRoom(message.To); room != nil {
+			room.Unwait(o)
+		}
+	case ActSitOut:
+		o.SitOut()
+	case ActSitIn:
+		// class: "wait" to wait for the big blind instead of posting
+		o.SitIn(message.Class == "wait")
+	case ActRebuy:
+		// deposit: escrowing the chips to add before the next hand
+		if room := o.Room; room == nil {
+			o.SendError(2, errNotSeated.Error())
+		} else if err := o.Rebuy(room, message.Deposit); err != nil {
+			o.SendError(2, err.Error())
+		}
+	case ActAutoRebuy:
+		// deposit: escrowing the chips to buy back in with, none to turn off
+		if room := o.Room; room == nil {
+			o.SendError(2, errNotSeated.Error())
+		} else if err := o.AutoRebuy(room, message.Deposit); err != nil {
+			o.SendError(2, err.Error())
+		}
+	case ActRegister:
+		// to: the tournament, deposit: escrowing its buy-in
+		if d := GetDirector(message.To); d == nil {
+			o.SendError(1, errUnknownDirector.Error())
+		} else if err := d.Register(o, message.Deposit); err != nil {
+			o.SendError(2, err.Error())
+		}
+	case ActUnregister:
+		if d := GetDirector(message.To); d == nil {
+			o.SendError(1, errUnknownDirector.Error())
+		} else if err := d.Unregister(o); err != nil {
+			o.SendError(2, err.Error())
+		}
 	case ActLeave:
-		o.Leave()
+		o.CashOut()
+	case ActMuck:
+		// class: "true" to muck losing hands at showdown, "false" to always
+		// show; "muck" or "show" for the current hand only
+		if autoMuck, err := strconv.ParseBool(message.Class); err == nil {
+			o.AutoMuck = autoMuck
+		} else if message.Class == "muck" || message.Class == "show" {
+			o.MuckHand(message.Class == "muck")
+		}
 	case ActBet:
 		select {
 		case o.Actions <- message: